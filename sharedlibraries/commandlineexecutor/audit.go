@@ -0,0 +1,317 @@
+/*
+Copyright 2025 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package commandlineexecutor
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/user"
+	"regexp"
+	"sync"
+	"time"
+
+	"github.com/GoogleCloudPlatform/workloadagentplatform/sharedlibraries/log"
+)
+
+// redacted replaces secret values in audit records.
+const redacted = "<redacted>"
+
+var (
+	auditLock sync.Mutex
+	auditSink *auditLog
+
+	// secretKey matches the names of flags and variables which hold secret values.
+	secretKey = `[\w.-]*(?:password|passwd|pwd|secret|token|api[_-]?key|credentials?)[\w.-]*`
+	// secretAssignment matches a secret value assigned with "=" or ":", or given as the argument
+	// following a secret flag, within a single argument such as a "-c" script.
+	secretAssignment = regexp.MustCompile(`(?i)((?:\b` + secretKey + `["']?\s*[=:]\s*)|(?:(?:^|\s)--?` + secretKey + `\s+))("[^"]*"|'[^']*'|[^\s;&|]+)`)
+	// secretFlag matches an argument which is a secret flag whose value is the next argument.
+	secretFlag = regexp.MustCompile(`(?i)^--?` + secretKey + `$`)
+
+	currentUser = sync.OnceValue(func() string {
+		u, err := user.Current()
+		if err != nil {
+			return ""
+		}
+		return u.Username
+	})
+)
+
+type (
+	// AuditParameters for setting up the command audit log.
+	AuditParameters struct {
+		// AuditFileName is the path of the audit file. Records are appended to the file, which is
+		// created with owner only permissions if it does not exist.
+		AuditFileName string
+		// RedactPatterns are regular expressions whose matches in the arguments of a command are
+		// redacted, in addition to values assigned to flags and variables named like passwords,
+		// secrets, tokens and keys.
+		RedactPatterns []string
+	}
+
+	/*
+		AuditRecord is the record of one command execution written as a line of JSON to the audit file.
+
+		Records are chained to make tampering evident: Hash is the SHA-256 of PrevHash followed by the
+		JSON encoding of the record without Hash, and PrevHash is the Hash of the previous record in
+		the file. VerifyAuditLog checks the chain.
+	*/
+	AuditRecord struct {
+		Sequence    uint64    `json:"sequence"`
+		Executable  string    `json:"executable"`
+		Args        []string  `json:"args,omitempty"`
+		ArgsToSplit string    `json:"args_to_split,omitempty"`
+		User        string    `json:"user"`
+		StartTime   time.Time `json:"start_time"`
+		EndTime     time.Time `json:"end_time"`
+		ExitCode    int       `json:"exit_code"`
+		Error       string    `json:"error,omitempty"`
+		TimedOut    bool      `json:"timed_out,omitempty"`
+		// StdOutHash and StdErrHash are the hex encoded SHA-256 of the output kept in the Result.
+		StdOutHash string `json:"stdout_hash"`
+		StdErrHash string `json:"stderr_hash"`
+		PrevHash   string `json:"prev_hash"`
+		Hash       string `json:"hash"`
+	}
+
+	// auditLog appends chained AuditRecords to a file.
+	auditLog struct {
+		mu       sync.Mutex
+		file     *os.File
+		redact   []*regexp.Regexp
+		sequence uint64
+		lastHash string
+	}
+)
+
+/*
+SetupAuditLog starts writing an AuditRecord for every command run with ExecuteCommand to the
+file in params.AuditFileName. The chain of records continues from the last record already in the
+file. Any audit log set up previously is closed.
+*/
+func SetupAuditLog(params AuditParameters) error {
+	var redact []*regexp.Regexp
+	for _, p := range params.RedactPatterns {
+		re, err := regexp.Compile(p)
+		if err != nil {
+			return fmt.Errorf("invalid redact pattern %q: %w", p, err)
+		}
+		redact = append(redact, re)
+	}
+	last, err := lastAuditRecord(params.AuditFileName)
+	if err != nil {
+		return err
+	}
+	file, err := os.OpenFile(params.AuditFileName, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return fmt.Errorf("could not open audit file: %w", err)
+	}
+	a := &auditLog{file: file, redact: redact}
+	if last != nil {
+		a.sequence, a.lastHash = last.Sequence, last.Hash
+	}
+
+	auditLock.Lock()
+	defer auditLock.Unlock()
+	if auditSink != nil {
+		auditSink.file.Close()
+	}
+	auditSink = a
+	return nil
+}
+
+// CloseAuditLog stops writing audit records and closes the audit file.
+func CloseAuditLog() error {
+	auditLock.Lock()
+	defer auditLock.Unlock()
+	if auditSink == nil {
+		return nil
+	}
+	err := auditSink.file.Close()
+	auditSink = nil
+	return err
+}
+
+/*
+VerifyAuditLog reads the audit file at path and checks that every record is intact and chained to
+the record before it. It returns an error identifying the first record that was modified, removed
+or inserted out of sequence.
+*/
+func VerifyAuditLog(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	prevHash := ""
+	var sequence uint64
+	scanner := newAuditScanner(f)
+	for line := 1; scanner.Scan(); line++ {
+		var r AuditRecord
+		if err := json.Unmarshal(scanner.Bytes(), &r); err != nil {
+			return fmt.Errorf("audit record at line %d is not valid: %w", line, err)
+		}
+		if r.Sequence != sequence+1 {
+			return fmt.Errorf("audit record at line %d has sequence %d, want %d", line, r.Sequence, sequence+1)
+		}
+		if r.PrevHash != prevHash {
+			return fmt.Errorf("audit record at line %d is not chained to the previous record", line)
+		}
+		if want, err := r.computeHash(); err != nil || r.Hash != want {
+			return fmt.Errorf("audit record at line %d has been modified", line)
+		}
+		sequence, prevHash = r.Sequence, r.Hash
+	}
+	return scanner.Err()
+}
+
+// audit writes the record of an execution to the audit log if one is set up.
+func audit(ctx context.Context, params Params, start, end time.Time, result Result) {
+	auditLock.Lock()
+	a := auditSink
+	auditLock.Unlock()
+	if a == nil {
+		return
+	}
+	r := &AuditRecord{
+		Executable:  params.Executable,
+		Args:        a.redactArgs(params.Args),
+		ArgsToSplit: a.redactString(params.ArgsToSplit),
+		User:        params.User,
+		StartTime:   start.UTC(),
+		EndTime:     end.UTC(),
+		ExitCode:    result.ExitCode,
+		TimedOut:    result.TimedOut,
+		StdOutHash:  hashString(result.StdOut),
+		StdErrHash:  hashString(result.StdErr),
+	}
+	if r.User == "" {
+		r.User = currentUser()
+	}
+	if result.Error != nil {
+		r.Error = result.Error.Error()
+	}
+	if err := a.write(r); err != nil {
+		log.CtxLogger(ctx).Warnw("Could not write command audit record", "executable", params.Executable, "error", err)
+	}
+}
+
+// write chains the record to the previous record and appends it to the audit file.
+func (a *auditLog) write(r *AuditRecord) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	r.Sequence = a.sequence + 1
+	r.PrevHash = a.lastHash
+	hash, err := r.computeHash()
+	if err != nil {
+		return err
+	}
+	r.Hash = hash
+	data, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+	if _, err := a.file.Write(append(data, '\n')); err != nil {
+		return err
+	}
+	a.sequence, a.lastHash = r.Sequence, r.Hash
+	return nil
+}
+
+// redactArgs redacts secret values in args, including the argument following a secret flag.
+func (a *auditLog) redactArgs(args []string) []string {
+	if len(args) == 0 {
+		return nil
+	}
+	out := make([]string, len(args))
+	for i, arg := range args {
+		if i > 0 && secretFlag.MatchString(args[i-1]) {
+			out[i] = redacted
+			continue
+		}
+		out[i] = a.redactString(arg)
+	}
+	return out
+}
+
+// redactString redacts secret values and matches of the redact patterns in s.
+func (a *auditLog) redactString(s string) string {
+	s = secretAssignment.ReplaceAllString(s, "${1}"+redacted)
+	for _, re := range a.redact {
+		s = re.ReplaceAllString(s, redacted)
+	}
+	return s
+}
+
+// computeHash returns the chained hash of the record, ignoring its current Hash.
+func (r AuditRecord) computeHash() (string, error) {
+	r.Hash = ""
+	data, err := json.Marshal(r)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(append([]byte(r.PrevHash), data...))
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// lastAuditRecord returns the last record in the audit file, or nil if it has none.
+func lastAuditRecord(path string) (*AuditRecord, error) {
+	f, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("could not read audit file: %w", err)
+	}
+	defer f.Close()
+	var last []byte
+	scanner := newAuditScanner(f)
+	for scanner.Scan() {
+		last = append(last[:0], scanner.Bytes()...)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("could not read audit file: %w", err)
+	}
+	if len(bytes.TrimSpace(last)) == 0 {
+		return nil, nil
+	}
+	r := &AuditRecord{}
+	if err := json.Unmarshal(last, r); err != nil {
+		return nil, fmt.Errorf("could not parse the last record of audit file: %w", err)
+	}
+	return r, nil
+}
+
+// newAuditScanner returns a line scanner which allows for records with long arguments.
+func newAuditScanner(f *os.File) *bufio.Scanner {
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	return scanner
+}
+
+// hashString returns the hex encoded SHA-256 of s.
+func hashString(s string) string {
+	sum := sha256.Sum256([]byte(s))
+	return hex.EncodeToString(sum[:])
+}
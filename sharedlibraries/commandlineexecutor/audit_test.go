@@ -0,0 +1,219 @@
+/*
+Copyright 2025 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package commandlineexecutor
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
+)
+
+func readAuditRecords(t *testing.T, path string) []AuditRecord {
+	t.Helper()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("os.ReadFile(%q) failed: %v", path, err)
+	}
+	var records []AuditRecord
+	for _, line := range strings.Split(strings.TrimSpace(string(data)), "\n") {
+		var r AuditRecord
+		if err := json.Unmarshal([]byte(line), &r); err != nil {
+			t.Fatalf("json.Unmarshal(%q) failed: %v", line, err)
+		}
+		records = append(records, r)
+	}
+	return records
+}
+
+func TestAuditLog(t *testing.T) {
+	setDefaults()
+	path := filepath.Join(t.TempDir(), "audit.log")
+	if err := SetupAuditLog(AuditParameters{AuditFileName: path, RedactPatterns: []string{`SAPPASS\d+`}}); err != nil {
+		t.Fatalf("SetupAuditLog() failed: %v", err)
+	}
+	defer CloseAuditLog()
+
+	ExecuteCommand(context.Background(), Params{Executable: "echo", Args: []string{"--password", "hunter2", "token=abc", "SAPPASS123"}})
+	ExecuteCommand(context.Background(), Params{Executable: "sh", ArgsToSplit: "-c 'echo login --api-key abc >&2; exit 3'"})
+	ExecuteCommand(context.Background(), Params{Executable: "nonexistent-executable"})
+
+	got := readAuditRecords(t, path)
+	want := []AuditRecord{
+		{
+			Sequence:   1,
+			Executable: "echo",
+			Args:       []string{"--password", redacted, "token=" + redacted, redacted},
+			ExitCode:   0,
+			StdOutHash: hashString("--password hunter2 token=abc SAPPASS123\n"),
+			StdErrHash: hashString(""),
+		},
+		{
+			Sequence:    2,
+			Executable:  "sh",
+			ArgsToSplit: "-c 'echo login --api-key " + redacted + " >&2; exit 3'",
+			ExitCode:    3,
+			Error:       "exit status 3",
+			StdOutHash:  hashString(""),
+			StdErrHash:  hashString("login --api-key abc\n"),
+		},
+		{
+			Sequence:   3,
+			Executable: "nonexistent-executable",
+			Error:      "command executable: nonexistent-executable not found",
+			StdOutHash: hashString(""),
+			StdErrHash: hashString(`Command executable: "nonexistent-executable" not found.`),
+		},
+	}
+	ignore := cmpopts.IgnoreFields(AuditRecord{}, "User", "StartTime", "EndTime", "PrevHash", "Hash")
+	if diff := cmp.Diff(want, got, ignore); diff != "" {
+		t.Errorf("ExecuteCommand() wrote unexpected audit records (-want +got):\n%s", diff)
+	}
+	for i, r := range got {
+		if r.User == "" || r.StartTime.IsZero() || r.EndTime.Before(r.StartTime) {
+			t.Errorf("Audit record %d has user: %q, start: %v, end: %v, want a user and ordered times", i, r.User, r.StartTime, r.EndTime)
+		}
+	}
+	if err := VerifyAuditLog(path); err != nil {
+		t.Errorf("VerifyAuditLog(%q) returned unexpected error: %v", path, err)
+	}
+
+	// Setting up the audit log again continues the chain from the last record.
+	if err := SetupAuditLog(AuditParameters{AuditFileName: path}); err != nil {
+		t.Fatalf("SetupAuditLog() failed: %v", err)
+	}
+	ExecuteCommand(context.Background(), Params{Executable: "true"})
+	got = readAuditRecords(t, path)
+	if last := got[len(got)-1]; last.Sequence != 4 || last.PrevHash != got[2].Hash {
+		t.Errorf("Audit record after reopening has sequence: %d, prev_hash: %q, want 4 and %q", last.Sequence, last.PrevHash, got[2].Hash)
+	}
+	if err := VerifyAuditLog(path); err != nil {
+		t.Errorf("VerifyAuditLog(%q) after reopening returned unexpected error: %v", path, err)
+	}
+}
+
+func TestVerifyAuditLog(t *testing.T) {
+	file, err := os.Create(filepath.Join(t.TempDir(), "audit.log"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	a := &auditLog{file: file}
+	for _, exe := range []string{"ls", "id", "df"} {
+		if err := a.write(&AuditRecord{Executable: exe}); err != nil {
+			t.Fatalf("write(%q) failed: %v", exe, err)
+		}
+	}
+	file.Close()
+	data, err := os.ReadFile(file.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+
+	tests := []struct {
+		name    string
+		lines   []string
+		wantErr string
+	}{
+		{
+			name:  "Intact",
+			lines: lines,
+		},
+		{
+			name:  "Empty",
+			lines: nil,
+		},
+		{
+			name:    "Modified",
+			lines:   []string{lines[0], strings.Replace(lines[1], `"id"`, `"rm"`, 1), lines[2]},
+			wantErr: "line 2 has been modified",
+		},
+		{
+			name:    "Removed",
+			lines:   []string{lines[0], lines[2]},
+			wantErr: "line 2 has sequence 3, want 2",
+		},
+		{
+			name:    "Truncated",
+			lines:   []string{lines[1], lines[2]},
+			wantErr: "line 1 has sequence 2, want 1",
+		},
+		{
+			name:    "NotJSON",
+			lines:   []string{lines[0], "garbage"},
+			wantErr: "line 2 is not valid",
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "audit.log")
+			if err := os.WriteFile(path, []byte(strings.Join(tc.lines, "\n")), 0600); err != nil {
+				t.Fatal(err)
+			}
+			err := VerifyAuditLog(path)
+			if tc.wantErr == "" && err != nil {
+				t.Errorf("VerifyAuditLog() returned unexpected error: %v", err)
+			}
+			if tc.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tc.wantErr)) {
+				t.Errorf("VerifyAuditLog() returned error: %v, want error containing %q", err, tc.wantErr)
+			}
+		})
+	}
+}
+
+func TestRedactArgs(t *testing.T) {
+	tests := []struct {
+		name string
+		args []string
+		want []string
+	}{
+		{
+			name: "NoSecrets",
+			args: []string{"-c", "ls -l /usr/sap"},
+			want: []string{"-c", "ls -l /usr/sap"},
+		},
+		{
+			name: "SecretFlagAndValue",
+			args: []string{"--db-password", "p@ss", "-user", "SYSTEM"},
+			want: []string{"--db-password", redacted, "-user", "SYSTEM"},
+		},
+		{
+			name: "Assignments",
+			args: []string{"--client_secret=abc", "PASSWORD: 'a b'", `api_key="x y"`},
+			want: []string{"--client_secret=" + redacted, "PASSWORD: " + redacted, "api_key=" + redacted},
+		},
+		{
+			name: "InScript",
+			args: []string{"-c", "export HANA_PASSWORD=abc; curl --token xyz https://example.com"},
+			want: []string{"-c", "export HANA_PASSWORD=" + redacted + "; curl --token " + redacted + " https://example.com"},
+		},
+	}
+	a := &auditLog{}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			got := a.redactArgs(tc.args)
+			if diff := cmp.Diff(tc.want, got); diff != "" {
+				t.Errorf("redactArgs(%q) returned unexpected diff (-want +got):\n%s", tc.args, diff)
+			}
+		})
+	}
+}
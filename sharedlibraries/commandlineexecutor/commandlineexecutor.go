@@ -49,12 +49,13 @@ Example usage:
 package commandlineexecutor
 
 import (
-	"bytes"
 	"context"
 	"errors"
 	"fmt"
+	"io"
 	"os/exec"
 	"regexp"
+	"runtime"
 	"strconv"
 	"strings"
 	"time"
@@ -99,13 +100,68 @@ type (
 		//   Executable: "/bin/sh"
 		//   ArgsToSplit: "-c 'ls /usr/sap/*/SYS/global/hdb/custom/config/global.ini'"
 		// In this case ArgsToSplit will be split up correctly as:
-		//   []string{"-c", "'ls /usr/sap/*/SYS/global/hdb/custom/config/global.ini'"}
+		//   []string{"-c", "ls /usr/sap/*/SYS/global/hdb/custom/config/global.ini"}
+		// ArgsToSplit is split following the POSIX shell quoting rules if ShellSplit is set.
 		ArgsToSplit string
 		Args        []string
 		Timeout     int // defaults to 60, so timeout will occur in 60 seconds
 		User        string
 		Env         []string
 		Stdin       string
+		// Stream is optional and delivers the command output to the caller while it is running.
+		Stream *StreamOptions
+		// TerminationGracePeriod is the number of seconds to wait after sending SIGTERM to the
+		// command's process group on timeout before sending SIGKILL. Defaults to 0, which sends
+		// SIGKILL immediately. Only supported on Linux.
+		TerminationGracePeriod int
+		// Limits is optional and restricts the resources available to the command.
+		// Only supported on Linux.
+		Limits *ResourceLimits
+		// WorkingDir is optional and sets the working directory of the command.
+		// Only supported on Linux.
+		WorkingDir string
+		// CleanEnv runs the command with only the variables in Env rather than appending Env to the
+		// agent's environment. Only supported on Linux.
+		CleanEnv bool
+		// Umask is optional and sets the file mode creation mask of the command, e.g. 0o077.
+		// Only supported on Linux.
+		Umask *int
+		// ShellSplit splits ArgsToSplit following the POSIX shell quoting rules, see splitShellWords.
+		// By default ArgsToSplit is split on spaces and single quotes only, and every backtick in the
+		// arguments is replaced with a single quote, see splitParams. ShellSplit is ignored on
+		// Windows, where backslashes and backticks are not escape characters.
+		ShellSplit bool
+		// Retry is optional and runs the command again while its Result is retryable.
+		Retry *RetryPolicy
+	}
+
+	// ResourceLimits holds the resource limits applied to a command with setrlimit.
+	// A zero value leaves the corresponding limit unchanged.
+	ResourceLimits struct {
+		// CPUSeconds limits the CPU time of the command (RLIMIT_CPU).
+		CPUSeconds uint64
+		// AddressSpaceBytes limits the virtual memory of the command (RLIMIT_AS).
+		AddressSpaceBytes uint64
+		// OpenFiles limits the number of open file descriptors of the command (RLIMIT_NOFILE).
+		OpenFiles uint64
+		// FileSizeBytes limits the size of the files written by the command, including any
+		// output it redirects to files (RLIMIT_FSIZE).
+		FileSizeBytes uint64
+	}
+
+	// StreamOptions configures the delivery of command output while the command is running.
+	// Writers and callbacks for stdout and stderr may be invoked concurrently with each other.
+	StreamOptions struct {
+		// StdOut and StdErr receive chunks of output as they are written by the command.
+		// An error returned by either writer stops the copying of that output and is
+		// reported in the Result.
+		StdOut, StdErr io.Writer
+		// OnStdOutLine and OnStdErrLine are called once for each line of output, without the
+		// trailing newline. A final line without a newline is delivered when the command exits.
+		OnStdOutLine, OnStdErrLine func(line string)
+		// MaxCapturedBytes caps the number of bytes of stdout, and separately of stderr, kept in
+		// the Result. Output beyond the cap is still streamed. Defaults to 0, which keeps all output.
+		MaxCapturedBytes int
 	}
 
 	// Result holds the stdout, stderr, exit code, and error from the execution.
@@ -115,6 +171,15 @@ type (
 		Error            error
 		ExecutableFound  bool
 		ExitStatusParsed bool // Will be true if "exit status ([0-9]+)" is in the error result
+		// StdOutTruncated and StdErrTruncated are true if output was dropped from the Result
+		// because it exceeded Params.Stream.MaxCapturedBytes.
+		StdOutTruncated, StdErrTruncated bool
+		// TimedOut is true if the command was terminated because Params.Timeout expired,
+		// rather than exiting on its own.
+		TimedOut bool
+		// Attempts is the number of times ExecuteCommand ran the command, more than 1 if it was
+		// retried by Params.Retry.
+		Attempts int
 	}
 
 	// userResolver abstracts the os/user package for testability.
@@ -127,23 +192,49 @@ type (
 ExecuteCommand takes Params and returns a Result.
 
 If the params.Executable does not exist it will return early with the Result.Error filled
-If the Params ArgsToSplit is not empty then it will be split into an arguments array
+If the Params ArgsToSplit is not empty then it will be split into an arguments array, and with
+ShellSplit the Result.Error will wrap ErrUnbalancedQuotes if it has an unterminated quote
 Else the Args will be used as the arguments array
 If the User is not empty then the command will be executed as that user
 If Env is defined then that environment will be used to execute the command
+If the Timeout expires the command and any processes it started are terminated, and the
+Result will have TimedOut set
+If Stream is defined then the output is also delivered to its writers and callbacks as the
+command runs, and the output kept in the Result is capped by Stream.MaxCapturedBytes
+If Retry is defined then the command is run again while its Result is retryable, and the Result
+of the last attempt is returned. Stream receives the output of every attempt
+If an audit log has been set up with SetupAuditLog then a record of each execution is written to it
 
 The returned Result will contain the standard out, standard error, the exit code and an error if
 one was encountered during execution.
 */
 func ExecuteCommand(ctx context.Context, params Params) Result {
+	if params.Retry != nil {
+		return params.Retry.execute(ctx, params, executeAndAudit)
+	}
+	result := executeAndAudit(ctx, params)
+	result.Attempts = 1
+	return result
+}
+
+// executeAndAudit runs the command once and writes its audit record.
+func executeAndAudit(ctx context.Context, params Params) Result {
+	start := time.Now()
+	result := executeCommand(ctx, params)
+	audit(ctx, params, start, time.Now(), result)
+	return result
+}
+
+// executeCommand runs the command for ExecuteCommand.
+func executeCommand(ctx context.Context, params Params) Result {
 	if !exists(params.Executable) {
 		log.CtxLogger(ctx).Debugw("Command executable not found", "executable", params.Executable)
 		msg := fmt.Sprintf("Command executable: %q not found.", params.Executable)
-		return Result{"", msg, 0, fmt.Errorf("command executable: %s not found", params.Executable), false, false}
+		return Result{StdErr: msg, Error: fmt.Errorf("command executable: %s not found", params.Executable)}
 	}
 
-	stdout := new(bytes.Buffer)
-	stderr := new(bytes.Buffer)
+	stdout := &cappedBuffer{max: params.Stream.maxCaptured()}
+	stderr := &cappedBuffer{max: params.Stream.maxCaptured()}
 	// Timeout the command at 60 seconds by default.
 	timeout := 60 * time.Second
 	if params.Timeout > 0 {
@@ -153,24 +244,33 @@ func ExecuteCommand(ctx context.Context, params Params) Result {
 	tctx, cancel := context.WithTimeout(ctx, timeout)
 	defer cancel()
 	args := params.Args
-	if params.ArgsToSplit != "" {
+	if params.ArgsToSplit != "" && (!params.ShellSplit || runtime.GOOS == "windows") {
 		args = splitParams(params.ArgsToSplit)
+	} else if params.ArgsToSplit != "" {
+		var err error
+		if args, err = splitShellWords(params.ArgsToSplit); err != nil {
+			log.CtxLogger(ctx).Debugw("Could not split the command arguments", "executable", params.Executable, "args", params.ArgsToSplit, "error", err)
+			msg := fmt.Sprintf("Could not split arguments %q: %v.", params.ArgsToSplit, err)
+			return Result{StdErr: msg, Error: fmt.Errorf("could not split arguments: %w", err), ExecutableFound: true}
+		}
 	}
 	exe := exec.CommandContext(tctx, params.Executable, args...)
 
 	exe.Stdin = strings.NewReader(params.Stdin)
-	exe.Stdout = stdout
-	exe.Stderr = stderr
+	var flushLines func()
+	exe.Stdout, exe.Stderr, flushLines = params.Stream.writers(stdout, stderr)
 	var err error
+	waited := func() {}
 	if exeForPlatform != nil {
 		err = exeForPlatform(exe, params)
 	} else {
 		// We pass ctx because this calls back into ExecuteCommand which adds the timeout before running the command.
 		err = setupExeForPlatform(ctx, exe, params, ExecuteCommand, newOSUserResolver())
+		waited = terminateOnCancel(exe, params)
 	}
 	if err != nil {
 		log.CtxLogger(ctx).Debugw("Could not setup the executable environment", "executable", params.Executable, "args", args, "error", err)
-		return Result{stdout.String(), stderr.String(), 0, err, true, false}
+		return newResult(stdout, stderr, 0, err, false)
 	}
 
 	log.CtxLogger(ctx).Debugw("Executing command", "executable", params.Executable, "args", args,
@@ -181,6 +281,8 @@ func ExecuteCommand(ctx context.Context, params Params) Result {
 	} else {
 		err = exe.Run()
 	}
+	waited()
+	flushLines()
 	if err != nil {
 		// Set the exit code based on the error first, then see if we can get it from the error message.
 		exitCode := exitCode(err)
@@ -201,13 +303,33 @@ func ExecuteCommand(ctx context.Context, params Params) Result {
 				"args", args, "exitcode", exitCode, "error", err, "stdout", stdout.String(),
 				"stderr", stderr.String())
 		}
-		return Result{stdout.String(), stderr.String(), exitCode, err, true, exitStatusParsed}
+		result := newResult(stdout, stderr, exitCode, err, exitStatusParsed)
+		if errors.Is(tctx.Err(), context.DeadlineExceeded) {
+			log.CtxLogger(ctx).Debugw("Command timed out", "executable", params.Executable, "args", args,
+				"timeout", timeout, "error", err)
+			result.TimedOut = true
+		}
+		return result
 	}
 
 	// Exit code can assumed to be 0
 	log.CtxLogger(ctx).Debugw("Successfully executed command", "executable", params.Executable, "args", args,
 		"stdout", stdout.String(), "stderr", stderr.String())
-	return Result{stdout.String(), stderr.String(), 0, nil, true, false}
+	return newResult(stdout, stderr, 0, nil, false)
+}
+
+// newResult builds the Result for a command whose executable was found.
+func newResult(stdout, stderr *cappedBuffer, exitCode int, err error, exitStatusParsed bool) Result {
+	return Result{
+		StdOut:           stdout.String(),
+		StdErr:           stderr.String(),
+		ExitCode:         exitCode,
+		Error:            err,
+		ExecutableFound:  true,
+		ExitStatusParsed: exitStatusParsed,
+		StdOutTruncated:  stdout.truncated,
+		StdErrTruncated:  stderr.truncated,
+	}
 }
 
 /*
@@ -232,7 +354,8 @@ func commandExitCode(err error) int {
 }
 
 /*
-splitParams performs a custom splitting operation around spaces and substrings contained within
+splitParams is the default splitting of ArgsToSplit, used unless Params.ShellSplit is set.
+It performs a custom splitting operation around spaces and substrings contained within
 single quotes, exclusively, on command strings in order to parse them into a list of valid shell
 arguments for exec.Command structs
 
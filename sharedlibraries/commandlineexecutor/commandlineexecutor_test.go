@@ -21,6 +21,7 @@ import (
 	"errors"
 	"fmt"
 	"os/exec"
+	"strings"
 	"syscall"
 	"testing"
 
@@ -434,8 +435,12 @@ func TestSetupExeForPlatform(t *testing.T) {
 				} else if diff := cmp.Diff(tt.wantCred, exe.SysProcAttr.Credential); diff != "" {
 					t.Errorf("setupExeForPlatform() SysProcAttr.Credential mismatch (-want +got):\n%s", diff)
 				}
-			} else if exe.SysProcAttr != nil {
-				t.Errorf("setupExeForPlatform() SysProcAttr is not nil, want nil")
+			} else if exe.SysProcAttr != nil && exe.SysProcAttr.Credential != nil {
+				t.Errorf("setupExeForPlatform() SysProcAttr.Credential = %v, want nil", exe.SysProcAttr.Credential)
+			}
+
+			if !tt.wantErr && (exe.SysProcAttr == nil || !exe.SysProcAttr.Setpgid) {
+				t.Errorf("setupExeForPlatform() did not set SysProcAttr.Setpgid, want a new process group")
 			}
 		})
 	}
@@ -473,6 +478,131 @@ func TestSplitParams(t *testing.T) {
 	}
 }
 
+func TestSplitShellWords(t *testing.T) {
+	tests := []struct {
+		name    string
+		args    string
+		wantOut []string
+		wantErr error
+	}{
+		{
+			name:    "echo",
+			args:    "echo hello, world",
+			wantOut: []string{"echo", "hello,", "world"},
+		},
+		{
+			name:    "bashMd5sum",
+			args:    "-c 'echo $0 | md5sum' 'test hashing functions'",
+			wantOut: []string{"-c", "echo $0 | md5sum", "test hashing functions"},
+		},
+		{
+			name:    "doubleQuotes",
+			args:    `-c "echo \"$HOME\" \$PATH \a" "with spaces"`,
+			wantOut: []string{"-c", `echo "$HOME" $PATH \a`, "with spaces"},
+		},
+		{
+			name:    "escapedSpaces",
+			args:    `ls /tmp/my\ file \'quoted\'`,
+			wantOut: []string{"ls", "/tmp/my file", "'quoted'"},
+		},
+		{
+			name:    "nestedQuotes",
+			args:    `-c 'grep "a b"' "it's" 'it'\''s'`,
+			wantOut: []string{"-c", `grep "a b"`, "it's", "it's"},
+		},
+		{
+			name:    "adjacentQuotedParts",
+			args:    `--name="SAP HANA"-01 a'b'"c"`,
+			wantOut: []string{"--name=SAP HANA-01", "abc"},
+		},
+		{
+			name:    "backticksPreserved",
+			args:    "-c 'echo `uname`' \\`x\\`",
+			wantOut: []string{"-c", "echo `uname`", "`x`"},
+		},
+		{
+			name:    "emptyArguments",
+			args:    `'' "" a`,
+			wantOut: []string{"", "", "a"},
+		},
+		{
+			name:    "lineContinuationAndTabs",
+			args:    "a\\\n\tb\nc",
+			wantOut: []string{"a", "b", "c"},
+		},
+		{
+			name:    "unterminatedSingleQuote",
+			args:    "-c 'echo hello",
+			wantErr: ErrUnbalancedQuotes,
+		},
+		{
+			name:    "unterminatedDoubleQuote",
+			args:    `-c "echo 'hello'`,
+			wantErr: ErrUnbalancedQuotes,
+		},
+		{
+			name:    "trailingBackslash",
+			args:    `echo \`,
+			wantErr: ErrUnbalancedQuotes,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, err := splitShellWords(test.args)
+			if !errors.Is(err, test.wantErr) {
+				t.Fatalf("splitShellWords(%q) returned error: %v, want: %v", test.args, err, test.wantErr)
+			}
+			if diff := cmp.Diff(test.wantOut, got); diff != "" {
+				t.Errorf("splitShellWords(%q) returned unexpected diff (-want +got):\n%s", test.args, diff)
+			}
+		})
+	}
+}
+
+func TestExecuteCommandSplitting(t *testing.T) {
+	tests := []struct {
+		name       string
+		args       string
+		shellSplit bool
+		wantOut    string
+		wantErr    error
+	}{
+		{
+			name:       "posixQuoting",
+			args:       `-c "printf '%s|' \"\$0\" \"\$1\"" "first arg" second\ arg`,
+			shellSplit: true,
+			wantOut:    "first arg|second arg|",
+		},
+		{
+			name:    "legacyBackticks",
+			args:    "-c 'echo `quoted`'",
+			wantOut: "quoted\n",
+		},
+		{
+			name:       "unbalancedQuotes",
+			args:       "-c 'echo hello",
+			shellSplit: true,
+			wantErr:    ErrUnbalancedQuotes,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			setDefaults()
+			result := ExecuteCommand(context.Background(), Params{
+				Executable:  "sh",
+				ArgsToSplit: test.args,
+				ShellSplit:  test.shellSplit,
+			})
+			if !errors.Is(result.Error, test.wantErr) {
+				t.Fatalf("ExecuteCommand(%q) returned error: %v, want: %v", test.args, result.Error, test.wantErr)
+			}
+			if diff := cmp.Diff(test.wantOut, result.StdOut); diff != "" {
+				t.Errorf("ExecuteCommand(%q) returned unexpected diff (-want +got):\n%s", test.args, diff)
+			}
+		})
+	}
+}
+
 func TestExecuteCommandWithStdin(t *testing.T) {
 	tests := []struct {
 		name    string
@@ -512,3 +642,87 @@ func TestExecuteCommandWithStdin(t *testing.T) {
 		})
 	}
 }
+
+func TestExecuteCommandWithStream(t *testing.T) {
+	tests := []struct {
+		name             string
+		args             string
+		maxCapturedBytes int
+		wantOut          string
+		wantErr          string
+		wantOutLines     []string
+		wantErrLines     []string
+		wantStreamedOut  string
+		wantOutTruncated bool
+		wantErrTruncated bool
+	}{
+		{
+			name:            "LinesAndChunks",
+			args:            "-c 'echo first; echo err1 >&2; echo second; printf last'",
+			wantOut:         "first\nsecond\nlast",
+			wantErr:         "err1\n",
+			wantOutLines:    []string{"first", "second", "last"},
+			wantErrLines:    []string{"err1"},
+			wantStreamedOut: "first\nsecond\nlast",
+		},
+		{
+			name:             "CappedOutput",
+			args:             "-c 'echo 0123456789; echo abcdefghij >&2'",
+			maxCapturedBytes: 4,
+			wantOut:          "0123",
+			wantErr:          "abcd",
+			wantOutLines:     []string{"0123456789"},
+			wantErrLines:     []string{"abcdefghij"},
+			wantStreamedOut:  "0123456789\n",
+			wantOutTruncated: true,
+			wantErrTruncated: true,
+		},
+		{
+			name:             "OutputUnderCap",
+			args:             "-c 'echo hello'",
+			maxCapturedBytes: 100,
+			wantOut:          "hello\n",
+			wantOutLines:     []string{"hello"},
+			wantStreamedOut:  "hello\n",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			setDefaults()
+			var gotOutLines, gotErrLines []string
+			streamedOut := new(strings.Builder)
+			result := ExecuteCommand(context.Background(), Params{
+				Executable:  "sh",
+				ArgsToSplit: test.args,
+				Stream: &StreamOptions{
+					StdOut:           streamedOut,
+					OnStdOutLine:     func(line string) { gotOutLines = append(gotOutLines, line) },
+					OnStdErrLine:     func(line string) { gotErrLines = append(gotErrLines, line) },
+					MaxCapturedBytes: test.maxCapturedBytes,
+				},
+			})
+			if result.Error != nil {
+				t.Fatalf("ExecuteCommand with stream returned unexpected error: %v", result.Error)
+			}
+			if diff := cmp.Diff(test.wantOut, result.StdOut); diff != "" {
+				t.Errorf("ExecuteCommand with stream returned unexpected stdout diff (-want +got):\n%s", diff)
+			}
+			if diff := cmp.Diff(test.wantErr, result.StdErr); diff != "" {
+				t.Errorf("ExecuteCommand with stream returned unexpected stderr diff (-want +got):\n%s", diff)
+			}
+			if diff := cmp.Diff(test.wantOutLines, gotOutLines); diff != "" {
+				t.Errorf("ExecuteCommand with stream delivered unexpected stdout lines (-want +got):\n%s", diff)
+			}
+			if diff := cmp.Diff(test.wantErrLines, gotErrLines); diff != "" {
+				t.Errorf("ExecuteCommand with stream delivered unexpected stderr lines (-want +got):\n%s", diff)
+			}
+			if diff := cmp.Diff(test.wantStreamedOut, streamedOut.String()); diff != "" {
+				t.Errorf("ExecuteCommand with stream wrote unexpected stdout (-want +got):\n%s", diff)
+			}
+			if result.StdOutTruncated != test.wantOutTruncated || result.StdErrTruncated != test.wantErrTruncated {
+				t.Errorf("ExecuteCommand with stream got truncated (stdout, stderr) = (%t, %t), want (%t, %t)",
+					result.StdOutTruncated, result.StdErrTruncated, test.wantOutTruncated, test.wantErrTruncated)
+			}
+		})
+	}
+}
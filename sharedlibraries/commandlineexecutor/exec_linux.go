@@ -18,16 +18,24 @@ package commandlineexecutor
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"os"
 	"os/exec"
 	"os/user"
 	"strconv"
 	"strings"
+	"sync"
 	"syscall"
+	"time"
 
 	"github.com/GoogleCloudPlatform/workloadagentplatform/sharedlibraries/log"
 )
 
+// waitDelayAfterKill is how long to wait for the command's output to be closed after the
+// process group has been sent SIGKILL.
+const waitDelayAfterKill = 5 * time.Second
+
 // osUserResolver is a wrapper around the os/user package for testability.
 type osUserResolver struct {
 	userLookup   func(username string) (*user.User, error)
@@ -43,12 +51,27 @@ func newOSUserResolver() userResolver {
 }
 
 // setupExeForPlatform sets up the env and user if provided in the params.
+// The command is run in its own process group so that on timeout the whole group is terminated,
+// including any processes started by a shell.
 // returns an error if it could not be setup
 func setupExeForPlatform(ctx context.Context, exe *exec.Cmd, params Params, executeCommand Execute, userResolver userResolver) error {
 	// set the execution environment if params Env exists
-	if len(params.Env) > 0 {
+	if params.CleanEnv {
+		// A non-nil Env prevents the agent's environment from being inherited.
+		exe.Env = append([]string{}, params.Env...)
+	} else if len(params.Env) > 0 {
 		exe.Env = append(exe.Environ(), params.Env...)
 	}
+	if params.WorkingDir != "" {
+		exe.Dir = params.WorkingDir
+	}
+	if params.Limits != nil || params.Umask != nil {
+		if err := wrapWithLimits(exe, params.Limits, params.Umask); err != nil {
+			return err
+		}
+	}
+
+	exe.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
 
 	// if params.User exists run as the user
 	if params.User != "" {
@@ -61,12 +84,118 @@ func setupExeForPlatform(ctx context.Context, exe *exec.Cmd, params Params, exec
 				return fmt.Errorf("failed to fetch IDs, please refer to the logs for more details: %w", err)
 			}
 		}
-		exe.SysProcAttr = &syscall.SysProcAttr{}
 		exe.SysProcAttr.Credential = &syscall.Credential{Uid: uid, Gid: gid, Groups: groups}
 	}
 	return nil
 }
 
+// wrapWithLimits rewrites exe to be started through bash, which applies the resource limits and
+// umask to itself with the ulimit and umask builtins before replacing itself with the command.
+// bash is used rather than sh because the units of ulimit -f differ between shells.
+func wrapWithLimits(exe *exec.Cmd, limits *ResourceLimits, umask *int) error {
+	bash, err := exec.LookPath("bash")
+	if err != nil {
+		return fmt.Errorf("bash is required to apply resource limits: %w", err)
+	}
+	var script []string
+	if limits != nil {
+		// The -t and -n limits are in seconds and counts, -v and -f are in kilobytes.
+		for _, l := range []struct {
+			flag  string
+			value uint64
+		}{
+			{"-t", limits.CPUSeconds},
+			{"-v", kilobytes(limits.AddressSpaceBytes)},
+			{"-n", limits.OpenFiles},
+			{"-f", kilobytes(limits.FileSizeBytes)},
+		} {
+			if l.value > 0 {
+				script = append(script, fmt.Sprintf("ulimit %s %d", l.flag, l.value))
+			}
+		}
+	}
+	if umask != nil {
+		if *umask < 0 || *umask > 0o777 {
+			return fmt.Errorf("invalid umask: %#o", *umask)
+		}
+		script = append(script, fmt.Sprintf("umask %04o", *umask))
+	}
+	script = append(script, `exec "$0" "$@"`)
+	// The command becomes $0 and its arguments become $@ of the script.
+	exe.Args = append([]string{bash, "-c", strings.Join(script, " && "), exe.Path}, exe.Args[1:]...)
+	exe.Path = bash
+	return nil
+}
+
+// kilobytes converts bytes to kilobytes, rounding up so that a non-zero limit stays non-zero.
+func kilobytes(bytes uint64) uint64 {
+	return (bytes + 1023) / 1024
+}
+
+/*
+terminateOnCancel makes exe terminate its process group when its context is done, see
+processGroup. The returned function must be called once Wait has returned.
+*/
+func terminateOnCancel(exe *exec.Cmd, params Params) (waited func()) {
+	g := &processGroup{exe: exe, gracePeriod: time.Duration(params.TerminationGracePeriod) * time.Second}
+	exe.Cancel = g.terminate
+	// Bound the wait for output pipes held open by processes that ignore the termination.
+	exe.WaitDelay = g.gracePeriod + waitDelayAfterKill
+	return g.waited
+}
+
+// processGroup terminates the process group of a command started with Setpgid.
+type processGroup struct {
+	exe         *exec.Cmd
+	gracePeriod time.Duration
+
+	mu sync.Mutex
+	// reaped is set once Wait has returned, after which the process group ID may be reused.
+	reaped bool
+	kill   *time.Timer
+}
+
+// terminate sends SIGTERM to the process group, followed by SIGKILL once the grace period has
+// elapsed. With no grace period SIGKILL is sent immediately.
+func (g *processGroup) terminate() error {
+	pid := g.exe.Process.Pid
+	if g.gracePeriod <= 0 {
+		return signalProcessGroup(pid, syscall.SIGKILL)
+	}
+	if err := signalProcessGroup(pid, syscall.SIGTERM); err != nil {
+		return err
+	}
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.kill = time.AfterFunc(g.gracePeriod, func() {
+		g.mu.Lock()
+		defer g.mu.Unlock()
+		if !g.reaped {
+			signalProcessGroup(pid, syscall.SIGKILL)
+		}
+	})
+	return nil
+}
+
+// waited stops the pending SIGKILL, the command has exited and its process has been reaped.
+func (g *processGroup) waited() {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.reaped = true
+	if g.kill != nil {
+		g.kill.Stop()
+	}
+}
+
+// signalProcessGroup sends sig to every process in the process group led by pid.
+func signalProcessGroup(pid int, sig syscall.Signal) error {
+	err := syscall.Kill(-pid, sig)
+	if errors.Is(err, syscall.ESRCH) {
+		return os.ErrProcessDone
+	}
+	return err
+}
+
 func (o *osUserResolver) lookupIDs(username string) (uint32, uint32, []uint32, error) {
 	u, err := o.userLookup(username)
 	if err != nil {
@@ -113,9 +242,9 @@ func fetchIDs(ctx context.Context, user string, executeCommand Execute) (uint32,
 	if result.Error != nil {
 		return 0, 0, nil, fmt.Errorf("getUID failed with: %s. StdErr: %s", result.Error, result.StdErr)
 	}
-	uid, err := strconv.Atoi(strings.TrimSuffix(result.StdOut, "\n"))
+	uid, err := singleID(result)
 	if err != nil {
-		return 0, 0, nil, fmt.Errorf("could not parse UID from StdOut: %s", result.StdOut)
+		return 0, 0, nil, fmt.Errorf("could not parse UID from StdOut: %s: %w", result.StdOut, err)
 	}
 
 	result = executeCommand(ctx, Params{
@@ -125,9 +254,9 @@ func fetchIDs(ctx context.Context, user string, executeCommand Execute) (uint32,
 	if result.Error != nil {
 		return 0, 0, nil, fmt.Errorf("getGID failed with: %s. StdErr: %s", result.Error, result.StdErr)
 	}
-	gid, err := strconv.Atoi(strings.TrimSuffix(result.StdOut, "\n"))
+	gid, err := singleID(result)
 	if err != nil {
-		return 0, 0, nil, fmt.Errorf("could not parse GID from StdOut: %s", result.StdOut)
+		return 0, 0, nil, fmt.Errorf("could not parse GID from StdOut: %s: %w", result.StdOut, err)
 	}
 
 	result = executeCommand(ctx, Params{
@@ -137,16 +266,30 @@ func fetchIDs(ctx context.Context, user string, executeCommand Execute) (uint32,
 	if result.Error != nil {
 		return 0, 0, nil, fmt.Errorf("getGroups failed with: %s. StdErr: %s", result.Error, result.StdErr)
 	}
-	groups := strings.Split(strings.TrimSuffix(result.StdOut, "\n"), " ")
+	groups, err := result.Integers()
+	if err == nil && len(groups) == 0 {
+		err = &ParseError{Format: FormatInteger, Text: result.StdOut, Reason: "expected at least one ID"}
+	}
+	if err != nil {
+		return 0, 0, nil, fmt.Errorf("could not parse GroupID from StdOut: %s: %w", result.StdOut, err)
+	}
 	var groupIDs []uint32
-	for _, gID := range groups {
-		g, err := strconv.Atoi(gID)
-		if err != nil {
-			return 0, 0, nil, fmt.Errorf("could not parse GroupID from StdOut: %s", result.StdOut)
-		}
+	for _, g := range groups {
 		groupIDs = append(groupIDs, uint32(g))
 	}
-	return uint32(uid), uint32(gid), groupIDs, nil
+	return uid, gid, groupIDs, nil
+}
+
+// singleID parses the output of the "id" command when it prints a single ID.
+func singleID(result Result) (uint32, error) {
+	ids, err := result.Integers()
+	if err != nil {
+		return 0, err
+	}
+	if len(ids) != 1 {
+		return 0, &ParseError{Format: FormatInteger, Text: result.StdOut, Reason: fmt.Sprintf("expected a single ID, got %d", len(ids))}
+	}
+	return uint32(ids[0]), nil
 }
 
 // Helper to convert string IDs to uint32.
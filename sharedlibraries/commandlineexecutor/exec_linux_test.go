@@ -19,9 +19,12 @@ package commandlineexecutor
 import (
 	"context"
 	"errors"
+	"os/exec"
 	"os/user"
 	"strings"
+	"syscall"
 	"testing"
+	"time"
 
 	"github.com/google/go-cmp/cmp"
 )
@@ -273,6 +276,13 @@ func TestFetchIDs(t *testing.T) {
 			gidsResult: Result{StdOut: "1002 abc\n"},
 			wantErr:    true,
 		},
+		{
+			name:       "GetGroupsEmptyOutput",
+			uidResult:  Result{StdOut: "1001\n"},
+			gidResult:  Result{StdOut: "1002\n"},
+			gidsResult: Result{StdOut: "\n"},
+			wantErr:    true,
+		},
 	}
 
 	for _, tt := range tests {
@@ -302,3 +312,187 @@ func TestFetchIDs(t *testing.T) {
 		})
 	}
 }
+
+func TestExecuteCommandTimeout(t *testing.T) {
+	tests := []struct {
+		name         string
+		params       Params
+		wantStdOut   string
+		wantTimedOut bool
+	}{
+		{
+			name: "KillsProcessGroup",
+			// The backgrounded sleep keeps stdout open, so the command only returns early if the
+			// whole process group is killed.
+			params: Params{
+				Executable:  "sh",
+				ArgsToSplit: "-c 'sleep 30 & sleep 30'",
+				Timeout:     1,
+			},
+			wantTimedOut: true,
+		},
+		{
+			name: "GracefulTermination",
+			params: Params{
+				Executable:             "sh",
+				ArgsToSplit:            "-c 'trap \"echo terminated; exit 0\" TERM; sleep 30 & wait'",
+				Timeout:                1,
+				TerminationGracePeriod: 10,
+			},
+			wantStdOut:   "terminated\n",
+			wantTimedOut: true,
+		},
+		{
+			name: "ExitsBeforeTimeout",
+			params: Params{
+				Executable:  "sh",
+				ArgsToSplit: "-c 'exit 3'",
+				Timeout:     10,
+			},
+			wantTimedOut: false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setDefaults()
+			start := time.Now()
+			result := ExecuteCommand(context.Background(), tt.params)
+			if elapsed := time.Since(start); elapsed > 8*time.Second {
+				t.Errorf("ExecuteCommand(%v) took %v, want the command to be terminated shortly after the timeout", tt.params, elapsed)
+			}
+			if result.TimedOut != tt.wantTimedOut {
+				t.Errorf("ExecuteCommand(%v) TimedOut = %t, want %t", tt.params, result.TimedOut, tt.wantTimedOut)
+			}
+			if result.Error == nil {
+				t.Errorf("ExecuteCommand(%v) returned nil error, want error", tt.params)
+			}
+			if diff := cmp.Diff(tt.wantStdOut, result.StdOut); diff != "" {
+				t.Errorf("ExecuteCommand(%v) returned unexpected stdout diff (-want +got):\n%s", tt.params, diff)
+			}
+		})
+	}
+}
+
+func TestExecuteCommandSandbox(t *testing.T) {
+	umask := 0o027
+	invalidUmask := 0o1000
+	dir := t.TempDir()
+	tests := []struct {
+		name       string
+		params     Params
+		wantStdOut string
+		wantErr    bool
+	}{
+		{
+			name: "CPUTimeLimit",
+			params: Params{
+				Executable:  "bash",
+				ArgsToSplit: "-c 'ulimit -t'",
+				Limits:      &ResourceLimits{CPUSeconds: 5},
+			},
+			wantStdOut: "5\n",
+		},
+		{
+			name: "AddressSpaceLimit",
+			params: Params{
+				Executable:  "bash",
+				ArgsToSplit: "-c 'ulimit -v'",
+				Limits:      &ResourceLimits{AddressSpaceBytes: 1 << 30},
+			},
+			wantStdOut: "1048576\n",
+		},
+		{
+			name: "OpenFilesLimit",
+			params: Params{
+				Executable:  "bash",
+				ArgsToSplit: "-c 'ulimit -n'",
+				Limits:      &ResourceLimits{OpenFiles: 64},
+			},
+			wantStdOut: "64\n",
+		},
+		{
+			name: "FileSizeLimit",
+			params: Params{
+				Executable:  "bash",
+				ArgsToSplit: "-c 'ulimit -f'",
+				Limits:      &ResourceLimits{FileSizeBytes: 1 << 20},
+			},
+			wantStdOut: "1024\n",
+		},
+		{
+			name: "LimitsKeepArgs",
+			params: Params{
+				Executable: "echo",
+				Args:       []string{"hello", "$HOME", "world"},
+				Limits:     &ResourceLimits{OpenFiles: 64},
+			},
+			wantStdOut: "hello $HOME world\n",
+		},
+		{
+			name: "Umask",
+			params: Params{
+				Executable: "sh",
+				Args:       []string{"-c", "umask"},
+				Umask:      &umask,
+			},
+			wantStdOut: "0027\n",
+		},
+		{
+			name: "InvalidUmask",
+			params: Params{
+				Executable: "sh",
+				Args:       []string{"-c", "umask"},
+				Umask:      &invalidUmask,
+			},
+			wantErr: true,
+		},
+		{
+			name: "WorkingDir",
+			params: Params{
+				Executable: "pwd",
+				WorkingDir: dir,
+			},
+			wantStdOut: dir + "\n",
+		},
+		{
+			name: "CleanEnv",
+			params: Params{
+				Executable: "env",
+				Env:        []string{"VAR1=val1"},
+				CleanEnv:   true,
+			},
+			wantStdOut: "VAR1=val1\n",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setDefaults()
+			result := ExecuteCommand(context.Background(), tt.params)
+			if gotErr := result.Error != nil; gotErr != tt.wantErr {
+				t.Fatalf("ExecuteCommand(%v) returned error: %v, wantErr: %t", tt.params, result.Error, tt.wantErr)
+			}
+			if diff := cmp.Diff(tt.wantStdOut, result.StdOut); diff != "" {
+				t.Errorf("ExecuteCommand(%v) returned unexpected stdout diff (-want +got):\n%s", tt.params, diff)
+			}
+		})
+	}
+}
+
+func TestProcessGroupWaitedStopsKill(t *testing.T) {
+	exe := exec.Command("sleep", "30")
+	exe.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	if err := exe.Start(); err != nil {
+		t.Fatalf("Start() returned unexpected error: %v", err)
+	}
+	g := &processGroup{exe: exe, gracePeriod: time.Hour}
+	if err := g.terminate(); err != nil {
+		t.Fatalf("terminate() returned unexpected error: %v", err)
+	}
+	exe.Wait()
+	g.waited()
+	if g.kill.Stop() {
+		t.Errorf("waited() did not stop the SIGKILL timer")
+	}
+}
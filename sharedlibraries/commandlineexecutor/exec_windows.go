@@ -38,3 +38,8 @@ func (o *osUserResolver) lookupIDs(username string) (uint32, uint32, []uint32, e
 func setupExeForPlatform(ctx context.Context, exe *exec.Cmd, params Params, executeCommand Execute, resolver userResolver) error {
 	return nil
 }
+
+// terminateOnCancel is not implemented for windows, the process is killed when its context is done.
+func terminateOnCancel(exe *exec.Cmd, params Params) (waited func()) {
+	return func() {}
+}
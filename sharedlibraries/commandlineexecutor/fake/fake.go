@@ -0,0 +1,189 @@
+/*
+Copyright 2025 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+/*
+Package fake provides a scripted fake of commandlineexecutor.Execute for unit tests.
+
+Example usage:
+
+	exec := fake.New(t,
+		fake.Expectation{
+			Executable: "id",
+			Args:       "-u *",
+			Match:      fake.Glob,
+			Results:    []commandlineexecutor.Result{{StdOut: "1001\n"}},
+		},
+	)
+	doSomething(ctx, exec.Execute)
+	if got := len(exec.Calls()); got != 1 {
+		t.Errorf("doSomething() ran %d commands, want 1", got)
+	}
+
+Scripts can also be captured from real command executions with a Recorder and loaded with
+LoadGolden.
+*/
+package fake
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/GoogleCloudPlatform/workloadagentplatform/sharedlibraries/commandlineexecutor"
+)
+
+// MatchType is how an Expectation is compared to the executable and arguments of a command.
+type MatchType int
+
+const (
+	// Exact requires the executable and arguments to be equal to the expectation.
+	Exact MatchType = iota
+	// Glob treats the expectation as a pattern where "*" matches any sequence of characters,
+	// including "/" and spaces, and "?" matches any single character.
+	Glob
+	// Regexp treats the expectation as a regular expression that must match the whole value.
+	Regexp
+)
+
+// Expectation scripts the results returned for the commands that match it.
+type Expectation struct {
+	// Executable is compared to Params.Executable.
+	Executable string
+	// Args is compared to Params.ArgsToSplit if it is set, or else to Params.Args joined by spaces.
+	Args  string
+	Match MatchType
+	// Results are returned in order, one for each matching command.
+	Results []commandlineexecutor.Result
+}
+
+// expectation is an Expectation compiled for matching, with its remaining results.
+type expectation struct {
+	Expectation
+	executable, args *regexp.Regexp
+	next             int
+}
+
+// Executor is a fake commandlineexecutor.Execute which returns scripted results and records every
+// command it is asked to run. Executor is safe for concurrent use.
+type Executor struct {
+	t            testing.TB
+	mu           sync.Mutex
+	expectations []*expectation
+	calls        []commandlineexecutor.Params
+}
+
+// New returns an Executor scripted with the expectations.
+// The test fails if a command does not match any expectation with results remaining, or if results
+// remain unused when the test completes.
+func New(t testing.TB, expectations ...Expectation) *Executor {
+	t.Helper()
+	e := &Executor{t: t}
+	for _, exp := range expectations {
+		e.Expect(exp)
+	}
+	t.Cleanup(e.verify)
+	return e
+}
+
+// Expect adds an expectation to the script. Expectations are matched in the order they were added.
+func (e *Executor) Expect(exp Expectation) *Executor {
+	e.t.Helper()
+	compiled := &expectation{Expectation: exp}
+	var err error
+	if compiled.executable, err = compile(exp.Executable, exp.Match); err != nil {
+		e.t.Fatalf("invalid executable pattern %q: %v", exp.Executable, err)
+	}
+	if compiled.args, err = compile(exp.Args, exp.Match); err != nil {
+		e.t.Fatalf("invalid args pattern %q: %v", exp.Args, err)
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.expectations = append(e.expectations, compiled)
+	return e
+}
+
+// Execute records the command and returns the next result of the first matching expectation.
+// It can be passed wherever a commandlineexecutor.Execute is accepted.
+func (e *Executor) Execute(ctx context.Context, params commandlineexecutor.Params) commandlineexecutor.Result {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.calls = append(e.calls, params)
+	args := argsString(params)
+	for _, exp := range e.expectations {
+		if exp.next < len(exp.Results) && exp.executable.MatchString(params.Executable) && exp.args.MatchString(args) {
+			exp.next++
+			return exp.Results[exp.next-1]
+		}
+	}
+	// Errorf rather than Fatalf as commands may be executed outside of the test goroutine.
+	e.t.Errorf("unexpected command: executable: %q, args: %q", params.Executable, args)
+	msg := fmt.Sprintf("fake executor: unexpected command %q %q", params.Executable, args)
+	return commandlineexecutor.Result{StdErr: msg, ExitCode: 1, Error: fmt.Errorf("%s", msg)}
+}
+
+// Calls returns the parameters of every command executed, in order.
+func (e *Executor) Calls() []commandlineexecutor.Params {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return append([]commandlineexecutor.Params(nil), e.calls...)
+}
+
+// verify fails the test if any scripted results were not returned.
+func (e *Executor) verify() {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	for _, exp := range e.expectations {
+		if remaining := len(exp.Results) - exp.next; remaining > 0 {
+			e.t.Errorf("%d unused result(s) for expected command: executable: %q, args: %q", remaining, exp.Executable, exp.Args)
+		}
+	}
+}
+
+// argsString returns the arguments of the command as a single string.
+func argsString(params commandlineexecutor.Params) string {
+	if params.ArgsToSplit != "" {
+		return params.ArgsToSplit
+	}
+	return strings.Join(params.Args, " ")
+}
+
+// compile returns a regular expression matching the whole of a value according to matchType.
+func compile(pattern string, matchType MatchType) (*regexp.Regexp, error) {
+	switch matchType {
+	case Exact:
+		return regexp.Compile("^" + regexp.QuoteMeta(pattern) + "$")
+	case Glob:
+		var b strings.Builder
+		for _, r := range pattern {
+			switch r {
+			case '*':
+				b.WriteString(".*")
+			case '?':
+				b.WriteString(".")
+			default:
+				b.WriteString(regexp.QuoteMeta(string(r)))
+			}
+		}
+		return regexp.Compile("^(?s)" + b.String() + "$")
+	case Regexp:
+		return regexp.Compile("^(?:" + pattern + ")$")
+	default:
+		return nil, fmt.Errorf("unknown match type: %d", matchType)
+	}
+}
@@ -0,0 +1,210 @@
+/*
+Copyright 2025 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package fake
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
+	"github.com/GoogleCloudPlatform/workloadagentplatform/sharedlibraries/commandlineexecutor"
+)
+
+// fakeTB records test failures instead of failing the test that uses it.
+type fakeTB struct {
+	testing.TB
+	errors   []string
+	cleanups []func()
+}
+
+func (f *fakeTB) Helper() {}
+
+func (f *fakeTB) Errorf(format string, args ...any) {
+	f.errors = append(f.errors, fmt.Sprintf(format, args...))
+}
+
+func (f *fakeTB) Fatalf(format string, args ...any) {
+	f.errors = append(f.errors, fmt.Sprintf(format, args...))
+}
+
+func (f *fakeTB) Cleanup(fn func()) {
+	f.cleanups = append(f.cleanups, fn)
+}
+
+func (f *fakeTB) runCleanups() {
+	for _, fn := range f.cleanups {
+		fn()
+	}
+}
+
+func TestExecute(t *testing.T) {
+	tests := []struct {
+		name         string
+		expectations []Expectation
+		params       []commandlineexecutor.Params
+		want         []commandlineexecutor.Result
+		wantFailures int
+	}{
+		{
+			name: "ExactMatchInOrder",
+			expectations: []Expectation{
+				{
+					Executable: "systemctl",
+					Args:       "is-active agent",
+					Results:    []commandlineexecutor.Result{{StdOut: "activating\n", ExitCode: 3}, {StdOut: "active\n"}},
+				},
+			},
+			params: []commandlineexecutor.Params{
+				{Executable: "systemctl", ArgsToSplit: "is-active agent"},
+				{Executable: "systemctl", Args: []string{"is-active", "agent"}},
+			},
+			want: []commandlineexecutor.Result{{StdOut: "activating\n", ExitCode: 3}, {StdOut: "active\n"}},
+		},
+		{
+			name: "GlobMatch",
+			expectations: []Expectation{
+				{
+					Executable: "/usr/sap/*/exe/sapcontrol",
+					Args:       "-nr ?? -function *",
+					Match:      Glob,
+					Results:    []commandlineexecutor.Result{{StdOut: "OK\n"}},
+				},
+			},
+			params: []commandlineexecutor.Params{
+				{Executable: "/usr/sap/ABC/SYS/exe/sapcontrol", ArgsToSplit: "-nr 00 -function GetProcessList"},
+			},
+			want: []commandlineexecutor.Result{{StdOut: "OK\n"}},
+		},
+		{
+			name: "RegexpMatch",
+			expectations: []Expectation{
+				{
+					Executable: "id",
+					Args:       `-[ug] \w+`,
+					Match:      Regexp,
+					Results:    []commandlineexecutor.Result{{StdOut: "1001\n"}, {StdOut: "1002\n"}},
+				},
+			},
+			params: []commandlineexecutor.Params{
+				{Executable: "id", ArgsToSplit: "-u testuser"},
+				{Executable: "id", ArgsToSplit: "-g testuser"},
+			},
+			want: []commandlineexecutor.Result{{StdOut: "1001\n"}, {StdOut: "1002\n"}},
+		},
+		{
+			name: "UnexpectedCommand",
+			expectations: []Expectation{
+				{Executable: "ls", Results: []commandlineexecutor.Result{{}}},
+			},
+			params: []commandlineexecutor.Params{
+				{Executable: "ls"},
+				{Executable: "rm", ArgsToSplit: "-rf /"},
+			},
+			want: []commandlineexecutor.Result{
+				{},
+				{StdErr: `fake executor: unexpected command "rm" "-rf /"`, ExitCode: 1, Error: cmpopts.AnyError},
+			},
+			wantFailures: 1,
+		},
+		{
+			name: "ResultsExhausted",
+			expectations: []Expectation{
+				{Executable: "ls", Results: []commandlineexecutor.Result{{StdOut: "a\n"}}},
+			},
+			params: []commandlineexecutor.Params{
+				{Executable: "ls"},
+				{Executable: "ls"},
+			},
+			want: []commandlineexecutor.Result{
+				{StdOut: "a\n"},
+				{StdErr: `fake executor: unexpected command "ls" ""`, ExitCode: 1, Error: cmpopts.AnyError},
+			},
+			wantFailures: 1,
+		},
+		{
+			name: "UnusedResults",
+			expectations: []Expectation{
+				{Executable: "ls", Results: []commandlineexecutor.Result{{}, {}}},
+			},
+			params: []commandlineexecutor.Params{
+				{Executable: "ls"},
+			},
+			want:         []commandlineexecutor.Result{{}},
+			wantFailures: 1,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			tb := &fakeTB{}
+			e := New(tb, tc.expectations...)
+			var got []commandlineexecutor.Result
+			for _, p := range tc.params {
+				got = append(got, e.Execute(context.Background(), p))
+			}
+			tb.runCleanups()
+
+			if diff := cmp.Diff(tc.want, got, cmpopts.EquateErrors()); diff != "" {
+				t.Errorf("Execute() returned diff (-want +got):\n%s", diff)
+			}
+			if diff := cmp.Diff(tc.params, e.Calls(), cmpopts.EquateEmpty()); diff != "" {
+				t.Errorf("Calls() returned diff (-want +got):\n%s", diff)
+			}
+			if len(tb.errors) != tc.wantFailures {
+				t.Errorf("Executor reported %d test failures: %q, want %d", len(tb.errors), tb.errors, tc.wantFailures)
+			}
+		})
+	}
+}
+
+func TestRecordAndLoadGolden(t *testing.T) {
+	results := map[string]commandlineexecutor.Result{
+		"-u": {StdOut: "1001\n", ExecutableFound: true, Attempts: 1},
+		"-x": {StdErr: "invalid option\n", ExitCode: 1, Error: errors.New("exit status 1"), ExecutableFound: true, ExitStatusParsed: true, Attempts: 3},
+		"-G": {StdOut: "1001 1002", StdOutTruncated: true, ExecutableFound: true, ExitStatusParsed: true, Attempts: 1},
+	}
+	recorder := NewRecorder(func(ctx context.Context, params commandlineexecutor.Params) commandlineexecutor.Result {
+		return results[params.Args[0]]
+	})
+	params := []commandlineexecutor.Params{
+		{Executable: "id", Args: []string{"-u", "testuser"}},
+		{Executable: "id", Args: []string{"-x", "testuser"}},
+		{Executable: "id", Args: []string{"-G", "testuser"}},
+	}
+	for _, p := range params {
+		recorder.Execute(context.Background(), p)
+	}
+	path := filepath.Join(t.TempDir(), "commands.golden")
+	if err := recorder.WriteGolden(path); err != nil {
+		t.Fatalf("WriteGolden(%q) failed: %v", path, err)
+	}
+
+	replay := LoadGolden(t, path)
+	for _, p := range params {
+		want := results[p.Args[0]]
+		got := replay.Execute(context.Background(), p)
+		if diff := cmp.Diff(want, got, cmp.Comparer(func(a, b error) bool {
+			return (a == nil) == (b == nil) && (a == nil || a.Error() == b.Error())
+		})); diff != "" {
+			t.Errorf("Execute(%v) after LoadGolden() returned diff (-want +got):\n%s", p, diff)
+		}
+	}
+}
@@ -0,0 +1,131 @@
+/*
+Copyright 2025 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package fake
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"sync"
+	"testing"
+
+	"github.com/GoogleCloudPlatform/workloadagentplatform/sharedlibraries/commandlineexecutor"
+)
+
+// goldenCommand is a single recorded command execution in a golden file.
+type goldenCommand struct {
+	Executable string       `json:"executable"`
+	Args       string       `json:"args"`
+	Result     goldenResult `json:"result"`
+}
+
+// goldenResult is the serializable form of a commandlineexecutor.Result.
+type goldenResult struct {
+	StdOut           string `json:"stdout"`
+	StdErr           string `json:"stderr"`
+	ExitCode         int    `json:"exit_code"`
+	Error            string `json:"error,omitempty"`
+	ExecutableFound  bool   `json:"executable_found"`
+	ExitStatusParsed bool   `json:"exit_status_parsed"`
+	TimedOut         bool   `json:"timed_out,omitempty"`
+	StdOutTruncated  bool   `json:"stdout_truncated,omitempty"`
+	StdErrTruncated  bool   `json:"stderr_truncated,omitempty"`
+	Attempts         int    `json:"attempts,omitempty"`
+}
+
+// Recorder wraps a commandlineexecutor.Execute, usually commandlineexecutor.ExecuteCommand, and
+// records every command and result so they can be replayed in tests with LoadGolden.
+// Recorder is safe for concurrent use.
+type Recorder struct {
+	execute  commandlineexecutor.Execute
+	mu       sync.Mutex
+	commands []goldenCommand
+}
+
+// NewRecorder returns a Recorder that runs commands with execute.
+func NewRecorder(execute commandlineexecutor.Execute) *Recorder {
+	return &Recorder{execute: execute}
+}
+
+// Execute runs the command with the wrapped executor and records its result.
+func (r *Recorder) Execute(ctx context.Context, params commandlineexecutor.Params) commandlineexecutor.Result {
+	result := r.execute(ctx, params)
+	gr := goldenResult{
+		StdOut:           result.StdOut,
+		StdErr:           result.StdErr,
+		ExitCode:         result.ExitCode,
+		ExecutableFound:  result.ExecutableFound,
+		ExitStatusParsed: result.ExitStatusParsed,
+		TimedOut:         result.TimedOut,
+		StdOutTruncated:  result.StdOutTruncated,
+		StdErrTruncated:  result.StdErrTruncated,
+		Attempts:         result.Attempts,
+	}
+	if result.Error != nil {
+		gr.Error = result.Error.Error()
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.commands = append(r.commands, goldenCommand{Executable: params.Executable, Args: argsString(params), Result: gr})
+	return result
+}
+
+// WriteGolden writes the recorded commands to a golden file at path.
+func (r *Recorder) WriteGolden(path string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	data, err := json.MarshalIndent(r.commands, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, append(data, '\n'), 0644)
+}
+
+// LoadGolden returns an Executor scripted with the commands recorded in the golden file at path.
+// Each recorded command is an exact expectation, so the commands must be executed again with the
+// same executables and arguments.
+func LoadGolden(t testing.TB, path string) *Executor {
+	t.Helper()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("could not read golden file: %v", err)
+	}
+	var commands []goldenCommand
+	if err := json.Unmarshal(data, &commands); err != nil {
+		t.Fatalf("could not parse golden file %s: %v", path, err)
+	}
+	e := New(t)
+	for _, c := range commands {
+		result := commandlineexecutor.Result{
+			StdOut:           c.Result.StdOut,
+			StdErr:           c.Result.StdErr,
+			ExitCode:         c.Result.ExitCode,
+			ExecutableFound:  c.Result.ExecutableFound,
+			ExitStatusParsed: c.Result.ExitStatusParsed,
+			TimedOut:         c.Result.TimedOut,
+			StdOutTruncated:  c.Result.StdOutTruncated,
+			StdErrTruncated:  c.Result.StdErrTruncated,
+			Attempts:         c.Result.Attempts,
+		}
+		if c.Result.Error != "" {
+			result.Error = errors.New(c.Result.Error)
+		}
+		e.Expect(Expectation{Executable: c.Executable, Args: c.Args, Results: []commandlineexecutor.Result{result}})
+	}
+	return e
+}
@@ -0,0 +1,215 @@
+/*
+Copyright 2025 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package commandlineexecutor
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/GoogleCloudPlatform/workloadagentplatform/sharedlibraries/log"
+)
+
+// Priority orders the commands waiting in a Limiter. Higher priorities run first.
+type Priority int
+
+const (
+	// PriorityBackground is for periodic work such as metric collection and status checks.
+	PriorityBackground Priority = iota
+	// PriorityInteractive is for commands a user is waiting on, such as guest actions.
+	PriorityInteractive
+)
+
+type (
+	// LimiterOptions configures the number of commands a Limiter runs at once.
+	LimiterOptions struct {
+		// MaxConcurrent is the maximum number of commands running at once across all pools.
+		// 0 means no global limit.
+		MaxConcurrent int
+		// PoolLimits is the maximum number of commands running at once for each named pool.
+		// Pools without a limit, or with a limit of 0 or less, are only restricted by MaxConcurrent.
+		PoolLimits map[string]int
+	}
+
+	// LimiterStats is a snapshot of the activity of a Limiter.
+	LimiterStats struct {
+		// Running is the number of commands running, in total and by pool.
+		Running       int
+		RunningByPool map[string]int
+		// Queued is the number of commands waiting to run, in total and by pool.
+		Queued       int
+		QueuedByPool map[string]int
+		// Started is the number of commands which have been allowed to run.
+		Started int64
+		// TotalWait and MaxWait are the total and longest time commands waited before running.
+		TotalWait time.Duration
+		MaxWait   time.Duration
+	}
+
+	/*
+		Limiter caps the number of commands running at once, globally and per named pool.
+
+		Commands that cannot run immediately wait in a queue ordered by Priority, and then by arrival.
+		A waiting command whose pool is full does not hold back commands from other pools.
+	*/
+	Limiter struct {
+		opts LimiterOptions
+		now  func() time.Time
+
+		mu            sync.Mutex
+		running       int
+		runningByPool map[string]int
+		waiting       []*limiterWaiter
+		started       int64
+		totalWait     time.Duration
+		maxWait       time.Duration
+	}
+
+	// limiterWaiter is a command waiting in a Limiter queue.
+	limiterWaiter struct {
+		pool     string
+		priority Priority
+		enqueued time.Time
+		ready    chan struct{}
+	}
+)
+
+// NewLimiter returns a Limiter with the options.
+func NewLimiter(opts LimiterOptions) *Limiter {
+	return &Limiter{opts: opts, now: time.Now, runningByPool: make(map[string]int)}
+}
+
+/*
+Execute returns a commandlineexecutor.Execute which runs commands with execute once the pool and
+the Limiter have capacity, waiting in the queue with priority if needed.
+
+If ctx is done while the command is waiting it is not run, and the Result holds the context error.
+*/
+func (l *Limiter) Execute(execute Execute, pool string, priority Priority) Execute {
+	return func(ctx context.Context, params Params) Result {
+		if err := l.acquire(ctx, pool, priority); err != nil {
+			log.CtxLogger(ctx).Debugw("Command was not run while waiting for the limiter", "executable", params.Executable, "pool", pool, "error", err)
+			msg := fmt.Sprintf("Command %q was not run while waiting in pool %q: %v.", params.Executable, pool, err)
+			return Result{StdErr: msg, Error: fmt.Errorf("waiting in pool %s: %w", pool, err)}
+		}
+		defer l.release(pool)
+		return execute(ctx, params)
+	}
+}
+
+// Stats returns a snapshot of the commands running and waiting in the Limiter.
+func (l *Limiter) Stats() LimiterStats {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	s := LimiterStats{
+		Running:       l.running,
+		RunningByPool: make(map[string]int),
+		Queued:        len(l.waiting),
+		QueuedByPool:  make(map[string]int),
+		Started:       l.started,
+		TotalWait:     l.totalWait,
+		MaxWait:       l.maxWait,
+	}
+	for pool, n := range l.runningByPool {
+		if n > 0 {
+			s.RunningByPool[pool] = n
+		}
+	}
+	for _, w := range l.waiting {
+		s.QueuedByPool[w.pool]++
+	}
+	return s
+}
+
+// acquire waits until a command in pool can run and reserves its place.
+func (l *Limiter) acquire(ctx context.Context, pool string, priority Priority) error {
+	l.mu.Lock()
+	w := &limiterWaiter{pool: pool, priority: priority, enqueued: l.now(), ready: make(chan struct{})}
+	// Insert after every waiter of the same or a higher priority.
+	i := len(l.waiting)
+	for i > 0 && l.waiting[i-1].priority < priority {
+		i--
+	}
+	l.waiting = append(l.waiting[:i], append([]*limiterWaiter{w}, l.waiting[i:]...)...)
+	l.dispatch()
+	l.mu.Unlock()
+
+	select {
+	case <-w.ready:
+		return nil
+	case <-ctx.Done():
+		l.mu.Lock()
+		defer l.mu.Unlock()
+		select {
+		case <-w.ready:
+			// The command was started as the context was done, give its place to the next one.
+			l.releaseLocked(pool)
+		default:
+			l.remove(w)
+		}
+		return ctx.Err()
+	}
+}
+
+// release frees the place of a finished command in pool.
+func (l *Limiter) release(pool string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.releaseLocked(pool)
+}
+
+func (l *Limiter) releaseLocked(pool string) {
+	l.running--
+	l.runningByPool[pool]--
+	l.dispatch()
+}
+
+// dispatch starts waiting commands, in queue order, while there is capacity for them.
+// l.mu must be held.
+func (l *Limiter) dispatch() {
+	for i := 0; i < len(l.waiting); {
+		if l.opts.MaxConcurrent > 0 && l.running >= l.opts.MaxConcurrent {
+			return
+		}
+		w := l.waiting[i]
+		if limit := l.opts.PoolLimits[w.pool]; limit > 0 && l.runningByPool[w.pool] >= limit {
+			i++
+			continue
+		}
+		l.waiting = append(l.waiting[:i], l.waiting[i+1:]...)
+		l.running++
+		l.runningByPool[w.pool]++
+		l.started++
+		wait := l.now().Sub(w.enqueued)
+		l.totalWait += wait
+		if wait > l.maxWait {
+			l.maxWait = wait
+		}
+		close(w.ready)
+	}
+}
+
+// remove takes w out of the queue. l.mu must be held.
+func (l *Limiter) remove(w *limiterWaiter) {
+	for i, q := range l.waiting {
+		if q == w {
+			l.waiting = append(l.waiting[:i], l.waiting[i+1:]...)
+			return
+		}
+	}
+}
@@ -0,0 +1,263 @@
+/*
+Copyright 2025 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package commandlineexecutor
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+// blockingExecutor runs commands which block until they are released by name.
+type blockingExecutor struct {
+	mu       sync.Mutex
+	running  int
+	max      int
+	order    []string
+	releases map[string]chan struct{}
+}
+
+func newBlockingExecutor(names ...string) *blockingExecutor {
+	b := &blockingExecutor{releases: make(map[string]chan struct{})}
+	for _, n := range names {
+		b.releases[n] = make(chan struct{})
+	}
+	return b
+}
+
+func (b *blockingExecutor) execute(ctx context.Context, params Params) Result {
+	b.mu.Lock()
+	b.running++
+	b.max = max(b.max, b.running)
+	b.order = append(b.order, params.Executable)
+	b.mu.Unlock()
+	<-b.releases[params.Executable]
+	b.mu.Lock()
+	b.running--
+	b.mu.Unlock()
+	return Result{StdOut: params.Executable}
+}
+
+func (b *blockingExecutor) started() []string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return append([]string(nil), b.order...)
+}
+
+// waitFor polls until cond is true or fails the test after a few seconds.
+func waitFor(t *testing.T, desc string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("Timed out waiting for %s", desc)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestLimiterGlobalLimit(t *testing.T) {
+	names := []string{"a", "b", "c", "d", "e"}
+	b := newBlockingExecutor(names...)
+	l := NewLimiter(LimiterOptions{MaxConcurrent: 2})
+	execute := l.Execute(b.execute, "metrics", PriorityBackground)
+
+	var wg sync.WaitGroup
+	for _, n := range names {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if got := execute(context.Background(), Params{Executable: n}); got.StdOut != n {
+				t.Errorf("Execute(%q) returned stdout: %q, want: %q", n, got.StdOut, n)
+			}
+		}()
+	}
+	waitFor(t, "2 commands to run and 3 to queue", func() bool {
+		s := l.Stats()
+		return s.Running == 2 && s.Queued == 3
+	})
+	if diff := cmp.Diff(map[string]int{"metrics": 3}, l.Stats().QueuedByPool); diff != "" {
+		t.Errorf("Stats().QueuedByPool returned unexpected diff (-want +got):\n%s", diff)
+	}
+	for _, n := range names {
+		close(b.releases[n])
+	}
+	wg.Wait()
+
+	if b.max != 2 {
+		t.Errorf("Limiter ran %d commands at once, want 2", b.max)
+	}
+	s := l.Stats()
+	if s.Running != 0 || s.Queued != 0 || s.Started != 5 {
+		t.Errorf("Stats() after completion = running: %d, queued: %d, started: %d, want 0, 0, 5", s.Running, s.Queued, s.Started)
+	}
+}
+
+func TestLimiterPriority(t *testing.T) {
+	b := newBlockingExecutor("first", "metric1", "metric2", "guestaction")
+	l := NewLimiter(LimiterOptions{MaxConcurrent: 1})
+	background := l.Execute(b.execute, "metrics", PriorityBackground)
+	interactive := l.Execute(b.execute, "guestactions", PriorityInteractive)
+
+	var wg sync.WaitGroup
+	run := func(execute Execute, name string, queued int) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			execute(context.Background(), Params{Executable: name})
+		}()
+		waitFor(t, name+" to run or queue", func() bool {
+			s := l.Stats()
+			return s.Running == 1 && s.Queued == queued
+		})
+	}
+	run(background, "first", 0)
+	run(background, "metric1", 1)
+	run(background, "metric2", 2)
+	run(interactive, "guestaction", 3)
+	for _, n := range []string{"first", "guestaction", "metric1", "metric2"} {
+		close(b.releases[n])
+	}
+	wg.Wait()
+
+	want := []string{"first", "guestaction", "metric1", "metric2"}
+	if diff := cmp.Diff(want, b.started()); diff != "" {
+		t.Errorf("Limiter started commands in unexpected order (-want +got):\n%s", diff)
+	}
+}
+
+func TestLimiterPoolLimit(t *testing.T) {
+	b := newBlockingExecutor("metric1", "metric2", "guestaction")
+	l := NewLimiter(LimiterOptions{MaxConcurrent: 3, PoolLimits: map[string]int{"metrics": 1}})
+	background := l.Execute(b.execute, "metrics", PriorityBackground)
+	interactive := l.Execute(b.execute, "guestactions", PriorityInteractive)
+
+	var wg sync.WaitGroup
+	for _, n := range []string{"metric1", "metric2"} {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			background(context.Background(), Params{Executable: n})
+		}()
+	}
+	waitFor(t, "one metric to run and one to queue", func() bool {
+		s := l.Stats()
+		return s.Running == 1 && s.Queued == 1
+	})
+	// The queued metric does not hold back a command from another pool.
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		interactive(context.Background(), Params{Executable: "guestaction"})
+	}()
+	waitFor(t, "the guest action to run", func() bool { return l.Stats().Running == 2 })
+	if diff := cmp.Diff(map[string]int{"metrics": 1, "guestactions": 1}, l.Stats().RunningByPool); diff != "" {
+		t.Errorf("Stats().RunningByPool returned unexpected diff (-want +got):\n%s", diff)
+	}
+	for _, ch := range b.releases {
+		close(ch)
+	}
+	wg.Wait()
+	if b.max != 2 {
+		t.Errorf("Limiter ran %d commands at once, want 2", b.max)
+	}
+}
+
+func TestLimiterUnlimitedPool(t *testing.T) {
+	b := newBlockingExecutor("metric1", "metric2")
+	l := NewLimiter(LimiterOptions{PoolLimits: map[string]int{"metrics": 0}})
+	background := l.Execute(b.execute, "metrics", PriorityBackground)
+
+	var wg sync.WaitGroup
+	for _, n := range []string{"metric1", "metric2"} {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			background(context.Background(), Params{Executable: n})
+		}()
+	}
+	waitFor(t, "both metrics to run", func() bool { return l.Stats().Running == 2 })
+	for _, ch := range b.releases {
+		close(ch)
+	}
+	wg.Wait()
+}
+
+func TestLimiterContextDone(t *testing.T) {
+	b := newBlockingExecutor("first", "never")
+	l := NewLimiter(LimiterOptions{MaxConcurrent: 1})
+	execute := l.Execute(b.execute, "metrics", PriorityBackground)
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		execute(context.Background(), Params{Executable: "first"})
+	}()
+	waitFor(t, "first to run", func() bool { return l.Stats().Running == 1 })
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	got := execute(ctx, Params{Executable: "never"})
+	if !errors.Is(got.Error, context.DeadlineExceeded) {
+		t.Errorf("Execute() with expired context returned error: %v, want: %v", got.Error, context.DeadlineExceeded)
+	}
+	if s := l.Stats(); s.Queued != 0 || s.Running != 1 {
+		t.Errorf("Stats() after expired context = queued: %d, running: %d, want 0, 1", s.Queued, s.Running)
+	}
+	close(b.releases["first"])
+	<-done
+	if diff := cmp.Diff([]string{"first"}, b.started()); diff != "" {
+		t.Errorf("Limiter started unexpected commands (-want +got):\n%s", diff)
+	}
+}
+
+func TestLimiterWaitStats(t *testing.T) {
+	now := time.Unix(1000, 0)
+	l := NewLimiter(LimiterOptions{MaxConcurrent: 1})
+	l.now = func() time.Time { return now }
+
+	ctx := context.Background()
+	if err := l.acquire(ctx, "metrics", PriorityBackground); err != nil {
+		t.Fatalf("acquire() returned unexpected error: %v", err)
+	}
+	acquired := make(chan error)
+	go func() { acquired <- l.acquire(ctx, "metrics", PriorityBackground) }()
+	waitFor(t, "the second command to queue", func() bool { return l.Stats().Queued == 1 })
+	l.mu.Lock()
+	now = now.Add(3 * time.Second)
+	l.mu.Unlock()
+	l.release("metrics")
+	if err := <-acquired; err != nil {
+		t.Fatalf("acquire() returned unexpected error: %v", err)
+	}
+	l.release("metrics")
+
+	want := LimiterStats{
+		RunningByPool: map[string]int{},
+		QueuedByPool:  map[string]int{},
+		Started:       2,
+		TotalWait:     3 * time.Second,
+		MaxWait:       3 * time.Second,
+	}
+	if diff := cmp.Diff(want, l.Stats()); diff != "" {
+		t.Errorf("Stats() returned unexpected diff (-want +got):\n%s", diff)
+	}
+}
@@ -0,0 +1,245 @@
+/*
+Copyright 2025 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package commandlineexecutor
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+)
+
+// Formats reported in a ParseError.
+const (
+	FormatJSON     = "json"
+	FormatProto    = "proto"
+	FormatKeyValue = "key-value"
+	FormatTable    = "table"
+	FormatInteger  = "integer"
+	FormatValue    = "value"
+	FormatVersion  = "version"
+)
+
+/*
+Version is a version made of dot separated numbers followed by free form text, as parsed by
+ParseVersion. For example "150500.55.73-default" has the Numbers 150500, 55 and 73 and the
+Remainder "default".
+*/
+type Version struct {
+	Numbers []int64
+	// Remainder is the text following the numbers and the "." or "-" separating it from them.
+	Remainder string
+}
+
+// ParseError is returned when the standard output of a command could not be parsed.
+type ParseError struct {
+	// Format is the format the output was expected to be in, one of the Format constants.
+	Format string
+	// Line is the 1-based line of the output that could not be parsed, 0 if not applicable.
+	Line int
+	// Text is the text that could not be parsed.
+	Text string
+	// Reason describes what was wrong with the text.
+	Reason string
+	// Err is the underlying error, if any.
+	Err error
+}
+
+func (e *ParseError) Error() string {
+	msg := fmt.Sprintf("could not parse command output as %s", e.Format)
+	if e.Line > 0 {
+		msg += fmt.Sprintf(" at line %d", e.Line)
+	}
+	if e.Reason != "" {
+		msg += ": " + e.Reason
+	}
+	if e.Text != "" {
+		msg += fmt.Sprintf(" in %q", e.Text)
+	}
+	if e.Err != nil {
+		msg += ": " + e.Err.Error()
+	}
+	return msg
+}
+
+func (e *ParseError) Unwrap() error {
+	return e.Err
+}
+
+// DecodeJSON decodes the standard output as JSON into v.
+func (r Result) DecodeJSON(v any) error {
+	if err := json.Unmarshal([]byte(r.StdOut), v); err != nil {
+		return &ParseError{Format: FormatJSON, Err: err}
+	}
+	return nil
+}
+
+// DecodeProto decodes the standard output as the JSON representation of m.
+// Fields that are not defined in m are ignored.
+func (r Result) DecodeProto(m proto.Message) error {
+	if err := (protojson.UnmarshalOptions{DiscardUnknown: true}).Unmarshal([]byte(r.StdOut), m); err != nil {
+		return &ParseError{Format: FormatProto, Err: err}
+	}
+	return nil
+}
+
+/*
+KeyValues parses the standard output as one key and value per line, separated by sep, e.g. "="
+for "key=value" or ":" for "key: value".
+
+Blank lines and lines starting with "#" are ignored. Keys and values are trimmed of surrounding
+whitespace, and values are also trimmed of one pair of surrounding quotes, as in /etc/os-release.
+If a key is repeated the last value is kept.
+*/
+func (r Result) KeyValues(sep string) (map[string]string, error) {
+	kv := make(map[string]string)
+	for i, line := range strings.Split(r.StdOut, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		key, value, found := strings.Cut(line, sep)
+		key = strings.TrimSpace(key)
+		if !found || key == "" {
+			return nil, &ParseError{Format: FormatKeyValue, Line: i + 1, Text: line, Reason: fmt.Sprintf("expected key%svalue", sep)}
+		}
+		kv[key] = unquote(strings.TrimSpace(value))
+	}
+	return kv, nil
+}
+
+/*
+Table parses the standard output as a whitespace aligned table whose first non-blank line holds
+the column headers, such as the output of df, ps or systemctl list-units.
+
+Each row is returned as a map from header to value. Values are separated by whitespace, so only the
+last column may contain values with spaces in them.
+*/
+func (r Result) Table() ([]map[string]string, error) {
+	var headers []string
+	var rows []map[string]string
+	for i, line := range strings.Split(r.StdOut, "\n") {
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+		if headers == nil {
+			headers = strings.Fields(line)
+			continue
+		}
+		fields := strings.Fields(line)
+		if len(fields) < len(headers) {
+			return nil, &ParseError{Format: FormatTable, Line: i + 1, Text: line, Reason: fmt.Sprintf("expected %d columns, got %d", len(headers), len(fields))}
+		}
+		row := make(map[string]string, len(headers))
+		last := len(headers) - 1
+		for c := 0; c < last; c++ {
+			row[headers[c]] = fields[c]
+		}
+		// The last column keeps the rest of the line, including its inner whitespace.
+		row[headers[last]] = lastColumn(line, last)
+		rows = append(rows, row)
+	}
+	if headers == nil {
+		return nil, &ParseError{Format: FormatTable, Reason: "no header line"}
+	}
+	return rows, nil
+}
+
+// Integers parses the standard output as whitespace separated base 10 integers, such as the
+// output of "id -G".
+func (r Result) Integers() ([]int64, error) {
+	var ints []int64
+	for i, line := range strings.Split(r.StdOut, "\n") {
+		for _, f := range strings.Fields(line) {
+			n, err := strconv.ParseInt(f, 10, 64)
+			if err != nil {
+				return nil, &ParseError{Format: FormatInteger, Line: i + 1, Text: f, Err: err}
+			}
+			ints = append(ints, n)
+		}
+	}
+	return ints, nil
+}
+
+// Value parses the standard output as a single value, such as the output of "uname -r" or
+// "systemctl is-enabled", and returns it trimmed of surrounding whitespace.
+func (r Result) Value() (string, error) {
+	value := strings.TrimSpace(r.StdOut)
+	if lines := strings.Count(value, "\n") + 1; lines > 1 {
+		return "", &ParseError{Format: FormatValue, Text: value, Reason: fmt.Sprintf("expected a single line, got %d", lines)}
+	}
+	return value, nil
+}
+
+/*
+ParseVersion parses the dot separated numbers at the start of s, at most max of them unless max is
+0, such as the numbers of a kernel release. The text after the numbers, without a "." or "-"
+separating it from them, is the Remainder.
+*/
+func ParseVersion(s string, max int) (Version, error) {
+	var v Version
+	rest := s
+	for {
+		end := strings.IndexFunc(rest, notDigit)
+		if end < 0 {
+			end = len(rest)
+		}
+		if end == 0 {
+			return Version{}, &ParseError{Format: FormatVersion, Text: s, Reason: "expected a number"}
+		}
+		n, err := strconv.ParseInt(rest[:end], 10, 64)
+		if err != nil {
+			return Version{}, &ParseError{Format: FormatVersion, Text: s, Err: err}
+		}
+		v.Numbers = append(v.Numbers, n)
+		rest = rest[end:]
+		// Only a "." directly followed by a digit starts another number.
+		if len(v.Numbers) == max || len(rest) < 2 || rest[0] != '.' || notDigit(rune(rest[1])) {
+			break
+		}
+		rest = rest[1:]
+	}
+	if strings.HasPrefix(rest, ".") || strings.HasPrefix(rest, "-") {
+		rest = rest[1:]
+	}
+	v.Remainder = rest
+	return v, nil
+}
+
+func notDigit(r rune) bool {
+	return r < '0' || r > '9'
+}
+
+// lastColumn returns the text of line starting at the field with index n.
+func lastColumn(line string, n int) string {
+	rest := strings.TrimSpace(line)
+	for i := 0; i < n; i++ {
+		rest = strings.TrimLeft(rest[strings.IndexAny(rest, " \t"):], " \t")
+	}
+	return rest
+}
+
+// unquote removes one pair of matching single or double quotes surrounding s.
+func unquote(s string) string {
+	if len(s) >= 2 && (s[0] == '"' || s[0] == '\'') && s[len(s)-1] == s[0] {
+		return s[1 : len(s)-1]
+	}
+	return s
+}
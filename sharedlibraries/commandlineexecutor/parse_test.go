@@ -0,0 +1,345 @@
+/*
+Copyright 2025 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package commandlineexecutor
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"google.golang.org/protobuf/testing/protocmp"
+
+	wpb "google.golang.org/protobuf/types/known/wrapperspb"
+)
+
+func TestDecodeJSON(t *testing.T) {
+	type disk struct {
+		Name string `json:"name"`
+		Size int    `json:"size"`
+	}
+	tests := []struct {
+		name    string
+		stdout  string
+		want    disk
+		wantErr bool
+	}{
+		{
+			name:   "Success",
+			stdout: `{"name": "sda", "size": 100}`,
+			want:   disk{Name: "sda", Size: 100},
+		},
+		{
+			name:    "InvalidJSON",
+			stdout:  `{"name": "sda",`,
+			wantErr: true,
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			var got disk
+			err := Result{StdOut: tc.stdout}.DecodeJSON(&got)
+			if gotErr := err != nil; gotErr != tc.wantErr {
+				t.Fatalf("DecodeJSON() returned error: %v, wantErr: %t", err, tc.wantErr)
+			}
+			var parseErr *ParseError
+			if tc.wantErr && (!errors.As(err, &parseErr) || parseErr.Format != FormatJSON) {
+				t.Errorf("DecodeJSON() returned error: %v, want a ParseError with format %q", err, FormatJSON)
+			}
+			if diff := cmp.Diff(tc.want, got); diff != "" {
+				t.Errorf("DecodeJSON() returned diff (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestDecodeProto(t *testing.T) {
+	tests := []struct {
+		name    string
+		stdout  string
+		want    *wpb.StringValue
+		wantErr bool
+	}{
+		{
+			name:   "Success",
+			stdout: `"hello"`,
+			want:   wpb.String("hello"),
+		},
+		{
+			name:    "WrongType",
+			stdout:  `123`,
+			want:    &wpb.StringValue{},
+			wantErr: true,
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			got := &wpb.StringValue{}
+			err := Result{StdOut: tc.stdout}.DecodeProto(got)
+			if gotErr := err != nil; gotErr != tc.wantErr {
+				t.Fatalf("DecodeProto() returned error: %v, wantErr: %t", err, tc.wantErr)
+			}
+			if diff := cmp.Diff(tc.want, got, protocmp.Transform()); diff != "" {
+				t.Errorf("DecodeProto() returned diff (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestKeyValues(t *testing.T) {
+	tests := []struct {
+		name     string
+		stdout   string
+		sep      string
+		want     map[string]string
+		wantLine int
+	}{
+		{
+			name:   "OSRelease",
+			stdout: "# comment\nNAME=\"SLES\"\nVERSION_ID='15.5'\n\nID=sles\n",
+			sep:    "=",
+			want:   map[string]string{"NAME": "SLES", "VERSION_ID": "15.5", "ID": "sles"},
+		},
+		{
+			name:   "ColonSeparated",
+			stdout: "Id: agent.service\nDescription: Workload Agent: main service\n",
+			sep:    ":",
+			want:   map[string]string{"Id": "agent.service", "Description": "Workload Agent: main service"},
+		},
+		{
+			name:   "EmptyValue",
+			stdout: "KEY=\n",
+			sep:    "=",
+			want:   map[string]string{"KEY": ""},
+		},
+		{
+			name:     "MissingSeparator",
+			stdout:   "KEY=value\ninvalid line\n",
+			sep:      "=",
+			wantLine: 2,
+		},
+		{
+			name:     "MissingKey",
+			stdout:   "=value\n",
+			sep:      "=",
+			wantLine: 1,
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := Result{StdOut: tc.stdout}.KeyValues(tc.sep)
+			if tc.wantLine != 0 {
+				var parseErr *ParseError
+				if !errors.As(err, &parseErr) || parseErr.Line != tc.wantLine {
+					t.Errorf("KeyValues(%q) returned error: %v, want a ParseError at line %d", tc.sep, err, tc.wantLine)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("KeyValues(%q) returned unexpected error: %v", tc.sep, err)
+			}
+			if diff := cmp.Diff(tc.want, got); diff != "" {
+				t.Errorf("KeyValues(%q) returned diff (-want +got):\n%s", tc.sep, diff)
+			}
+		})
+	}
+}
+
+func TestTable(t *testing.T) {
+	tests := []struct {
+		name     string
+		stdout   string
+		want     []map[string]string
+		wantErr  bool
+		wantLine int
+	}{
+		{
+			name: "SystemctlListUnits",
+			stdout: "UNIT            LOAD   ACTIVE SUB     DESCRIPTION\n" +
+				"sshd.service    loaded active running OpenSSH Daemon\n" +
+				"agent.service   loaded failed failed  Google Cloud Workload Agent\n",
+			want: []map[string]string{
+				{"UNIT": "sshd.service", "LOAD": "loaded", "ACTIVE": "active", "SUB": "running", "DESCRIPTION": "OpenSSH Daemon"},
+				{"UNIT": "agent.service", "LOAD": "loaded", "ACTIVE": "failed", "SUB": "failed", "DESCRIPTION": "Google Cloud Workload Agent"},
+			},
+		},
+		{
+			name:   "HeaderOnly",
+			stdout: "\nPID CMD\n",
+		},
+		{
+			name:    "Empty",
+			stdout:  "",
+			wantErr: true,
+		},
+		{
+			name:     "MissingColumns",
+			stdout:   "PID USER CMD\n1 root\n",
+			wantErr:  true,
+			wantLine: 2,
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := Result{StdOut: tc.stdout}.Table()
+			if gotErr := err != nil; gotErr != tc.wantErr {
+				t.Fatalf("Table() returned error: %v, wantErr: %t", err, tc.wantErr)
+			}
+			var parseErr *ParseError
+			if tc.wantErr && (!errors.As(err, &parseErr) || parseErr.Line != tc.wantLine) {
+				t.Errorf("Table() returned error: %v, want a ParseError at line %d", err, tc.wantLine)
+			}
+			if diff := cmp.Diff(tc.want, got); diff != "" {
+				t.Errorf("Table() returned diff (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestIntegers(t *testing.T) {
+	tests := []struct {
+		name    string
+		stdout  string
+		want    []int64
+		wantErr bool
+	}{
+		{
+			name:   "Groups",
+			stdout: "1002 2001\n",
+			want:   []int64{1002, 2001},
+		},
+		{
+			name:   "MultipleLines",
+			stdout: "1\n-2\n",
+			want:   []int64{1, -2},
+		},
+		{
+			name:    "NotANumber",
+			stdout:  "1002 abc\n",
+			wantErr: true,
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := Result{StdOut: tc.stdout}.Integers()
+			if gotErr := err != nil; gotErr != tc.wantErr {
+				t.Fatalf("Integers() returned error: %v, wantErr: %t", err, tc.wantErr)
+			}
+			if diff := cmp.Diff(tc.want, got); diff != "" {
+				t.Errorf("Integers() returned diff (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestValue(t *testing.T) {
+	tests := []struct {
+		name    string
+		stdout  string
+		want    string
+		wantErr bool
+	}{
+		{
+			name:   "Trimmed",
+			stdout: " enabled\n",
+			want:   "enabled",
+		},
+		{
+			name:   "Empty",
+			stdout: "\n",
+			want:   "",
+		},
+		{
+			name:    "MultipleLines",
+			stdout:  "enabled\nactive\n",
+			wantErr: true,
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := Result{StdOut: tc.stdout}.Value()
+			if gotErr := err != nil; gotErr != tc.wantErr {
+				t.Fatalf("Value() returned error: %v, wantErr: %t", err, tc.wantErr)
+			}
+			if got != tc.want {
+				t.Errorf("Value() = %q, want: %q", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestParseVersion(t *testing.T) {
+	tests := []struct {
+		name    string
+		s       string
+		max     int
+		want    Version
+		wantErr bool
+	}{
+		{
+			name: "KernelRelease",
+			s:    "5.14.21",
+			max:  4,
+			want: Version{Numbers: []int64{5, 14, 21}},
+		},
+		{
+			name: "DashRemainder",
+			s:    "150500.55.73-default",
+			max:  4,
+			want: Version{Numbers: []int64{150500, 55, 73}, Remainder: "default"},
+		},
+		{
+			name: "DotRemainder",
+			s:    "102.17.1.el8.x86_64",
+			max:  4,
+			want: Version{Numbers: []int64{102, 17, 1}, Remainder: "el8.x86_64"},
+		},
+		{
+			name: "MaxNumbers",
+			s:    "1.2.3.4.5-x",
+			max:  4,
+			want: Version{Numbers: []int64{1, 2, 3, 4}, Remainder: "5-x"},
+		},
+		{
+			name: "Unlimited",
+			s:    "1.2.3.4.5-x",
+			want: Version{Numbers: []int64{1, 2, 3, 4, 5}, Remainder: "x"},
+		},
+		{
+			name:    "NotANumber",
+			s:       "Major.Minor",
+			max:     4,
+			wantErr: true,
+		},
+		{
+			name:    "OutOfRange",
+			s:       "99999999999999999999.1",
+			max:     4,
+			wantErr: true,
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := ParseVersion(tc.s, tc.max)
+			if gotErr := err != nil; gotErr != tc.wantErr {
+				t.Fatalf("ParseVersion(%q, %d) returned error: %v, wantErr: %t", tc.s, tc.max, err, tc.wantErr)
+			}
+			if diff := cmp.Diff(tc.want, got); diff != "" {
+				t.Errorf("ParseVersion(%q, %d) returned diff (-want +got):\n%s", tc.s, tc.max, diff)
+			}
+		})
+	}
+}
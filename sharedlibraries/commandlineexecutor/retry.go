@@ -0,0 +1,109 @@
+/*
+Copyright 2025 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package commandlineexecutor
+
+import (
+	"context"
+	"errors"
+	"regexp"
+	"slices"
+	"time"
+
+	"github.com/cenkalti/backoff/v4"
+	"github.com/GoogleCloudPlatform/workloadagentplatform/sharedlibraries/log"
+)
+
+const defaultMaxAttempts = 3
+
+var errRetryable = errors.New("command result is retryable")
+
+/*
+RetryPolicy runs a command again while its Result is retryable.
+
+Each attempt runs the command from the start, so the writers and callbacks of Params.Stream receive
+the output of every attempt, including the attempts which are retried.
+*/
+type RetryPolicy struct {
+	// MaxAttempts is the maximum number of times the command is run, including the first.
+	// Defaults to 3.
+	MaxAttempts int
+	// BackOff returns the wait between attempts. It is called once for every execution so that
+	// concurrent executions do not share the state of a backoff.
+	// Defaults to an exponential backoff starting at 1 second.
+	BackOff func() backoff.BackOff
+	// Retryable decides whether the command should be run again after it returned the Result.
+	// Defaults to retrying on any error or non-zero exit code, unless the executable was not found.
+	Retryable func(Result) bool
+}
+
+// RetryOnExitCodes returns a RetryPolicy.Retryable which retries when the command exits with one
+// of the exit codes.
+func RetryOnExitCodes(codes ...int) func(Result) bool {
+	return func(r Result) bool {
+		return slices.Contains(codes, r.ExitCode)
+	}
+}
+
+// RetryOnStdErr returns a RetryPolicy.Retryable which retries when the standard error of the
+// command matches the regular expression.
+func RetryOnStdErr(pattern *regexp.Regexp) func(Result) bool {
+	return func(r Result) bool {
+		return pattern.MatchString(r.StdErr)
+	}
+}
+
+// defaultRetryable retries any failure other than the executable not being found.
+func defaultRetryable(r Result) bool {
+	return r.ExecutableFound && (r.Error != nil || r.ExitCode != 0)
+}
+
+// execute runs the command with runOnce until its Result is not retryable, the attempts are
+// exhausted, or ctx is done. The Result of the last attempt is returned with its Attempts set.
+func (p *RetryPolicy) execute(ctx context.Context, params Params, runOnce Execute) Result {
+	maxAttempts := p.MaxAttempts
+	if maxAttempts <= 0 {
+		maxAttempts = defaultMaxAttempts
+	}
+	var bo backoff.BackOff
+	if p.BackOff != nil {
+		bo = p.BackOff()
+	} else {
+		exp := backoff.NewExponentialBackOff()
+		exp.InitialInterval = time.Second
+		exp.MaxElapsedTime = 0
+		bo = exp
+	}
+	retryable := p.Retryable
+	if retryable == nil {
+		retryable = defaultRetryable
+	}
+
+	var result Result
+	attempts := 0
+	backoff.Retry(func() error {
+		attempts++
+		result = runOnce(ctx, params)
+		if attempts < maxAttempts && retryable(result) {
+			log.CtxLogger(ctx).Debugw("Retrying command", "executable", params.Executable, "attempt", attempts,
+				"exitCode", result.ExitCode, "error", result.Error)
+			return errRetryable
+		}
+		return nil
+	}, backoff.WithContext(backoff.WithMaxRetries(bo, uint64(maxAttempts-1)), ctx))
+	result.Attempts = attempts
+	return result
+}
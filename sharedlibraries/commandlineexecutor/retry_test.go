@@ -0,0 +1,157 @@
+/*
+Copyright 2025 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package commandlineexecutor
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"regexp"
+	"testing"
+
+	"github.com/cenkalti/backoff/v4"
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
+)
+
+func TestRetryPolicy(t *testing.T) {
+	failure := Result{ExitCode: 1, StdErr: "Failed to connect to bus: Connection refused", Error: errors.New("exit status 1"), ExecutableFound: true}
+	success := Result{StdOut: "active\n", ExecutableFound: true}
+	notFound := Result{StdErr: "not found", Error: errors.New("not found")}
+	tests := []struct {
+		name    string
+		policy  RetryPolicy
+		results []Result
+		want    Result
+	}{
+		{
+			name:    "SucceedsFirstTime",
+			policy:  RetryPolicy{},
+			results: []Result{success},
+			want:    Result{StdOut: "active\n", ExecutableFound: true, Attempts: 1},
+		},
+		{
+			name:    "SucceedsAfterRetries",
+			policy:  RetryPolicy{MaxAttempts: 5},
+			results: []Result{failure, failure, success},
+			want:    Result{StdOut: "active\n", ExecutableFound: true, Attempts: 3},
+		},
+		{
+			name:    "DefaultMaxAttempts",
+			policy:  RetryPolicy{},
+			results: []Result{failure, failure, failure, success},
+			want:    Result{ExitCode: 1, StdErr: failure.StdErr, Error: cmpopts.AnyError, ExecutableFound: true, Attempts: 3},
+		},
+		{
+			name:    "NotFoundIsNotRetried",
+			policy:  RetryPolicy{},
+			results: []Result{notFound, success},
+			want:    Result{StdErr: "not found", Error: cmpopts.AnyError, Attempts: 1},
+		},
+		{
+			name:    "RetryOnExitCodes",
+			policy:  RetryPolicy{Retryable: RetryOnExitCodes(3)},
+			results: []Result{{ExitCode: 3}, {ExitCode: 1}, success},
+			want:    Result{ExitCode: 1, Attempts: 2},
+		},
+		{
+			name:    "RetryOnStdErr",
+			policy:  RetryPolicy{Retryable: RetryOnStdErr(regexp.MustCompile(`Connection refused`))},
+			results: []Result{failure, success},
+			want:    Result{StdOut: "active\n", ExecutableFound: true, Attempts: 2},
+		},
+		{
+			name:    "StdErrDoesNotMatch",
+			policy:  RetryPolicy{Retryable: RetryOnStdErr(regexp.MustCompile(`timed out`))},
+			results: []Result{failure, success},
+			want:    Result{ExitCode: 1, StdErr: failure.StdErr, Error: cmpopts.AnyError, ExecutableFound: true, Attempts: 1},
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			calls := 0
+			runOnce := func(context.Context, Params) Result {
+				calls++
+				return tc.results[calls-1]
+			}
+			tc.policy.BackOff = zeroBackOff
+			got := tc.policy.execute(context.Background(), Params{Executable: "systemctl"}, runOnce)
+			if diff := cmp.Diff(tc.want, got, cmpopts.EquateErrors()); diff != "" {
+				t.Errorf("execute() returned unexpected diff (-want +got):\n%s", diff)
+			}
+			if calls != tc.want.Attempts {
+				t.Errorf("execute() ran the command %d times, want %d", calls, tc.want.Attempts)
+			}
+		})
+	}
+}
+
+func TestRetryPolicyContextCancelled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	calls := 0
+	runOnce := func(context.Context, Params) Result {
+		calls++
+		cancel()
+		return Result{ExitCode: 1, ExecutableFound: true}
+	}
+	policy := &RetryPolicy{MaxAttempts: 5, BackOff: zeroBackOff}
+	got := policy.execute(ctx, Params{Executable: "systemctl"}, runOnce)
+	if got.Attempts != 1 || calls != 1 {
+		t.Errorf("execute() with cancelled context ran %d times and reported %d attempts, want 1", calls, got.Attempts)
+	}
+}
+
+func TestRetryPolicyBackOffPerExecution(t *testing.T) {
+	var backOffs []*backoff.ConstantBackOff
+	policy := &RetryPolicy{MaxAttempts: 2, BackOff: func() backoff.BackOff {
+		b := backoff.NewConstantBackOff(0)
+		backOffs = append(backOffs, b)
+		return b
+	}}
+	runOnce := func(context.Context, Params) Result {
+		return Result{ExitCode: 1, ExecutableFound: true}
+	}
+	for i := 0; i < 2; i++ {
+		policy.execute(context.Background(), Params{Executable: "systemctl"}, runOnce)
+	}
+	if len(backOffs) != 2 || backOffs[0] == backOffs[1] {
+		t.Errorf("execute() twice created backoffs %v, want a new backoff for each execution", backOffs)
+	}
+}
+
+func TestExecuteCommandWithRetry(t *testing.T) {
+	setDefaults()
+	// The script fails until it has been run twice before.
+	counter := filepath.Join(t.TempDir(), "counter")
+	result := ExecuteCommand(context.Background(), Params{
+		Executable: "sh",
+		Args:       []string{"-c", `echo x >> "$0"; [ $(wc -l < "$0") -ge 3 ] && echo done`, counter},
+		Retry:      &RetryPolicy{MaxAttempts: 4, BackOff: zeroBackOff},
+	})
+	if result.Error != nil || result.StdOut != "done\n" || result.Attempts != 3 {
+		t.Errorf("ExecuteCommand() with retry returned stdout: %q, error: %v, attempts: %d, want: \"done\\n\", nil, 3", result.StdOut, result.Error, result.Attempts)
+	}
+
+	result = ExecuteCommand(context.Background(), Params{Executable: "true"})
+	if result.Attempts != 1 {
+		t.Errorf("ExecuteCommand() without retry reported %d attempts, want 1", result.Attempts)
+	}
+}
+
+func zeroBackOff() backoff.BackOff {
+	return &backoff.ZeroBackOff{}
+}
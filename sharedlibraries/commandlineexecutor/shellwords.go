@@ -0,0 +1,105 @@
+/*
+Copyright 2025 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package commandlineexecutor
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// ErrUnbalancedQuotes is returned when ArgsToSplit has a quote or escape that is not terminated.
+var ErrUnbalancedQuotes = errors.New("unbalanced quotes")
+
+/*
+splitShellWords splits s into arguments following the POSIX shell rules for quoting, without
+performing any expansion or substitution.
+
+  - Unquoted blanks and newlines separate arguments.
+  - A backslash outside of quotes preserves the next character literally, and a backslash followed
+    by a newline is removed.
+  - Single quotes preserve every character up to the closing single quote.
+  - Double quotes preserve every character up to the closing double quote, except that a backslash
+    escapes a following $, `, ", \ or newline.
+  - Quoted empty strings are kept as empty arguments.
+
+ex:
+-c "echo \"$0\" | grep -c \`uname\`" my\ file
+becomes:
+{"-c", "echo \"$0\" | grep -c `uname`", "my file"}
+*/
+func splitShellWords(s string) ([]string, error) {
+	var args []string
+	var word strings.Builder
+	inWord := false
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n':
+			if inWord {
+				args = append(args, word.String())
+				word.Reset()
+				inWord = false
+			}
+		case c == '\\':
+			if i+1 == len(s) {
+				return nil, fmt.Errorf("%w: trailing backslash at position %d", ErrUnbalancedQuotes, i)
+			}
+			i++
+			if s[i] != '\n' {
+				word.WriteByte(s[i])
+				inWord = true
+			}
+		case c == '\'':
+			end := strings.IndexByte(s[i+1:], '\'')
+			if end < 0 {
+				return nil, fmt.Errorf("%w: unterminated single quote at position %d", ErrUnbalancedQuotes, i)
+			}
+			word.WriteString(s[i+1 : i+1+end])
+			i += end + 1
+			inWord = true
+		case c == '"':
+			start := i
+			closed := false
+			for i++; i < len(s); i++ {
+				if s[i] == '"' {
+					closed = true
+					break
+				}
+				if s[i] == '\\' && i+1 < len(s) && strings.IndexByte("$`\"\\\n", s[i+1]) >= 0 {
+					i++
+					if s[i] != '\n' {
+						word.WriteByte(s[i])
+					}
+					continue
+				}
+				word.WriteByte(s[i])
+			}
+			if !closed {
+				return nil, fmt.Errorf("%w: unterminated double quote at position %d", ErrUnbalancedQuotes, start)
+			}
+			inWord = true
+		default:
+			word.WriteByte(c)
+			inWord = true
+		}
+	}
+	if inWord {
+		args = append(args, word.String())
+	}
+	return args, nil
+}
@@ -0,0 +1,125 @@
+/*
+Copyright 2025 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package commandlineexecutor
+
+import (
+	"bytes"
+	"io"
+)
+
+// cappedBuffer keeps at most max bytes of the output written to it.
+// A max of 0 or less keeps all output.
+type cappedBuffer struct {
+	buf       bytes.Buffer
+	max       int
+	truncated bool
+}
+
+// Write always reports the full length of p as written so that the command output keeps flowing
+// to any other writers after the cap has been reached.
+func (c *cappedBuffer) Write(p []byte) (int, error) {
+	if c.max <= 0 {
+		return c.buf.Write(p)
+	}
+	if room := c.max - c.buf.Len(); room < len(p) {
+		c.truncated = true
+		if room > 0 {
+			c.buf.Write(p[:room])
+		}
+		return len(p), nil
+	}
+	return c.buf.Write(p)
+}
+
+func (c *cappedBuffer) String() string {
+	return c.buf.String()
+}
+
+// lineWriter calls fn once for every complete line written to it.
+// Any trailing partial line is held until more output arrives or flush is called.
+type lineWriter struct {
+	fn      func(line string)
+	partial []byte
+}
+
+func (l *lineWriter) Write(p []byte) (int, error) {
+	n := len(p)
+	for {
+		i := bytes.IndexByte(p, '\n')
+		if i < 0 {
+			break
+		}
+		line := p[:i]
+		if len(l.partial) > 0 {
+			line = append(l.partial, line...)
+			l.partial = l.partial[:0]
+		}
+		l.fn(string(bytes.TrimSuffix(line, []byte("\r"))))
+		p = p[i+1:]
+	}
+	l.partial = append(l.partial, p...)
+	return n, nil
+}
+
+// flush delivers the final line of output if it was not terminated by a newline.
+func (l *lineWriter) flush() {
+	if len(l.partial) > 0 {
+		l.fn(string(l.partial))
+		l.partial = nil
+	}
+}
+
+// writers returns the writers to attach to the command's stdout and stderr, and a function to be
+// called once the command has exited to deliver any unterminated final lines.
+func (s *StreamOptions) writers(stdout, stderr *cappedBuffer) (io.Writer, io.Writer, func()) {
+	if s == nil {
+		return stdout, stderr, func() {}
+	}
+	outWriters := []io.Writer{stdout}
+	errWriters := []io.Writer{stderr}
+	var lineWriters []*lineWriter
+	if s.StdOut != nil {
+		outWriters = append(outWriters, s.StdOut)
+	}
+	if s.StdErr != nil {
+		errWriters = append(errWriters, s.StdErr)
+	}
+	if s.OnStdOutLine != nil {
+		lw := &lineWriter{fn: s.OnStdOutLine}
+		lineWriters = append(lineWriters, lw)
+		outWriters = append(outWriters, lw)
+	}
+	if s.OnStdErrLine != nil {
+		lw := &lineWriter{fn: s.OnStdErrLine}
+		lineWriters = append(lineWriters, lw)
+		errWriters = append(errWriters, lw)
+	}
+	flush := func() {
+		for _, lw := range lineWriters {
+			lw.flush()
+		}
+	}
+	return io.MultiWriter(outWriters...), io.MultiWriter(errWriters...), flush
+}
+
+// maxCaptured returns the cap for output kept in the Result, 0 if there is none.
+func (s *StreamOptions) maxCaptured() int {
+	if s == nil {
+		return 0
+	}
+	return s.MaxCapturedBytes
+}
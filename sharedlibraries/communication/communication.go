@@ -97,22 +97,64 @@ func listenForMessages(ctx context.Context, conn *client.Connection, endpoint st
 	return msg
 }
 
-func establishConnection(ctx context.Context, endpoint string, channel string) *client.Connection {
-	log.CtxLogger(ctx).Infow("Establishing connection with ACS", "endpoint", endpoint, "channel", channel)
+// ConnectOptions configures the connections to ACS.
+type ConnectOptions struct {
+	// Endpoint is optional and overrides the default ACS endpoint.
+	Endpoint string
+	// Channel is the registered channel name to be used for communication between the agent and the
+	// service provider.
+	Channel string
+	// Regional connects to the ACS endpoint of the region of the instance rather than of its zone.
+	Regional bool
+	// Backoff is optional and returns the backoff between attempts to connect. It defaults to an
+	// exponential backoff from 2 seconds up to 1 hour.
+	Backoff func() backoff.BackOff
+	// ClientOptions are optional, such as the insecure local credentials of a fake ACS server in tests.
+	ClientOptions []option.ClientOption
+}
+
+func (o ConnectOptions) backoff() backoff.BackOff {
+	if o.Backoff != nil {
+		return o.Backoff()
+	}
+	return setupBackoff()
+}
+
+func establishConnection(ctx context.Context, o ConnectOptions) *client.Connection {
+	return establishConnectionWith(ctx, o, createConnection)
+}
+
+// establishConnectionWith establishes a connection to ACS with create.
+func establishConnectionWith(ctx context.Context, o ConnectOptions, create func(ctx context.Context, channel string, regional bool, opts ...option.ClientOption) (*client.Connection, error)) *client.Connection {
+	log.CtxLogger(ctx).Infow("Establishing connection with ACS", "endpoint", o.Endpoint, "channel", o.Channel, "regional", o.Regional)
 	var opts []option.ClientOption
-	if endpoint != "" {
-		log.CtxLogger(ctx).Infow("Using non-default endpoint", "endpoint", endpoint, "channel", channel)
-		opts = append(opts, option.WithEndpoint(endpoint))
+	if o.Endpoint != "" {
+		log.CtxLogger(ctx).Infow("Using non-default endpoint", "endpoint", o.Endpoint, "channel", o.Channel)
+		opts = append(opts, option.WithEndpoint(o.Endpoint))
 	}
-	conn, err := createConnection(ctx, channel, true, opts...)
+	opts = append(opts, o.ClientOptions...)
+	conn, err := create(ctx, o.Channel, o.Regional, opts...)
 	if err != nil {
-		log.CtxLogger(ctx).Warnw("Failed to establish connection to ACS", "err", err, "endpoint", endpoint, "channel", channel)
+		log.CtxLogger(ctx).Warnw("Failed to establish connection to ACS", "err", err, "endpoint", o.Endpoint, "channel", o.Channel)
 		return nil
 	}
 	log.CtxLogger(ctx).Info("Connected to ACS")
 	return conn
 }
 
+// connectWithBackoff establishes a connection to ACS, retrying with b until it succeeds. It returns
+// nil if ctx is done first.
+func connectWithBackoff(ctx context.Context, o ConnectOptions, b backoff.BackOff) *client.Connection {
+	for {
+		if conn := establishConnection(ctx, o); conn != nil {
+			return conn
+		}
+		if !logAndBackoff(ctx, b, "Establishing connection failed. Will backoff and retry") {
+			return nil
+		}
+	}
+}
+
 func setupBackoff() backoff.BackOff {
 	b := &backoff.ExponentialBackOff{
 		InitialInterval:     2 * time.Second,
@@ -132,6 +174,11 @@ func setupBackoff() backoff.BackOff {
 // 1. Send multiple status updates (e.g., "running" then "done") for LROs.
 // 2. Perform work asynchronously in the background without blocking the listener loop.
 func Listen(ctx context.Context, conn *client.Connection, connectionHandler ConnectionHandler, cloudProperties *metadataserver.CloudProperties) error {
+	return listenWith(ctx, conn, receive, connectionHandler, cloudProperties)
+}
+
+// listenWith is Listen, receiving the messages of conn with receive.
+func listenWith(ctx context.Context, conn *client.Connection, receive func(*client.Connection) (*acpb.MessageBody, error), connectionHandler ConnectionHandler, cloudProperties *metadataserver.CloudProperties) error {
 	for {
 		select {
 		case <-ctx.Done():
@@ -149,15 +196,21 @@ func Listen(ctx context.Context, conn *client.Connection, connectionHandler Conn
 		// Delegate message handling to the provided connectionHandler.
 		err = connectionHandler(ctx, msg, conn, cloudProperties)
 		if err != nil {
-			return fmt.Errorf("%w: %v", ErrHandler, err)
+			return fmt.Errorf("%w: %w", ErrHandler, err)
 		}
 	}
 }
 
-func logAndBackoff(ctx context.Context, eBackoff backoff.BackOff, msg string) {
+// logAndBackoff waits for the next backoff duration. It returns false if ctx is done first.
+func logAndBackoff(ctx context.Context, eBackoff backoff.BackOff, msg string) bool {
 	duration := eBackoff.NextBackOff()
 	log.CtxLogger(ctx).Infow(msg, "duration", duration)
-	time.Sleep(duration)
+	select {
+	case <-ctx.Done():
+		return false
+	case <-time.After(duration):
+		return true
+	}
 }
 
 // Communicate creates ACS connection and enters a loop to receive messages.
@@ -167,12 +220,16 @@ func logAndBackoff(ctx context.Context, eBackoff backoff.BackOff, msg string) {
 // This function is left for backward compatibility with existing code.
 // New code should use Listen() directly.
 func Communicate(ctx context.Context, endpoint string, channel string, messageHandler MsgHandlerFunc, cloudProperties *metadataserver.CloudProperties) error {
-	eBackoff := setupBackoff()
-	conn := establishConnection(ctx, endpoint, channel)
-	for conn == nil {
-		logMsg := fmt.Sprintf("Establishing connection failed. Will backoff and retry")
-		logAndBackoff(ctx, eBackoff, logMsg)
-		conn = establishConnection(ctx, endpoint, channel)
+	return CommunicateWithOptions(ctx, ConnectOptions{Endpoint: endpoint, Channel: channel, Regional: true}, messageHandler, cloudProperties)
+}
+
+// CommunicateWithOptions is Communicate with the connection configured by opts.
+func CommunicateWithOptions(ctx context.Context, opts ConnectOptions, messageHandler MsgHandlerFunc, cloudProperties *metadataserver.CloudProperties) error {
+	eBackoff := opts.backoff()
+	conn := connectWithBackoff(ctx, opts, eBackoff)
+	if conn == nil {
+		log.CtxLogger(ctx).Info("Context cancelled before connecting to ACS")
+		return ctx.Err()
 	}
 	// Reset backoff once we successfully connected.
 	eBackoff.Reset()
@@ -187,21 +244,22 @@ func Communicate(ctx context.Context, endpoint string, channel string, messageHa
 		default:
 		}
 		// listen for messages
-		msg := listenForMessages(ctx, conn, endpoint, channel)
+		msg := listenForMessages(ctx, conn, opts.Endpoint, opts.Channel)
 		log.CtxLogger(ctx).Infow("ListenForMessages complete.", "msg", prototext.Format(msg))
 		// parse message
 		if msg.GetLabels() == nil {
-			logMsg := fmt.Sprintf("Nil labels in message from listenForMessages. Will backoff and retry with a new connection")
-			logAndBackoff(ctx, eBackoff, logMsg)
-			conn = establishConnection(ctx, endpoint, channel)
 			lastErr = fmt.Errorf("nil labels in message from listenForMessages")
+			if logAndBackoff(ctx, eBackoff, "Nil labels in message from listenForMessages. Will backoff and retry with a new connection") {
+				if newConn := connectWithBackoff(ctx, opts, eBackoff); newConn != nil {
+					conn = newConn
+				}
+			}
 			continue
 		}
 		operationID, ok := msg.GetLabels()["operation_id"]
 		if !ok {
-			logMsg := fmt.Sprintf("No operation_id label in message. Will backoff and retry")
-			logAndBackoff(ctx, eBackoff, logMsg)
 			lastErr = fmt.Errorf("no operation_id label in message")
+			logAndBackoff(ctx, eBackoff, "No operation_id label in message. Will backoff and retry")
 			continue
 		}
 		log.CtxLogger(ctx).Debugw("Parsed operation_id from label", "operation_id", operationID)
@@ -228,8 +286,9 @@ func Communicate(ctx context.Context, endpoint string, channel string, messageHa
 // "endpoint" is the endpoint and will often be an empty string.
 // "channel" is the registered channel name to be used for communication
 // between the agent and the service provider.
-func EstablishACSConnection(ctx context.Context, endpoint string, channel string) *client.Connection {
-	return establishConnection(ctx, endpoint, channel)
+// "opts" are optional, such as the insecure local credentials of a fake ACS server in tests.
+func EstablishACSConnection(ctx context.Context, endpoint string, channel string, opts ...option.ClientOption) *client.Connection {
+	return establishConnection(ctx, ConnectOptions{Endpoint: endpoint, Channel: channel, Regional: true, ClientOptions: opts})
 }
 
 // SendAgentMessage sends a message from the agent to the service provider via ACS.
@@ -237,6 +296,7 @@ func EstablishACSConnection(ctx context.Context, endpoint string, channel string
 // "messageType" is the label value of the message.
 // "body" is the body of the message.
 // "conn" is the connection to ACS.
+// The message is sent once. Use an Outbox to retry it and learn whether it was delivered.
 func SendAgentMessage(ctx context.Context, messageKey string, messageType string, body *anypb.Any, conn *client.Connection) error {
 	labels := map[string]string{
 		messageKey: messageType,
@@ -27,11 +27,13 @@ import (
 	apb "google.golang.org/protobuf/types/known/anypb"
 	"github.com/GoogleCloudPlatform/agentcommunication_client"
 	acpb "github.com/GoogleCloudPlatform/agentcommunication_client/gapic/agentcommunicationpb"
+	"github.com/cenkalti/backoff/v4"
 	"github.com/google/go-cmp/cmp"
 	"github.com/google/go-cmp/cmp/cmpopts"
 	"google.golang.org/api/option"
 	"google.golang.org/protobuf/proto"
 	"google.golang.org/protobuf/testing/protocmp"
+	"github.com/GoogleCloudPlatform/workloadagentplatform/sharedlibraries/communication/fakeacs"
 	"github.com/GoogleCloudPlatform/workloadagentplatform/sharedlibraries/gce/metadataserver"
 	gpb "github.com/GoogleCloudPlatform/workloadagentplatform/sharedprotos/guestactions"
 )
@@ -138,6 +140,8 @@ func TestListenForMessages(t *testing.T) {
 	conn := &client.Connection{}
 	for _, test := range tests {
 		t.Run(test.name, func(t *testing.T) {
+			origReceive := receive
+			defer func() { receive = origReceive }()
 			receive = test.receive
 			got := listenForMessages(ctx, conn, "testendpoint", "testchannel")
 			if diff := cmp.Diff(test.want, got, protocmp.Transform()); diff != "" {
@@ -209,8 +213,10 @@ func TestEstablishConnection(t *testing.T) {
 	ctx := context.Background()
 	for _, test := range tests {
 		t.Run(test.name, func(t *testing.T) {
+			origCreateConnection := createConnection
+			defer func() { createConnection = origCreateConnection }()
 			createConnection = test.createConnection
-			got := establishConnection(ctx, "endpoint", "channel")
+			got := establishConnection(ctx, ConnectOptions{Endpoint: "endpoint", Channel: "channel"})
 			if diff := cmp.Diff(test.want, got, protocmp.Transform(), cmpopts.IgnoreUnexported(client.Connection{})); diff != "" {
 				t.Errorf("establishConnection() returned diff (-want +got):\n%s", diff)
 			}
@@ -218,6 +224,51 @@ func TestEstablishConnection(t *testing.T) {
 	}
 }
 
+func TestEstablishConnectionRegional(t *testing.T) {
+	tests := []struct {
+		name    string
+		connect func(ctx context.Context) *client.Connection
+		want    bool
+	}{
+		{
+			name: "Zonal",
+			connect: func(ctx context.Context) *client.Connection {
+				return establishConnection(ctx, ConnectOptions{Channel: "channel"})
+			},
+			want: false,
+		},
+		{
+			name: "Regional",
+			connect: func(ctx context.Context) *client.Connection {
+				return establishConnection(ctx, ConnectOptions{Channel: "channel", Regional: true})
+			},
+			want: true,
+		},
+		{
+			name: "EstablishACSConnection",
+			connect: func(ctx context.Context) *client.Connection {
+				return EstablishACSConnection(ctx, "", "channel")
+			},
+			want: true,
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			origCreateConnection := createConnection
+			defer func() { createConnection = origCreateConnection }()
+			var got bool
+			createConnection = func(ctx context.Context, channel string, regional bool, opts ...option.ClientOption) (*client.Connection, error) {
+				got = regional
+				return &client.Connection{}, nil
+			}
+			tc.connect(context.Background())
+			if got != tc.want {
+				t.Errorf("createConnection() called with regional %v, want: %v", got, tc.want)
+			}
+		})
+	}
+}
+
 func TestEstablishACSConnection(t *testing.T) {
 	tests := []struct {
 		name             string
@@ -243,6 +294,8 @@ func TestEstablishACSConnection(t *testing.T) {
 	ctx := context.Background()
 	for _, test := range tests {
 		t.Run(test.name, func(t *testing.T) {
+			origCreateConnection := createConnection
+			defer func() { createConnection = origCreateConnection }()
 			createConnection = test.createConnection
 			got := EstablishACSConnection(ctx, "endpoint", "channel")
 			if diff := cmp.Diff(test.want, got, protocmp.Transform(), cmpopts.IgnoreUnexported(client.Connection{})); diff != "" {
@@ -344,10 +397,14 @@ func TestCommunicate(t *testing.T) {
 
 	for _, test := range tests {
 		t.Run(test.name, func(t *testing.T) {
+			origCreateConnection := createConnection
+			defer func() { createConnection = origCreateConnection }()
 			createConnection = test.createConnection
 			origSendMessage := SendMessage
 			defer func() { SendMessage = origSendMessage }()
 			SendMessage = test.SendMessage
+			origReceive := receive
+			defer func() { receive = origReceive }()
 			receive = test.receive
 			ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 			defer cancel()
@@ -416,6 +473,8 @@ func TestListen(t *testing.T) {
 
 	for _, tc := range tests {
 		t.Run(tc.name, func(t *testing.T) {
+			origReceive := receive
+			defer func() { receive = origReceive }()
 			receive = func(c *client.Connection) (*acpb.MessageBody, error) {
 				return tc.receive()
 			}
@@ -432,6 +491,8 @@ func TestListen(t *testing.T) {
 	t.Run("handler called with success", func(t *testing.T) {
 		handlerCalled := make(chan bool, 1)
 		var receiveCount int
+		origReceive := receive
+		defer func() { receive = origReceive }()
 		receive = func(c *client.Connection) (*acpb.MessageBody, error) {
 			receiveCount++
 			if receiveCount == 1 {
@@ -494,3 +555,70 @@ func TestSendAgentMessage(t *testing.T) {
 		})
 	}
 }
+
+func TestEstablishACSConnectionFakeACS(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	server := fakeacs.New(t)
+	conn := EstablishACSConnection(ctx, server.Endpoint(), "test-channel", server.ClientOptions()...)
+	if conn == nil {
+		t.Fatalf("EstablishACSConnection() = nil, want a connection to the fake ACS server")
+	}
+	defer conn.Close()
+
+	want := &acpb.MessageBody{Labels: map[string]string{"operation_id": "op1"}}
+	server.Send(want)
+	got, err := conn.Receive()
+	if err != nil {
+		t.Fatalf("Receive() returned unexpected error: %v", err)
+	}
+	if diff := cmp.Diff(want, got, protocmp.Transform()); diff != "" {
+		t.Errorf("Receive() returned diff (-want +got):\n%s", diff)
+	}
+
+	if err := SendStatusMessage(ctx, "op1", nil, succeeded, "done", conn); err != nil {
+		t.Fatalf("SendStatusMessage() returned unexpected error: %v", err)
+	}
+	got, err = server.Next(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantStatus := &acpb.MessageBody{Labels: map[string]string{"operation_id": "op1", "state": succeeded, "lro_state": "done"}}
+	if diff := cmp.Diff(wantStatus, got, protocmp.Transform()); diff != "" {
+		t.Errorf("Status message returned diff (-want +got):\n%s", diff)
+	}
+}
+
+func TestCommunicateWithOptionsFakeACS(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	server := fakeacs.New(t)
+	opts := ConnectOptions{
+		Endpoint:      server.Endpoint(),
+		Channel:       "test-channel",
+		Regional:      true,
+		Backoff:       func() backoff.BackOff { return &backoff.ZeroBackOff{} },
+		ClientOptions: server.ClientOptions(),
+	}
+	response := wrapAny(t, &gpb.GuestActionResponse{})
+	done := make(chan error)
+	go func() {
+		done <- CommunicateWithOptions(ctx, opts, func(context.Context, *apb.Any, *metadataserver.CloudProperties) (*apb.Any, error) {
+			return response, nil
+		}, nil)
+	}()
+
+	server.Send(&acpb.MessageBody{Labels: map[string]string{"operation_id": "op1"}})
+	got, err := server.Next(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := &acpb.MessageBody{Labels: map[string]string{"operation_id": "op1", "state": succeeded, "lro_state": "done"}, Body: response}
+	if diff := cmp.Diff(want, got, protocmp.Transform()); diff != "" {
+		t.Errorf("CommunicateWithOptions() sent status diff (-want +got):\n%s", diff)
+	}
+	cancel()
+	// The listener loop only checks ctx between messages, and the status of op2 cannot be sent.
+	server.Send(&acpb.MessageBody{Labels: map[string]string{"operation_id": "op2"}})
+	<-done
+}
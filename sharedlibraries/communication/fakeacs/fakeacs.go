@@ -0,0 +1,254 @@
+/*
+Copyright 2025 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+/*
+Package fakeacs provides an in-process fake of the Agent Communication Service for tests.
+
+The fake serves the ACS streaming API over gRPC on a local port. Tests push messages to the agent
+with Send and assert on the messages the agent sends back with Next. Connect to it by passing
+Endpoint and ClientOptions to communication.EstablishACSConnection.
+
+The ACS client reads the zone, the instance and an identity token from the GCE metadata server.
+The first call to New starts a fake metadata server for the whole test binary and points the
+GCE_METADATA_HOST environment variable at it, so servers can be used by parallel tests.
+*/
+package fakeacs
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"google.golang.org/api/option"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+
+	acpb "github.com/GoogleCloudPlatform/agentcommunication_client/gapic/agentcommunicationpb"
+	spb "google.golang.org/genproto/googleapis/rpc/status"
+)
+
+const (
+	// ProjectNumber, Zone and InstanceID are served by the fake metadata server.
+	ProjectNumber = "123456789"
+	Zone          = "us-central1-a"
+	InstanceID    = "987654321"
+
+	metadataHostEnv = "GCE_METADATA_HOST"
+)
+
+/*
+Server is a fake ACS server. Messages pushed with Send are delivered on the open streams of the
+channels registered by the agent, and the messages the agent sends, on its streams or with
+SendAgentMessage, are queued for Next.
+*/
+type Server struct {
+	acpb.UnimplementedAgentCommunicationServer
+
+	listener net.Listener
+	server   *grpc.Server
+	toAgent  chan *acpb.MessageBody
+	received chan *acpb.MessageBody
+
+	mu        sync.Mutex
+	streams   int
+	closeAll  chan struct{}
+	closeErr  error
+	messageID int
+}
+
+/*
+New starts a fake ACS server, and a fake metadata server which the ACS client authenticates with,
+which are stopped when the test ends. The metadata server is set in the environment of the test, so
+tests using New cannot run in parallel.
+*/
+func New(t testing.TB) *Server {
+	t.Helper()
+	metadata := httptest.NewServer(http.HandlerFunc(serveMetadata))
+	t.Cleanup(metadata.Close)
+	t.Setenv(metadataHostEnv, strings.TrimPrefix(metadata.URL, "http://"))
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen for the fake ACS server: %v", err)
+	}
+	s := &Server{
+		listener: listener,
+		server:   grpc.NewServer(),
+		toAgent:  make(chan *acpb.MessageBody, 100),
+		received: make(chan *acpb.MessageBody, 100),
+		closeAll: make(chan struct{}),
+	}
+	acpb.RegisterAgentCommunicationServer(s.server, s)
+	go s.server.Serve(listener)
+	t.Cleanup(s.server.Stop)
+	return s
+}
+
+// Endpoint returns the address the server listens on.
+func (s *Server) Endpoint() string {
+	return s.listener.Addr().String()
+}
+
+// ClientOptions returns the options an ACS client connecting to Endpoint needs, as the server does
+// not use TLS.
+func (s *Server) ClientOptions() []option.ClientOption {
+	return []option.ClientOption{option.WithGRPCDialOption(grpc.WithTransportCredentials(insecure.NewCredentials()))}
+}
+
+// Send queues msg to be delivered to the agent on an open stream.
+func (s *Server) Send(msg *acpb.MessageBody) {
+	s.toAgent <- msg
+}
+
+// Next returns the next message sent by the agent, or an error if there is none before ctx is done.
+func (s *Server) Next(ctx context.Context) (*acpb.MessageBody, error) {
+	select {
+	case msg := <-s.received:
+		return msg, nil
+	case <-ctx.Done():
+		return nil, fmt.Errorf("waiting for a message from the agent: %w", ctx.Err())
+	}
+}
+
+// Streams returns the number of streams which were opened by the agent.
+func (s *Server) Streams() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.streams
+}
+
+/*
+CloseStreams ends the open streams with code, as ACS does when it disconnects an agent. The ACS
+client opens a new stream after Unavailable, Canceled and DeadlineExceeded, and closes its
+connection after other codes.
+*/
+func (s *Server) CloseStreams(code codes.Code) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.closeErr = status.Error(code, "stream closed by the fake ACS server")
+	close(s.closeAll)
+	s.closeAll = make(chan struct{})
+}
+
+// StreamAgentMessages implements the ACS streaming API.
+func (s *Server) StreamAgentMessages(stream acpb.AgentCommunication_StreamAgentMessagesServer) error {
+	req, err := stream.Recv()
+	if err != nil {
+		return err
+	}
+	if req.GetRegisterConnection() == nil {
+		return status.Error(codes.FailedPrecondition, "the first message of a stream must register the connection")
+	}
+	s.mu.Lock()
+	s.streams++
+	closeAll := s.closeAll
+	s.mu.Unlock()
+
+	// Sends on a stream are not safe for concurrent use, so the acknowledgements of the messages of
+	// the agent are sent by this goroutine too.
+	acks := make(chan string, 100)
+	recvErr := make(chan error, 1)
+	go func() {
+		for {
+			req, err := stream.Recv()
+			if err != nil {
+				recvErr <- err
+				return
+			}
+			if msg := req.GetMessageBody(); msg != nil {
+				s.received <- msg
+				acks <- req.GetMessageId()
+			}
+		}
+	}()
+	if err := stream.Send(messageResponse(req.GetMessageId())); err != nil {
+		return err
+	}
+	for {
+		select {
+		case msg := <-s.toAgent:
+			resp := &acpb.StreamAgentMessagesResponse{
+				MessageId: s.nextMessageID(),
+				Type:      &acpb.StreamAgentMessagesResponse_MessageBody{MessageBody: msg},
+			}
+			if err := stream.Send(resp); err != nil {
+				// The message is delivered on the next stream.
+				s.toAgent <- msg
+				return err
+			}
+		case id := <-acks:
+			if err := stream.Send(messageResponse(id)); err != nil {
+				return err
+			}
+		case err := <-recvErr:
+			return err
+		case <-closeAll:
+			s.mu.Lock()
+			defer s.mu.Unlock()
+			return s.closeErr
+		case <-stream.Context().Done():
+			return stream.Context().Err()
+		}
+	}
+}
+
+// SendAgentMessage implements the ACS API which sends a single message.
+func (s *Server) SendAgentMessage(ctx context.Context, req *acpb.SendAgentMessageRequest) (*acpb.SendAgentMessageResponse, error) {
+	s.received <- req.GetMessageBody()
+	return &acpb.SendAgentMessageResponse{}, nil
+}
+
+func (s *Server) nextMessageID() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.messageID++
+	return fmt.Sprintf("fake-acs-%d", s.messageID)
+}
+
+// messageResponse acknowledges the message with id.
+func messageResponse(id string) *acpb.StreamAgentMessagesResponse {
+	return &acpb.StreamAgentMessagesResponse{
+		MessageId: id,
+		Type:      &acpb.StreamAgentMessagesResponse_MessageResponse{MessageResponse: &acpb.MessageResponse{Status: &spb.Status{Code: int32(codes.OK)}}},
+	}
+}
+
+// serveMetadata serves the metadata the ACS client reads.
+func serveMetadata(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Metadata-Flavor", "Google")
+	switch path := strings.TrimPrefix(r.URL.Path, "/computeMetadata/v1/"); path {
+	case "instance/zone":
+		fmt.Fprintf(w, "projects/%s/zones/%s", ProjectNumber, Zone)
+	case "project/numeric-project-id":
+		fmt.Fprint(w, ProjectNumber)
+	case "instance/id":
+		fmt.Fprint(w, InstanceID)
+	case "instance/service-accounts/default/identity":
+		// The client only reads the expiry of the token.
+		claims := fmt.Sprintf(`{"exp":%d}`, time.Now().Add(24*time.Hour).Unix())
+		fmt.Fprintf(w, "header.%s.signature", base64.RawURLEncoding.EncodeToString([]byte(claims)))
+	default:
+		http.NotFound(w, r)
+	}
+}
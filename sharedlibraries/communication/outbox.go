@@ -0,0 +1,425 @@
+/*
+Copyright 2025 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package communication
+
+import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/GoogleCloudPlatform/agentcommunication_client"
+	"github.com/cenkalti/backoff/v4"
+	"google.golang.org/protobuf/proto"
+	"github.com/GoogleCloudPlatform/workloadagentplatform/sharedlibraries/log"
+
+	anypb "google.golang.org/protobuf/types/known/anypb"
+	acpb "github.com/GoogleCloudPlatform/agentcommunication_client/gapic/agentcommunicationpb"
+)
+
+const (
+	// CorrelationIDLabel is the label holding the correlation ID of the messages sent by an Outbox.
+	CorrelationIDLabel = "correlation_id"
+
+	defaultOutboxCapacity    = 100
+	defaultOutboxMaxAttempts = 5
+	// outboxCompactThreshold is the number of done records after which the persist file is
+	// compacted.
+	outboxCompactThreshold = 100
+
+	// outboxQueued records a message waiting to be sent, and outboxDone a message which was
+	// delivered or which failed to be.
+	outboxQueued = "queued"
+	outboxDone   = "done"
+)
+
+var (
+	// ErrOutboxFull indicates that a message was not queued because the outbox holds
+	// OutboxOptions.Capacity messages.
+	ErrOutboxFull = errors.New("agent message outbox is full")
+	// ErrOutboxStopped is the outcome of the messages which were not sent when Outbox.Run returned,
+	// and the error of Outbox.Enqueue once it has returned.
+	ErrOutboxStopped = errors.New("agent message outbox stopped before the message was sent")
+)
+
+type (
+	// OutboxOptions configures an Outbox.
+	OutboxOptions struct {
+		// Capacity is the maximum number of messages waiting to be sent. It defaults to 100.
+		Capacity int
+		// MaxAttempts is the number of times sending a message is attempted on a connection before it
+		// fails. Attempts are not counted while there is no connection. It defaults to 5.
+		MaxAttempts int
+		// Backoff is optional and returns the backoff between attempts. It defaults to an exponential
+		// backoff from 2 seconds up to 1 hour.
+		Backoff func() backoff.BackOff
+		// PersistFile is optional and is the path of a file which keeps the messages waiting to be
+		// sent, so that the next Outbox opened on it sends them after an agent restart.
+		PersistFile string
+		// OnDelivery is optional and is called with the outcome of every message.
+		OnDelivery func(DeliveryResult)
+	}
+
+	// DeliveryResult is the final outcome of a message sent by an Outbox.
+	DeliveryResult struct {
+		CorrelationID string
+		// Attempts is the number of times sending the message was attempted.
+		Attempts int
+		// Err is nil if ACS acknowledged the message, or the reason it was not delivered.
+		Err error
+	}
+
+	// outboxRecord is a state transition of a message, stored as a line of JSON in the persist file.
+	outboxRecord struct {
+		Time          time.Time `json:"time"`
+		CorrelationID string    `json:"correlation_id"`
+		State         string    `json:"state"`
+		// Message is the serialized MessageBody of a queued message.
+		Message []byte `json:"message,omitempty"`
+	}
+
+	outboxMessage struct {
+		body     *acpb.MessageBody
+		attempts int
+		result   chan DeliveryResult
+		// record is the queued record of the message in the persist file.
+		record outboxRecord
+	}
+
+	/*
+		Outbox sends agent-initiated messages to ACS in the order they are queued, unlike
+		SendAgentMessage which sends a message once. Every message gets a correlation ID in its
+		CorrelationIDLabel label and waits in the outbox while there is no connection. Sending a
+		message is retried with a backoff, and its outcome is reported on the channel returned by
+		Enqueue and to OutboxOptions.OnDelivery.
+
+		The persist file is compacted to the messages waiting to be sent when the outbox is empty,
+		and every outboxCompactThreshold messages which are done.
+	*/
+	Outbox struct {
+		options    OutboxOptions
+		connection func() *client.Connection
+		wake       chan struct{}
+
+		mu      sync.Mutex
+		pending []*outboxMessage
+		file    *os.File
+		// done is the number of done records written since the persist file was last compacted.
+		done int
+		// stopped is true once Run has returned.
+		stopped bool
+	}
+)
+
+/*
+NewOutbox returns an Outbox which sends messages on the connection returned by connection, such
+as Supervisor.Connection, or waits while it returns nil. If OutboxOptions.PersistFile is set, the
+messages it holds are queued first. Run sends the messages.
+*/
+func NewOutbox(ctx context.Context, opts OutboxOptions, connection func() *client.Connection) (*Outbox, error) {
+	if opts.Capacity <= 0 {
+		opts.Capacity = defaultOutboxCapacity
+	}
+	if opts.MaxAttempts <= 0 {
+		opts.MaxAttempts = defaultOutboxMaxAttempts
+	}
+	o := &Outbox{options: opts, connection: connection, wake: make(chan struct{}, 1)}
+	if opts.PersistFile == "" {
+		return o, nil
+	}
+	records, err := readOutbox(ctx, opts.PersistFile)
+	if err != nil {
+		return nil, err
+	}
+	if o.file, err = openOutbox(opts.PersistFile, records); err != nil {
+		return nil, err
+	}
+	for _, r := range records {
+		body := &acpb.MessageBody{}
+		if err := proto.Unmarshal(r.Message, body); err != nil {
+			log.CtxLogger(ctx).Warnw("Skipping invalid persisted agent message", "correlation_id", r.CorrelationID, "error", err)
+			continue
+		}
+		o.pending = append(o.pending, &outboxMessage{body: body, result: make(chan DeliveryResult, 1), record: r})
+	}
+	if len(o.pending) > 0 {
+		log.CtxLogger(ctx).Infow("Queued persisted agent messages", "count", len(o.pending), "file", opts.PersistFile)
+	}
+	return o, nil
+}
+
+/*
+Enqueue queues a message with the label messageKey: messageType and body, like SendAgentMessage.
+It returns the correlation ID of the message and a channel which receives its outcome, or
+ErrOutboxFull if the outbox is full and ErrOutboxStopped once Run has returned.
+*/
+func (o *Outbox) Enqueue(ctx context.Context, messageKey string, messageType string, body *anypb.Any) (string, <-chan DeliveryResult, error) {
+	id := rand.Text()
+	m := &outboxMessage{
+		body:   &acpb.MessageBody{Labels: map[string]string{messageKey: messageType, CorrelationIDLabel: id}, Body: body},
+		result: make(chan DeliveryResult, 1),
+	}
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if o.stopped {
+		return "", nil, ErrOutboxStopped
+	}
+	if len(o.pending) >= o.options.Capacity {
+		return "", nil, ErrOutboxFull
+	}
+	if o.file != nil {
+		data, err := proto.Marshal(m.body)
+		if err != nil {
+			return "", nil, err
+		}
+		m.record = outboxRecord{Time: time.Now(), CorrelationID: id, State: outboxQueued, Message: data}
+		if err := o.write(m.record); err != nil {
+			return "", nil, err
+		}
+	}
+	o.pending = append(o.pending, m)
+	select {
+	case o.wake <- struct{}{}:
+	default:
+	}
+	log.CtxLogger(ctx).Debugw("Queued agent message", "correlation_id", id, "message_key", messageKey, "message_type", messageType)
+	return id, m.result, nil
+}
+
+// Len returns the number of messages waiting to be sent.
+func (o *Outbox) Len() int {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	return len(o.pending)
+}
+
+/*
+Run sends the queued messages until ctx is done. The messages which are not sent when it returns
+fail with ErrOutboxStopped, and are kept in OutboxOptions.PersistFile if it is set.
+*/
+func (o *Outbox) Run(ctx context.Context) {
+	defer o.stop(ctx)
+	b := o.options.backoff()
+	for {
+		m := o.front()
+		if m == nil {
+			select {
+			case <-ctx.Done():
+				return
+			case <-o.wake:
+			}
+			continue
+		}
+		conn := o.connection()
+		if conn == nil {
+			if !logAndBackoff(ctx, b, "No ACS connection to send agent messages. Will backoff and retry") {
+				return
+			}
+			continue
+		}
+		m.attempts++
+		err := SendMessage(conn, m.body)
+		if err == nil || m.attempts >= o.options.MaxAttempts {
+			b.Reset()
+			o.finish(ctx, m, err)
+			continue
+		}
+		log.CtxLogger(ctx).Warnw("Sending agent message failed", "correlation_id", m.correlationID(), "attempt", m.attempts, "err", err)
+		if !logAndBackoff(ctx, b, "Will backoff and retry sending the agent message") {
+			return
+		}
+	}
+}
+
+func (o *Outbox) front() *outboxMessage {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if len(o.pending) == 0 {
+		return nil
+	}
+	return o.pending[0]
+}
+
+/*
+finish removes m from the outbox and reports its outcome. The persist file is compacted once the
+outbox is empty or enough messages are done.
+*/
+func (o *Outbox) finish(ctx context.Context, m *outboxMessage, err error) {
+	o.mu.Lock()
+	o.pending = o.pending[1:]
+	if o.file != nil {
+		if err := o.write(outboxRecord{Time: time.Now(), CorrelationID: m.correlationID(), State: outboxDone}); err != nil {
+			log.CtxLogger(ctx).Warnw("Could not write to the agent message persist file", "correlation_id", m.correlationID(), "error", err)
+		}
+		if o.done++; len(o.pending) == 0 || o.done >= outboxCompactThreshold {
+			if err := o.compact(); err != nil {
+				log.CtxLogger(ctx).Warnw("Could not compact the agent message persist file", "file", o.options.PersistFile, "error", err)
+			}
+		}
+	}
+	o.mu.Unlock()
+	if err != nil {
+		log.CtxLogger(ctx).Warnw("Agent message was not delivered", "correlation_id", m.correlationID(), "attempts", m.attempts, "err", err)
+	} else {
+		log.CtxLogger(ctx).Debugw("Agent message delivered", "correlation_id", m.correlationID(), "attempts", m.attempts)
+	}
+	o.report(m, err)
+}
+
+// stop reports the messages which were not sent and closes the persist file.
+func (o *Outbox) stop(ctx context.Context) {
+	o.mu.Lock()
+	pending := o.pending
+	o.pending, o.stopped = nil, true
+	if o.file != nil {
+		o.file.Close()
+		o.file = nil
+	}
+	o.mu.Unlock()
+	if len(pending) > 0 {
+		log.CtxLogger(ctx).Infow("Agent message outbox stopped with messages not sent", "count", len(pending))
+	}
+	for _, m := range pending {
+		o.report(m, ErrOutboxStopped)
+	}
+}
+
+func (o *Outbox) report(m *outboxMessage, err error) {
+	result := DeliveryResult{CorrelationID: m.correlationID(), Attempts: m.attempts, Err: err}
+	if o.options.OnDelivery != nil {
+		o.options.OnDelivery(result)
+	}
+	m.result <- result
+	close(m.result)
+}
+
+// write appends r to the persist file and syncs it to disk. o.mu must be held.
+func (o *Outbox) write(r outboxRecord) error {
+	data, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+	if _, err := o.file.Write(append(data, '\n')); err != nil {
+		return err
+	}
+	return o.file.Sync()
+}
+
+// compact replaces the persist file with the queued records of the pending messages. o.mu must be
+// held.
+func (o *Outbox) compact() error {
+	records := make([]outboxRecord, 0, len(o.pending))
+	for _, m := range o.pending {
+		records = append(records, m.record)
+	}
+	file, err := openOutbox(o.options.PersistFile, records)
+	if err != nil {
+		return err
+	}
+	o.file.Close()
+	o.file, o.done = file, 0
+	return nil
+}
+
+func (m *outboxMessage) correlationID() string {
+	return m.body.GetLabels()[CorrelationIDLabel]
+}
+
+func (o OutboxOptions) backoff() backoff.BackOff {
+	if o.Backoff != nil {
+		return o.Backoff()
+	}
+	return setupBackoff()
+}
+
+/*
+readOutbox returns the records of the queued messages in the persist file at path which are not
+done, in the order they were queued. A missing file has no messages. Lines which cannot be parsed,
+such as a record partially written as the agent stopped, are skipped.
+*/
+func readOutbox(ctx context.Context, path string) ([]outboxRecord, error) {
+	f, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var records []outboxRecord
+	done := make(map[string]bool)
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 0, 64*1024), 16*1024*1024)
+	for line := 1; scanner.Scan(); line++ {
+		var r outboxRecord
+		if err := json.Unmarshal(scanner.Bytes(), &r); err != nil {
+			log.CtxLogger(ctx).Warnw("Skipping invalid agent message record", "file", path, "line", line, "error", err)
+			continue
+		}
+		switch r.State {
+		case outboxQueued:
+			records = append(records, r)
+		case outboxDone:
+			done[r.CorrelationID] = true
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	var queued []outboxRecord
+	for _, r := range records {
+		if !done[r.CorrelationID] {
+			queued = append(queued, r)
+		}
+	}
+	return queued, nil
+}
+
+// openOutbox replaces the persist file at path with the records and opens it to append new records.
+func openOutbox(path string, records []outboxRecord) (*os.File, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0750); err != nil {
+		return nil, err
+	}
+	tmp := path + ".tmp"
+	f, err := os.OpenFile(tmp, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0600)
+	if err != nil {
+		return nil, err
+	}
+	for _, r := range records {
+		data, err := json.Marshal(r)
+		if err == nil {
+			_, err = f.Write(append(data, '\n'))
+		}
+		if err != nil {
+			f.Close()
+			return nil, err
+		}
+	}
+	if err := f.Close(); err != nil {
+		return nil, err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return nil, err
+	}
+	return os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0600)
+}
@@ -0,0 +1,346 @@
+/*
+Copyright 2025 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package communication
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/GoogleCloudPlatform/agentcommunication_client"
+	"github.com/google/go-cmp/cmp"
+	"google.golang.org/protobuf/testing/protocmp"
+	"github.com/GoogleCloudPlatform/workloadagentplatform/sharedlibraries/communication/fakeacs"
+	"github.com/GoogleCloudPlatform/workloadagentplatform/sharedlibraries/gce/metadataserver"
+
+	acpb "github.com/GoogleCloudPlatform/agentcommunication_client/gapic/agentcommunicationpb"
+)
+
+// outboxSender replaces SendMessage, failing the first failures messages it is given.
+type outboxSender struct {
+	mu       sync.Mutex
+	failures int
+	sent     []*acpb.MessageBody
+}
+
+func (s *outboxSender) install(t *testing.T) {
+	t.Helper()
+	origSendMessage := SendMessage
+	t.Cleanup(func() { SendMessage = origSendMessage })
+	SendMessage = func(c *client.Connection, msg *acpb.MessageBody) error {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		if s.failures > 0 {
+			s.failures--
+			return errors.New("send failed")
+		}
+		s.sent = append(s.sent, msg)
+		return nil
+	}
+}
+
+func (s *outboxSender) messages() []*acpb.MessageBody {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.sent
+}
+
+// runOutbox runs o until the test ends.
+func runOutbox(t *testing.T, o *Outbox) context.CancelFunc {
+	t.Helper()
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		o.Run(ctx)
+		close(done)
+	}()
+	t.Cleanup(func() {
+		cancel()
+		<-done
+	})
+	return cancel
+}
+
+func connected() *client.Connection {
+	return &client.Connection{}
+}
+
+func nextResult(t *testing.T, results <-chan DeliveryResult) DeliveryResult {
+	t.Helper()
+	select {
+	case r := <-results:
+		return r
+	case <-time.After(5 * time.Second):
+		t.Fatal("Timed out waiting for the delivery result")
+		return DeliveryResult{}
+	}
+}
+
+func TestOutboxDelivery(t *testing.T) {
+	tests := []struct {
+		name         string
+		failures     int
+		wantAttempts int
+		wantErr      bool
+	}{
+		{name: "Delivered", wantAttempts: 1},
+		{name: "Retried", failures: 2, wantAttempts: 3},
+		{name: "Failed", failures: 5, wantAttempts: 3, wantErr: true},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			ctx := context.Background()
+			sender := &outboxSender{failures: tc.failures}
+			sender.install(t)
+			var reported []DeliveryResult
+			o, err := NewOutbox(ctx, OutboxOptions{MaxAttempts: 3, Backoff: zeroBackoff, OnDelivery: func(r DeliveryResult) {
+				reported = append(reported, r)
+			}}, connected)
+			if err != nil {
+				t.Fatalf("NewOutbox() returned unexpected error: %v", err)
+			}
+			runOutbox(t, o)
+
+			id, results, err := o.Enqueue(ctx, "message_type", "alert", nil)
+			if err != nil {
+				t.Fatalf("Enqueue() returned unexpected error: %v", err)
+			}
+			got := nextResult(t, results)
+			if got.CorrelationID != id || got.Attempts != tc.wantAttempts || (got.Err != nil) != tc.wantErr {
+				t.Errorf("Enqueue() result = %+v, want correlation ID %q, %d attempts and error: %v", got, id, tc.wantAttempts, tc.wantErr)
+			}
+			if diff := cmp.Diff([]DeliveryResult{got}, reported, cmp.Comparer(func(a, b error) bool { return a == b })); diff != "" {
+				t.Errorf("OnDelivery() results diff (-want +got):\n%s", diff)
+			}
+			if tc.wantErr {
+				return
+			}
+			want := []*acpb.MessageBody{{Labels: map[string]string{"message_type": "alert", CorrelationIDLabel: id}}}
+			if diff := cmp.Diff(want, sender.messages(), protocmp.Transform()); diff != "" {
+				t.Errorf("Outbox sent messages diff (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestOutboxBuffersWhileDisconnected(t *testing.T) {
+	ctx := context.Background()
+	sender := &outboxSender{}
+	sender.install(t)
+	var isConnected atomic.Bool
+	o, err := NewOutbox(ctx, OutboxOptions{Capacity: 2, Backoff: zeroBackoff}, func() *client.Connection {
+		if isConnected.Load() {
+			return connected()
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("NewOutbox() returned unexpected error: %v", err)
+	}
+	runOutbox(t, o)
+
+	first, firstResult, err := o.Enqueue(ctx, "message_type", "first", nil)
+	if err != nil {
+		t.Fatalf("Enqueue(first) returned unexpected error: %v", err)
+	}
+	second, secondResult, err := o.Enqueue(ctx, "message_type", "second", nil)
+	if err != nil {
+		t.Fatalf("Enqueue(second) returned unexpected error: %v", err)
+	}
+	if _, _, err := o.Enqueue(ctx, "message_type", "third", nil); !errors.Is(err, ErrOutboxFull) {
+		t.Errorf("Enqueue(third) returned error %v, want %v", err, ErrOutboxFull)
+	}
+	if got := o.Len(); got != 2 {
+		t.Errorf("Len() = %d while disconnected, want 2", got)
+	}
+
+	isConnected.Store(true)
+	for _, r := range []DeliveryResult{nextResult(t, firstResult), nextResult(t, secondResult)} {
+		if r.Err != nil || r.Attempts != 1 {
+			t.Errorf("Enqueue() result = %+v, want delivered on the first attempt", r)
+		}
+	}
+	sent := sender.messages()
+	if len(sent) != 2 || sent[0].GetLabels()[CorrelationIDLabel] != first || sent[1].GetLabels()[CorrelationIDLabel] != second {
+		t.Errorf("Outbox sent messages %v, want %q then %q", sent, first, second)
+	}
+}
+
+func TestOutboxPersistFile(t *testing.T) {
+	ctx := context.Background()
+	sender := &outboxSender{}
+	sender.install(t)
+	path := filepath.Join(t.TempDir(), "outbox", "messages.jsonl")
+	disconnected := func() *client.Connection { return nil }
+
+	o, err := NewOutbox(ctx, OutboxOptions{PersistFile: path, Backoff: zeroBackoff}, disconnected)
+	if err != nil {
+		t.Fatalf("NewOutbox() returned unexpected error: %v", err)
+	}
+	stop := runOutbox(t, o)
+	var ids []string
+	for _, messageType := range []string{"first", "second"} {
+		id, results, err := o.Enqueue(ctx, "message_type", messageType, nil)
+		if err != nil {
+			t.Fatalf("Enqueue(%s) returned unexpected error: %v", messageType, err)
+		}
+		ids = append(ids, id)
+		defer func() {
+			if r := nextResult(t, results); !errors.Is(r.Err, ErrOutboxStopped) {
+				t.Errorf("Enqueue(%s) result = %+v, want error %v", messageType, r, ErrOutboxStopped)
+			}
+		}()
+	}
+	stop()
+
+	// A new outbox on the file sends the messages which were not sent.
+	delivered := make(chan DeliveryResult, 2)
+	o, err = NewOutbox(ctx, OutboxOptions{PersistFile: path, OnDelivery: func(r DeliveryResult) { delivered <- r }}, connected)
+	if err != nil {
+		t.Fatalf("NewOutbox() returned unexpected error: %v", err)
+	}
+	if got := o.Len(); got != 2 {
+		t.Errorf("Len() = %d after reopening the persist file, want 2", got)
+	}
+	runOutbox(t, o)
+	for _, id := range ids {
+		if r := nextResult(t, delivered); r.CorrelationID != id || r.Err != nil {
+			t.Errorf("OnDelivery() result = %+v, want %q delivered", r, id)
+		}
+	}
+
+	o, err = NewOutbox(ctx, OutboxOptions{PersistFile: path}, disconnected)
+	if err != nil {
+		t.Fatalf("NewOutbox() returned unexpected error: %v", err)
+	}
+	if got := o.Len(); got != 0 {
+		t.Errorf("Len() = %d after the messages were delivered, want 0", got)
+	}
+}
+
+func TestOutboxEnqueueAfterStop(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	o, err := NewOutbox(ctx, OutboxOptions{}, connected)
+	if err != nil {
+		t.Fatalf("NewOutbox() returned unexpected error: %v", err)
+	}
+	cancel()
+	o.Run(ctx)
+	if _, _, err := o.Enqueue(context.Background(), "message_type", "late", nil); !errors.Is(err, ErrOutboxStopped) {
+		t.Errorf("Enqueue() after Run returned error %v, want %v", err, ErrOutboxStopped)
+	}
+}
+
+func TestOutboxCompactsPersistFile(t *testing.T) {
+	ctx := context.Background()
+	sender := &outboxSender{}
+	sender.install(t)
+	path := filepath.Join(t.TempDir(), "messages.jsonl")
+	lines := func() int {
+		t.Helper()
+		data, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatalf("os.ReadFile(%q) failed: %v", path, err)
+		}
+		return strings.Count(string(data), "\n")
+	}
+	var isConnected atomic.Bool
+	// The number of records in the persist file after each message is done.
+	var records []int
+	o, err := NewOutbox(ctx, OutboxOptions{
+		Capacity:    outboxCompactThreshold + 1,
+		Backoff:     zeroBackoff,
+		PersistFile: path,
+		OnDelivery:  func(DeliveryResult) { records = append(records, lines()) },
+	}, func() *client.Connection {
+		if isConnected.Load() {
+			return connected()
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("NewOutbox() returned unexpected error: %v", err)
+	}
+	var last <-chan DeliveryResult
+	for i := 0; i <= outboxCompactThreshold; i++ {
+		if _, last, err = o.Enqueue(ctx, "message_type", "status", nil); err != nil {
+			t.Fatalf("Enqueue() returned unexpected error: %v", err)
+		}
+	}
+	runOutbox(t, o)
+	isConnected.Store(true)
+	nextResult(t, last)
+
+	// Before compaction the file has a queued record for every message and a done record for every
+	// message sent. It is compacted to the last message once outboxCompactThreshold messages are
+	// done, and is empty once the outbox is.
+	if got, want := records[outboxCompactThreshold-2], 2*outboxCompactThreshold; got != want {
+		t.Errorf("Persist file has %d records before compaction, want %d", got, want)
+	}
+	if got := records[outboxCompactThreshold-1]; got != 1 {
+		t.Errorf("Persist file has %d records after %d messages were done, want 1", got, outboxCompactThreshold)
+	}
+	if got := records[outboxCompactThreshold]; got != 0 {
+		t.Errorf("Persist file has %d records once the outbox is empty, want 0", got)
+	}
+}
+
+func TestOutboxFakeACS(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	server := fakeacs.New(t)
+	opts := ConnectOptions{Endpoint: server.Endpoint(), Channel: "test-channel", Backoff: zeroBackoff, ClientOptions: server.ClientOptions()}
+	s := NewSupervisor(opts, func(context.Context, *acpb.MessageBody, *client.Connection, *metadataserver.CloudProperties) error {
+		return nil
+	}, nil)
+	runCtx, stop := context.WithCancel(ctx)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		s.Run(runCtx, nil)
+	}()
+	t.Cleanup(func() {
+		stop()
+		<-done
+	})
+	o, err := NewOutbox(ctx, OutboxOptions{Backoff: zeroBackoff}, s.Connection)
+	if err != nil {
+		t.Fatalf("NewOutbox() returned unexpected error: %v", err)
+	}
+	runOutbox(t, o)
+
+	id, results, err := o.Enqueue(ctx, "message_type", "discovery", nil)
+	if err != nil {
+		t.Fatalf("Enqueue() returned unexpected error: %v", err)
+	}
+	if r := nextResult(t, results); r.Err != nil {
+		t.Errorf("Enqueue() result = %+v, want delivered", r)
+	}
+	got, err := server.Next(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.GetLabels()[CorrelationIDLabel] != id {
+		t.Errorf("Fake ACS received labels %v, want correlation ID %q", got.GetLabels(), id)
+	}
+}
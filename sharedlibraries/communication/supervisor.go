@@ -0,0 +1,204 @@
+/*
+Copyright 2025 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package communication
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/GoogleCloudPlatform/agentcommunication_client"
+	"github.com/cenkalti/backoff/v4"
+	"google.golang.org/api/option"
+	"github.com/GoogleCloudPlatform/workloadagentplatform/sharedlibraries/gce/metadataserver"
+	"github.com/GoogleCloudPlatform/workloadagentplatform/sharedlibraries/log"
+
+	acpb "github.com/GoogleCloudPlatform/agentcommunication_client/gapic/agentcommunicationpb"
+)
+
+// ErrFatal marks a connection handler error which stops a Supervisor. Handler errors which do not
+// wrap ErrFatal are transient, they are logged and the next message is handled.
+var ErrFatal = errors.New("fatal connection handler error")
+
+// ConnectionState is the state of the ACS connection of a Supervisor, for status reporting.
+type ConnectionState struct {
+	// Connected is true while messages are received on the connection.
+	Connected bool
+	// Reconnecting is true while the connection is being re-established after it failed.
+	Reconnecting bool
+	// LastError is the last error of the connection or of the handler, and LastErrorTime is when it
+	// happened.
+	LastError     error
+	LastErrorTime time.Time
+	// Reconnects is the number of times the connection was re-established.
+	Reconnects int
+}
+
+/*
+Supervisor listens for messages on an ACS channel like Listen, and keeps the channel alive. When
+the connection fails it is re-established with an exponential backoff, and transient handler
+errors do not stop it.
+
+The connection a message is received on is closed when it fails, so handlers which outlive it
+send their status messages on Connection. Messages sent while the channel is down are not
+delivered.
+*/
+type Supervisor struct {
+	options         ConnectOptions
+	handler         ConnectionHandler
+	cloudProperties *metadataserver.CloudProperties
+	connector       connector
+
+	mu    sync.Mutex
+	state ConnectionState
+	// conn is the connection messages are received on, nil while not connected.
+	conn *client.Connection
+}
+
+// connector creates, receives messages on and closes the connections of a Supervisor. Tests
+// replace it with a fake rather than swapping the package functions a running Supervisor uses.
+type connector struct {
+	create  func(ctx context.Context, channel string, regional bool, opts ...option.ClientOption) (*client.Connection, error)
+	receive func(c *client.Connection) (*acpb.MessageBody, error)
+	close   func(c *client.Connection)
+}
+
+// NewSupervisor returns a Supervisor for the channel of opts which handles messages with handler.
+func NewSupervisor(opts ConnectOptions, handler ConnectionHandler, cloudProperties *metadataserver.CloudProperties) *Supervisor {
+	return newSupervisor(opts, handler, cloudProperties, connector{
+		create:  createConnection,
+		receive: receive,
+		close:   (*client.Connection).Close,
+	})
+}
+
+func newSupervisor(opts ConnectOptions, handler ConnectionHandler, cloudProperties *metadataserver.CloudProperties, c connector) *Supervisor {
+	return &Supervisor{
+		options:         opts,
+		handler:         handler,
+		cloudProperties: cloudProperties,
+		connector:       c,
+	}
+}
+
+// State returns the current state of the connection.
+func (s *Supervisor) State() ConnectionState {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.state
+}
+
+/*
+Connection returns the current connection, or nil while the Supervisor is not connected. Handlers
+send the status of operations which outlive the connection they were received on through it, and
+it can be used as the connection of an Outbox.
+*/
+func (s *Supervisor) Connection() *client.Connection {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.conn
+}
+
+// Connect establishes a connection to ACS, retrying with an exponential backoff until it succeeds.
+// It returns nil if ctx is done first.
+func (s *Supervisor) Connect(ctx context.Context) *client.Connection {
+	return s.connect(ctx, s.options.backoff())
+}
+
+func (s *Supervisor) connect(ctx context.Context, b backoff.BackOff) *client.Connection {
+	for {
+		if conn := establishConnectionWith(ctx, s.options, s.connector.create); conn != nil {
+			return conn
+		}
+		s.recordError(errors.New("failed to establish connection to ACS"))
+		if !logAndBackoff(ctx, b, "Establishing connection failed. Will backoff and retry") {
+			return nil
+		}
+	}
+}
+
+/*
+Run listens for messages on conn, or on a new connection if conn is nil, until ctx is done or the
+handler returns an error wrapping ErrFatal. A connection which fails to receive messages is closed
+and re-established.
+*/
+func (s *Supervisor) Run(ctx context.Context, conn *client.Connection) error {
+	b := s.options.backoff()
+	handler := func(ctx context.Context, msg *acpb.MessageBody, conn *client.Connection, cp *metadataserver.CloudProperties) error {
+		// The connection is healthy once it delivers a message.
+		b.Reset()
+		err := s.handler(ctx, msg, conn, cp)
+		if err == nil || errors.Is(err, ErrFatal) {
+			return err
+		}
+		log.CtxLogger(ctx).Warnw("Connection handler failed, continuing with the next message", "err", err, "channel", s.options.Channel)
+		s.recordError(err)
+		return nil
+	}
+	for reconnecting := false; ; reconnecting = true {
+		if conn == nil {
+			s.setState(false, reconnecting)
+			if conn = s.connect(ctx, b); conn == nil {
+				s.setState(false, false)
+				return ctx.Err()
+			}
+		}
+		s.mu.Lock()
+		if reconnecting {
+			s.state.Reconnects++
+		}
+		s.state.Connected, s.state.Reconnecting = true, false
+		s.conn = conn
+		s.mu.Unlock()
+
+		err := listenWith(ctx, conn, s.connector.receive, handler, s.cloudProperties)
+		if ctx.Err() != nil {
+			s.setState(false, false)
+			return ctx.Err()
+		}
+		s.recordError(err)
+		if errors.Is(err, ErrFatal) {
+			log.CtxLogger(ctx).Errorw("Connection handler failed with a fatal error, stopping", "err", err, "channel", s.options.Channel)
+			s.setState(false, false)
+			return err
+		}
+		log.CtxLogger(ctx).Warnw("ACS connection failed", "err", err, "channel", s.options.Channel)
+		s.setState(false, true)
+		s.connector.close(conn)
+		conn = nil
+		if !logAndBackoff(ctx, b, "Will backoff and reconnect to ACS") {
+			s.setState(false, false)
+			return ctx.Err()
+		}
+	}
+}
+
+func (s *Supervisor) setState(connected, reconnecting bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.state.Connected, s.state.Reconnecting = connected, reconnecting
+	if !connected {
+		s.conn = nil
+	}
+}
+
+func (s *Supervisor) recordError(err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.state.LastError, s.state.LastErrorTime = err, time.Now()
+}
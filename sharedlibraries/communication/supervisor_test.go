@@ -0,0 +1,213 @@
+/*
+Copyright 2025 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package communication
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/GoogleCloudPlatform/agentcommunication_client"
+	"github.com/cenkalti/backoff/v4"
+	"google.golang.org/api/option"
+	"google.golang.org/grpc/codes"
+	"github.com/GoogleCloudPlatform/workloadagentplatform/sharedlibraries/communication/fakeacs"
+	"github.com/GoogleCloudPlatform/workloadagentplatform/sharedlibraries/gce/metadataserver"
+
+	acpb "github.com/GoogleCloudPlatform/agentcommunication_client/gapic/agentcommunicationpb"
+)
+
+// fakeACS is the connector of a Supervisor, replaying a sequence of received messages and errors.
+type fakeACS struct {
+	mu          sync.Mutex
+	connectErrs []error
+	received    []any
+	connections int
+	closed      int
+}
+
+func (f *fakeACS) connector() connector {
+	return connector{
+		create: func(ctx context.Context, channel string, regional bool, opts ...option.ClientOption) (*client.Connection, error) {
+			f.mu.Lock()
+			defer f.mu.Unlock()
+			if len(f.connectErrs) > 0 {
+				err := f.connectErrs[0]
+				f.connectErrs = f.connectErrs[1:]
+				return nil, err
+			}
+			f.connections++
+			return &client.Connection{}, nil
+		},
+		receive: func(c *client.Connection) (*acpb.MessageBody, error) {
+			f.mu.Lock()
+			defer f.mu.Unlock()
+			if len(f.received) == 0 {
+				return nil, errors.New("no more messages")
+			}
+			r := f.received[0]
+			f.received = f.received[1:]
+			if err, ok := r.(error); ok {
+				return nil, err
+			}
+			return r.(*acpb.MessageBody), nil
+		},
+		close: func(c *client.Connection) {
+			f.mu.Lock()
+			defer f.mu.Unlock()
+			f.closed++
+		},
+	}
+}
+
+// counts returns the number of connections made and closed.
+func (f *fakeACS) counts() (connections, closed int) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.connections, f.closed
+}
+
+func labelled(id string) *acpb.MessageBody {
+	return &acpb.MessageBody{Labels: map[string]string{"id": id}}
+}
+
+func zeroBackoff() backoff.BackOff {
+	return &backoff.ZeroBackOff{}
+}
+
+func newTestSupervisor(f *fakeACS, handler ConnectionHandler) *Supervisor {
+	return newSupervisor(ConnectOptions{Channel: "test-channel", Backoff: zeroBackoff}, handler, nil, f.connector())
+}
+
+func TestSupervisorReconnects(t *testing.T) {
+	f := &fakeACS{received: []any{labelled("1"), errors.New("stream closed"), labelled("2")}}
+	var handled []string
+	s := newTestSupervisor(f, func(ctx context.Context, msg *acpb.MessageBody, conn *client.Connection, cp *metadataserver.CloudProperties) error {
+		handled = append(handled, msg.GetLabels()["id"])
+		if len(handled) == 2 {
+			return fmt.Errorf("%w: stop", ErrFatal)
+		}
+		return nil
+	})
+
+	if err := s.Run(context.Background(), nil); !errors.Is(err, ErrFatal) {
+		t.Errorf("Run() returned error %v, want %v", err, ErrFatal)
+	}
+	if len(handled) != 2 {
+		t.Errorf("Run() handled messages %v, want 2 messages", handled)
+	}
+	if connections, closed := f.counts(); connections != 2 || closed != 1 {
+		t.Errorf("Run() made %d connections and closed %d, want 2 and 1", connections, closed)
+	}
+	state := s.State()
+	if state.Connected || state.Reconnecting || state.Reconnects != 1 || !errors.Is(state.LastError, ErrFatal) {
+		t.Errorf("State() = %+v, want disconnected with 1 reconnect and last error %v", state, ErrFatal)
+	}
+}
+
+func TestSupervisorTransientHandlerError(t *testing.T) {
+	f := &fakeACS{received: []any{labelled("1"), labelled("2")}}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	transient := errors.New("transient")
+	var handled []string
+	s := newTestSupervisor(f, func(ctx context.Context, msg *acpb.MessageBody, conn *client.Connection, cp *metadataserver.CloudProperties) error {
+		handled = append(handled, msg.GetLabels()["id"])
+		if len(handled) == 1 {
+			return transient
+		}
+		cancel()
+		return nil
+	})
+
+	if err := s.Run(ctx, &client.Connection{}); !errors.Is(err, context.Canceled) {
+		t.Errorf("Run() returned error %v, want %v", err, context.Canceled)
+	}
+	if len(handled) != 2 {
+		t.Errorf("Run() handled messages %v, want 2 messages", handled)
+	}
+	if connections, closed := f.counts(); connections != 0 || closed != 0 {
+		t.Errorf("Run() made %d connections and closed %d, want none", connections, closed)
+	}
+	if state := s.State(); state.Reconnects != 0 || !errors.Is(state.LastError, transient) {
+		t.Errorf("State() = %+v, want no reconnects and last error %v", state, transient)
+	}
+}
+
+func TestSupervisorConnect(t *testing.T) {
+	f := &fakeACS{connectErrs: []error{errors.New("unavailable"), errors.New("unavailable")}}
+	s := newTestSupervisor(f, nil)
+
+	if conn := s.Connect(context.Background()); conn == nil {
+		t.Errorf("Connect() = nil, want a connection")
+	}
+	if connections, _ := f.counts(); connections != 1 {
+		t.Errorf("Connect() made %d connections, want 1", connections)
+	}
+	if s.State().LastError == nil {
+		t.Errorf("State().LastError = nil, want the connection error")
+	}
+}
+
+func TestSupervisorConnectCancelled(t *testing.T) {
+	f := &fakeACS{connectErrs: []error{errors.New("unavailable")}}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	s := newSupervisor(ConnectOptions{Channel: "test-channel", Backoff: func() backoff.BackOff { return backoff.NewConstantBackOff(time.Hour) }}, nil, nil, f.connector())
+
+	if err := s.Run(ctx, nil); !errors.Is(err, context.Canceled) {
+		t.Errorf("Run() returned error %v, want %v", err, context.Canceled)
+	}
+	if state := s.State(); state.Connected || state.Reconnecting {
+		t.Errorf("State() = %+v, want disconnected", state)
+	}
+}
+
+func TestSupervisorFakeACS(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	server := fakeacs.New(t)
+	handled := make(chan string, 10)
+	opts := ConnectOptions{Endpoint: server.Endpoint(), Channel: "test-channel", Backoff: zeroBackoff, ClientOptions: server.ClientOptions()}
+	s := NewSupervisor(opts, func(ctx context.Context, msg *acpb.MessageBody, conn *client.Connection, cp *metadataserver.CloudProperties) error {
+		handled <- msg.GetLabels()["id"]
+		return nil
+	}, nil)
+	done := make(chan error)
+	go func() { done <- s.Run(ctx, nil) }()
+
+	server.Send(labelled("1"))
+	if got := <-handled; got != "1" {
+		t.Errorf("Run() handled message %q, want: %q", got, "1")
+	}
+	// The ACS client closes its connection after an unexpected stream error.
+	server.CloseStreams(codes.Internal)
+	server.Send(labelled("2"))
+	if got := <-handled; got != "2" {
+		t.Errorf("Run() handled message %q after reconnecting, want: %q", got, "2")
+	}
+	if state := s.State(); !state.Connected || state.Reconnects != 1 {
+		t.Errorf("State() = %+v, want connected with 1 reconnect", state)
+	}
+	cancel()
+	if err := <-done; !errors.Is(err, context.Canceled) {
+		t.Errorf("Run() returned error %v, want %v", err, context.Canceled)
+	}
+}
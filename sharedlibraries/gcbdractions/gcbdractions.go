@@ -0,0 +1,112 @@
+/*
+Copyright 2025 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package gcbdractions connects to the Agent Communication Service and handles the GCBDR actions
+// sent by the WorkloadActions service, such as deep discovery.
+// GCBDR actions are dispatched by guestactions, with the same locking, long-running operation
+// statuses and shell command handling as guest actions.
+package gcbdractions
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"github.com/GoogleCloudPlatform/workloadagentplatform/sharedlibraries/communication"
+	"github.com/GoogleCloudPlatform/workloadagentplatform/sharedlibraries/gce/metadataserver"
+	"github.com/GoogleCloudPlatform/workloadagentplatform/sharedlibraries/guestactions"
+	"github.com/GoogleCloudPlatform/workloadagentplatform/sharedlibraries/log"
+
+	gapb "github.com/GoogleCloudPlatform/workloadagentplatform/sharedprotos/gcbdractions"
+	gpb "github.com/GoogleCloudPlatform/workloadagentplatform/sharedprotos/guestactions"
+)
+
+// deepDiscoveryLockKey is the lock held by a deep discovery, so that only one runs at a time.
+const deepDiscoveryLockKey = "gcbdr_deep_discovery"
+
+// Protocol is the GCBDR action request and response messages.
+var Protocol = guestactions.NewProtocol(&gapb.GCBDRActionRequest{}, &gapb.GCBDRActionResponse{})
+
+// DeepDiscoveryCommand is the agent command of a deep discovery, the lower case name of
+// GCBDR_ACTION_DEEP_DISCOVERY.
+var DeepDiscoveryCommand = strings.ToLower(gapb.GCBDRAction_GCBDR_ACTION_DEEP_DISCOVERY.String())
+
+// GCBDRActions is a struct that holds the state for GCBDR actions.
+type GCBDRActions struct {
+	guestActions guestactions.GuestActions
+}
+
+// Options is a struct that holds the options for GCBDR actions.
+type Options struct {
+	Channel         string
+	Endpoint        string
+	CloudProperties *metadataserver.CloudProperties
+	// DeepDiscovery discovers the workloads of the instance for backup and DR, returning them in the
+	// payload of its result. It runs as a long-running operation, and deep discoveries requested
+	// while one is running wait for up to DeepDiscoveryWaitTimeout before failing.
+	DeepDiscovery            guestactions.GuestActionHandler
+	DeepDiscoveryWaitTimeout time.Duration
+	// Handlers are other GCBDR agent commands, which run synchronously.
+	Handlers map[string]guestactions.GuestActionHandler
+	// ShellCommandPolicyFile is optional and restricts shell commands as in guestactions.Options.
+	ShellCommandPolicyFile string
+	// DebugSocket is optional and serves the debug endpoint of guestactions.Options, which accepts
+	// GCBDRActionRequest messages.
+	DebugSocket string
+}
+
+// Start starts listening to ACS and handling the GCBDR actions.
+func (g *GCBDRActions) Start(ctx context.Context, a any) {
+	args, ok := a.(Options)
+	if !ok {
+		log.CtxLogger(ctx).Warn("Args is not of type Options")
+		return
+	}
+	g.guestActions.Start(ctx, guestActionsOptions(args))
+}
+
+// ConnectionState returns the state of the ACS connection.
+func (g *GCBDRActions) ConnectionState() communication.ConnectionState {
+	return g.guestActions.ConnectionState()
+}
+
+// guestActionsOptions returns the options of the guest actions dispatcher running GCBDR actions.
+func guestActionsOptions(args Options) guestactions.Options {
+	opts := guestactions.Options{
+		Channel:                args.Channel,
+		Endpoint:               args.Endpoint,
+		CloudProperties:        args.CloudProperties,
+		Handlers:               args.Handlers,
+		LROHandlers:            map[string]guestactions.GuestActionHandler{},
+		CommandConcurrencyKey:  commandConcurrencyKey,
+		LockWaitTimeout:        args.DeepDiscoveryWaitTimeout,
+		ShellCommandPolicyFile: args.ShellCommandPolicyFile,
+		DebugSocket:            args.DebugSocket,
+		Protocol:               Protocol,
+	}
+	if args.DeepDiscovery != nil {
+		opts.LROHandlers[DeepDiscoveryCommand] = args.DeepDiscovery
+	}
+	return opts
+}
+
+// commandConcurrencyKey locks deep discoveries so that only one runs at a time.
+func commandConcurrencyKey(ctx context.Context, command *gpb.Command, cp *metadataserver.CloudProperties) (string, time.Duration, bool) {
+	if strings.ToLower(command.GetAgentCommand().GetCommand()) != DeepDiscoveryCommand {
+		return "", 0, false
+	}
+	return deepDiscoveryLockKey, 0, true
+}
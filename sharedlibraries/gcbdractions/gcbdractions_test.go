@@ -0,0 +1,75 @@
+/*
+Copyright 2025 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package gcbdractions
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/GoogleCloudPlatform/workloadagentplatform/sharedlibraries/gce/metadataserver"
+
+	gpb "github.com/GoogleCloudPlatform/workloadagentplatform/sharedprotos/guestactions"
+)
+
+func agentCommand(command string) *gpb.Command {
+	return &gpb.Command{CommandType: &gpb.Command_AgentCommand{AgentCommand: &gpb.AgentCommand{Command: command}}}
+}
+
+func TestDeepDiscoveryCommand(t *testing.T) {
+	if want := "gcbdr_action_deep_discovery"; DeepDiscoveryCommand != want {
+		t.Errorf("DeepDiscoveryCommand = %q, want: %q", DeepDiscoveryCommand, want)
+	}
+}
+
+func TestGuestActionsOptions(t *testing.T) {
+	discover := func(ctx context.Context, command *gpb.Command, cp *metadataserver.CloudProperties) *gpb.CommandResult {
+		return &gpb.CommandResult{Command: command}
+	}
+	got := guestActionsOptions(Options{Channel: "gcbdr", DeepDiscovery: discover, DeepDiscoveryWaitTimeout: time.Minute})
+	if _, ok := got.LROHandlers[DeepDiscoveryCommand]; !ok || len(got.LROHandlers) != 1 {
+		t.Errorf("guestActionsOptions() LROHandlers = %v, want only %s", got.LROHandlers, DeepDiscoveryCommand)
+	}
+	if got.Channel != "gcbdr" || got.LockWaitTimeout != time.Minute || got.Protocol != Protocol {
+		t.Errorf("guestActionsOptions() = %+v, want the channel, wait timeout and GCBDR protocol set", got)
+	}
+
+	if got := guestActionsOptions(Options{}); len(got.LROHandlers) != 0 {
+		t.Errorf("guestActionsOptions() without DeepDiscovery LROHandlers = %v, want none", got.LROHandlers)
+	}
+}
+
+func TestCommandConcurrencyKey(t *testing.T) {
+	tests := []struct {
+		name    string
+		command *gpb.Command
+		wantKey string
+		wantOK  bool
+	}{
+		{name: "DeepDiscovery", command: agentCommand("GCBDR_ACTION_DEEP_DISCOVERY"), wantKey: deepDiscoveryLockKey, wantOK: true},
+		{name: "OtherAgentCommand", command: agentCommand("version")},
+		{name: "ShellCommand", command: &gpb.Command{CommandType: &gpb.Command_ShellCommand{ShellCommand: &gpb.ShellCommand{Command: "ls"}}}},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			key, _, ok := commandConcurrencyKey(context.Background(), tc.command, nil)
+			if key != tc.wantKey || ok != tc.wantOK {
+				t.Errorf("commandConcurrencyKey() = %q, %t, want: %q, %t", key, ok, tc.wantKey, tc.wantOK)
+			}
+		})
+	}
+}
@@ -23,6 +23,8 @@ require (
   google.golang.org/api v0.220.0
   google.golang.org/genproto v0.0.0-20250204164813-702378808489
   google.golang.org/genproto/googleapis/api v0.0.0-20250204164813-702378808489
+  google.golang.org/genproto/googleapis/rpc v0.0.0-20250127172529-29210b9bc287
+  google.golang.org/grpc v1.70.0
   google.golang.org/protobuf v1.36.5
 )
 
@@ -72,9 +74,11 @@ require (
   golang.org/x/sys v0.29.0 // indirect
   golang.org/x/text v0.21.0 // indirect
   golang.org/x/time v0.9.0 // indirect
-  google.golang.org/genproto/googleapis/rpc v0.0.0-20250127172529-29210b9bc287 // indirect
-  google.golang.org/grpc v1.70.0 // indirect
   gopkg.in/natefinch/lumberjack.v2 v2.2.1 // indirect
   gopkg.in/yaml.v2 v2.4.0 // indirect
   mvdan.cc/sh/v3 v3.7.0 // indirect
 )
+
+// sharedprotos is developed alongside sharedlibraries, so the local module is used until its
+// protos are published.
+replace github.com/GoogleCloudPlatform/workloadagentplatform/sharedprotos => ../sharedprotos
@@ -0,0 +1,189 @@
+/*
+Copyright 2025 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package guestactions
+
+import (
+	"context"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/encoding/prototext"
+	"github.com/GoogleCloudPlatform/workloadagentplatform/sharedlibraries/gce/metadataserver"
+	"github.com/GoogleCloudPlatform/workloadagentplatform/sharedlibraries/log"
+
+	anypb "google.golang.org/protobuf/types/known/anypb"
+	acpb "github.com/GoogleCloudPlatform/agentcommunication_client/gapic/agentcommunicationpb"
+)
+
+const (
+	// debugPath is the path of the debug endpoint which runs a GuestActionRequest.
+	debugPath = "/guestactions"
+	// maxDebugRequestBytes is the maximum size of a request to the debug endpoint.
+	maxDebugRequestBytes = 4 << 20
+)
+
+type statusSinkKey struct{}
+
+// statusSink receives the status messages of operations started by the debug endpoint in place of
+// ACS.
+type statusSink func(*acpb.MessageBody)
+
+// isDebugOperation reports whether the operation in ctx was started by the debug endpoint.
+func isDebugOperation(ctx context.Context) bool {
+	_, ok := ctx.Value(statusSinkKey{}).(statusSink)
+	return ok
+}
+
+/*
+serveDebug serves the debug endpoint on a Unix socket at path until ctx is done. The socket is
+only accessible to the user running the agent. A socket left at path by a previous run is replaced,
+any other file at path is an error. A request POSTs a GuestActionRequest, or the
+request message of Options.Protocol, to /guestactions, as JSON with a Content-Type of application/json or as prototext otherwise, and an
+optional operation_id query parameter. The request runs as if it was received from ACS, and the
+status messages of the operation are streamed back as lines of JSON until the final status.
+*/
+func (g *GuestActions) serveDebug(ctx context.Context, path string, cloudProperties *metadataserver.CloudProperties) error {
+	if fi, err := os.Lstat(path); err == nil {
+		if fi.Mode()&os.ModeSocket == 0 {
+			return fmt.Errorf("%s exists and is not a socket", path)
+		}
+		// A socket left behind by a previous run of the agent.
+		os.Remove(path)
+	}
+	// The socket is created in a directory only the agent's user can access, and is moved into place
+	// once its permissions are restricted, so that other users can never connect to it.
+	dir, err := os.MkdirTemp(filepath.Dir(path), ".debug-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(dir)
+	tmp := filepath.Join(dir, "debug.sock")
+	l, err := net.Listen("unix", tmp)
+	if err != nil {
+		return err
+	}
+	if err := os.Chmod(tmp, 0600); err != nil {
+		l.Close()
+		return err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		l.Close()
+		return err
+	}
+	defer os.Remove(path)
+	mux := http.NewServeMux()
+	mux.HandleFunc(debugPath, func(w http.ResponseWriter, r *http.Request) {
+		g.handleDebugRequest(ctx, w, r, cloudProperties)
+	})
+	srv := &http.Server{Handler: mux, ReadHeaderTimeout: 10 * time.Second}
+	go func() {
+		<-ctx.Done()
+		srv.Close()
+	}()
+	log.CtxLogger(ctx).Infow("Serving guest actions debug endpoint", "socket", path)
+	if err := srv.Serve(l); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
+}
+
+/*
+handleDebugRequest runs the request message of a debug request through connectionHandler and
+streams its status messages to the response. Debug operations are not recorded in the operation
+cache or the journal, so they are never persisted or replayed.
+*/
+func (g *GuestActions) handleDebugRequest(ctx context.Context, w http.ResponseWriter, r *http.Request, cloudProperties *metadataserver.CloudProperties) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	data, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxDebugRequestBytes))
+	if err != nil {
+		http.Error(w, fmt.Sprintf("reading request: %v", err), http.StatusBadRequest)
+		return
+	}
+	req := g.options.Protocol.newRequest()
+	if mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type")); mediaType == "application/json" {
+		err = protojson.Unmarshal(data, req)
+	} else {
+		err = prototext.Unmarshal(data, req)
+	}
+	if err != nil {
+		http.Error(w, fmt.Sprintf("parsing %s: %v", req.ProtoReflect().Descriptor().Name(), err), http.StatusBadRequest)
+		return
+	}
+	body, err := anypb.New(req)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	operationID := r.URL.Query().Get("operation_id")
+	if operationID == "" {
+		operationID = "debug-" + rand.Text()
+	}
+
+	// The operation runs to completion even if the caller goes away, its statuses are then dropped.
+	statuses := make(chan *acpb.MessageBody, 16)
+	gone := make(chan struct{})
+	defer close(gone)
+	sink := statusSink(func(msg *acpb.MessageBody) {
+		select {
+		case statuses <- msg:
+		case <-gone:
+		}
+	})
+	log.CtxLogger(ctx).Infow("Running guest action from the debug endpoint", "operation_id", operationID)
+	msg := &acpb.MessageBody{Labels: map[string]string{"operation_id": operationID}, Body: body}
+	if err := g.connectionHandler(context.WithValue(ctx, statusSinkKey{}, sink), msg, nil, cloudProperties); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	flusher, _ := w.(http.Flusher)
+	for {
+		select {
+		case status := <-statuses:
+			line, err := protojson.Marshal(status)
+			if err != nil {
+				log.CtxLogger(ctx).Warnw("Could not marshal status for the debug endpoint", "operation_id", operationID, "err", err)
+				continue
+			}
+			if _, err := w.Write(append(line, '\n')); err != nil {
+				return
+			}
+			if flusher != nil {
+				flusher.Flush()
+			}
+			if status.GetLabels()["lro_state"] == lroStateDone {
+				return
+			}
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
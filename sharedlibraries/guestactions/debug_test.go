@@ -0,0 +1,232 @@
+/*
+Copyright 2025 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package guestactions
+
+import (
+	"bufio"
+	"context"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"google.golang.org/protobuf/encoding/protojson"
+	"github.com/GoogleCloudPlatform/workloadagentplatform/sharedlibraries/gce/metadataserver"
+
+	acpb "github.com/GoogleCloudPlatform/agentcommunication_client/gapic/agentcommunicationpb"
+	gpb "github.com/GoogleCloudPlatform/workloadagentplatform/sharedprotos/guestactions"
+)
+
+// startDebugServer serves the debug endpoint of g and returns a client connected to its socket.
+func startDebugServer(t *testing.T, g *GuestActions) *http.Client {
+	t.Helper()
+	// Unix socket paths are limited in length, so the socket is not placed in t.TempDir().
+	dir, err := os.MkdirTemp("", "ga")
+	if err != nil {
+		t.Fatalf("os.MkdirTemp() failed: %v", err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	path := filepath.Join(dir, "debug.sock")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	served := make(chan error, 1)
+	go func() { served <- g.serveDebug(ctx, path, nil) }()
+	t.Cleanup(func() {
+		cancel()
+		if err := <-served; err != nil {
+			t.Errorf("serveDebug() returned unexpected error: %v", err)
+		}
+	})
+	for deadline := time.Now().Add(5 * time.Second); ; time.Sleep(time.Millisecond) {
+		if _, err := os.Stat(path); err == nil {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("serveDebug() did not create socket %s", path)
+		}
+	}
+	if fi, err := os.Stat(path); err != nil || fi.Mode().Perm() != 0600 {
+		t.Errorf("Debug socket mode = %v, %v, want: 0600", fi.Mode().Perm(), err)
+	}
+	return &http.Client{Transport: &http.Transport{
+		DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			return (&net.Dialer{}).DialContext(ctx, "unix", path)
+		},
+	}}
+}
+
+func TestDebugEndpoint(t *testing.T) {
+	tests := []struct {
+		name        string
+		contentType string
+		request     string
+		query       string
+		want        []string
+		wantStdout  string
+	}{
+		{
+			name:        "Prototext",
+			contentType: "text/plain",
+			request:     `commands: { agent_command: { command: "version" } }`,
+			want:        []string{"debug-*/succeeded/done"},
+			wantStdout:  "Google Cloud Agent for SAP version test response",
+		},
+		{
+			name:        "JSONLongRunningOperation",
+			contentType: "application/json; charset=utf-8",
+			request:     `{"commands": [{"agentCommand": {"command": "sap_stop"}}]}`,
+			query:       "?operation_id=op1",
+			want:        []string{"op1/running/running", "op1/succeeded/done"},
+			wantStdout:  "stopped",
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			dir := t.TempDir()
+			cacheFile, journalFile := filepath.Join(dir, "operations"), filepath.Join(dir, "journal")
+			journal, err := openJournal(journalFile, nil)
+			if err != nil {
+				t.Fatalf("openJournal() returned unexpected error: %v", err)
+			}
+			g := &GuestActions{
+				options: Options{
+					Handlers: testHandlers,
+					LROHandlers: map[string]GuestActionHandler{
+						"sap_stop": func(ctx context.Context, command *gpb.Command, cp *metadataserver.CloudProperties) *gpb.CommandResult {
+							return &gpb.CommandResult{Command: command, Stdout: "stopped"}
+						},
+					},
+				},
+				locker:         newLocker(),
+				operationCache: newOperationCache(context.Background(), OperationCacheOptions{File: cacheFile}),
+				journal:        journal,
+			}
+			client := startDebugServer(t, g)
+			resp, err := client.Post("http://unix"+debugPath+tc.query, tc.contentType, strings.NewReader(tc.request))
+			if err != nil {
+				t.Fatalf("Post() failed: %v", err)
+			}
+			defer resp.Body.Close()
+			if resp.StatusCode != http.StatusOK {
+				t.Fatalf("Post() returned status %d, want: %d", resp.StatusCode, http.StatusOK)
+			}
+
+			var got []string
+			last := &acpb.MessageBody{}
+			scanner := bufio.NewScanner(resp.Body)
+			for scanner.Scan() {
+				last = &acpb.MessageBody{}
+				if err := protojson.Unmarshal(scanner.Bytes(), last); err != nil {
+					t.Fatalf("protojson.Unmarshal(%s) failed: %v", scanner.Text(), err)
+				}
+				l := last.GetLabels()
+				id := l["operation_id"]
+				if strings.HasPrefix(id, "debug-") {
+					id = "debug-*"
+				}
+				got = append(got, id+"/"+l["state"]+"/"+l["lro_state"])
+			}
+			if diff := cmp.Diff(tc.want, got); diff != "" {
+				t.Errorf("Debug endpoint streamed unexpected statuses (-want +got):\n%s", diff)
+			}
+			gar := &gpb.GuestActionResponse{}
+			if err := last.GetBody().UnmarshalTo(gar); err != nil {
+				t.Fatalf("UnmarshalTo() failed: %v", err)
+			}
+			if got := gar.GetCommandResults()[0].GetStdout(); got != tc.wantStdout {
+				t.Errorf("Debug endpoint final status stdout = %q, want: %q", got, tc.wantStdout)
+			}
+			// Debug operations are neither persisted nor journaled.
+			if _, err := os.Stat(cacheFile); !os.IsNotExist(err) {
+				t.Errorf("Debug endpoint persisted the operation cache to %s, want no cache file", cacheFile)
+			}
+			if data, err := os.ReadFile(journalFile); err != nil || len(data) != 0 {
+				t.Errorf("Debug endpoint journaled %q, %v, want an empty journal", data, err)
+			}
+		})
+	}
+}
+
+func TestDebugEndpointInvalidRequests(t *testing.T) {
+	client := startDebugServer(t, &GuestActions{locker: newLocker()})
+	tests := []struct {
+		name   string
+		method string
+		body   string
+		want   int
+	}{
+		{name: "Get", method: http.MethodGet, want: http.StatusMethodNotAllowed},
+		{name: "InvalidRequest", method: http.MethodPost, body: "commands: {", want: http.StatusBadRequest},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			req, err := http.NewRequest(tc.method, "http://unix"+debugPath, strings.NewReader(tc.body))
+			if err != nil {
+				t.Fatalf("http.NewRequest() failed: %v", err)
+			}
+			resp, err := client.Do(req)
+			if err != nil {
+				t.Fatalf("Do() failed: %v", err)
+			}
+			resp.Body.Close()
+			if resp.StatusCode != tc.want {
+				t.Errorf("Do(%s) returned status %d, want: %d", tc.method, resp.StatusCode, tc.want)
+			}
+		})
+	}
+}
+
+func TestDebugEndpointUniqueOperationIDs(t *testing.T) {
+	client := startDebugServer(t, &GuestActions{options: Options{Handlers: testHandlers}, locker: newLocker()})
+	ids := make(map[string]bool)
+	for i := 0; i < 3; i++ {
+		resp, err := client.Post("http://unix"+debugPath, "text/plain", strings.NewReader(`commands: { agent_command: { command: "version" } }`))
+		if err != nil {
+			t.Fatalf("Post() failed: %v", err)
+		}
+		msg := &acpb.MessageBody{}
+		scanner := bufio.NewScanner(resp.Body)
+		for scanner.Scan() {
+			if err := protojson.Unmarshal(scanner.Bytes(), msg); err != nil {
+				t.Fatalf("protojson.Unmarshal(%s) failed: %v", scanner.Text(), err)
+			}
+		}
+		resp.Body.Close()
+		ids[msg.GetLabels()["operation_id"]] = true
+	}
+	if len(ids) != 3 {
+		t.Errorf("Debug endpoint used operation IDs %v for 3 requests, want 3 unique IDs", ids)
+	}
+}
+
+func TestServeDebugExistingFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "debug.sock")
+	if err := os.WriteFile(path, []byte("config"), 0600); err != nil {
+		t.Fatalf("os.WriteFile(%q) failed: %v", path, err)
+	}
+	g := &GuestActions{locker: newLocker()}
+	if err := g.serveDebug(context.Background(), path, nil); err == nil {
+		t.Errorf("serveDebug(%q) with an existing file returned nil error, want an error", path)
+	}
+	if data, err := os.ReadFile(path); err != nil || string(data) != "config" {
+		t.Errorf("serveDebug(%q) replaced the existing file, read %q, %v", path, data, err)
+	}
+}
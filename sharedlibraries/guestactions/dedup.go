@@ -0,0 +1,243 @@
+/*
+Copyright 2025 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package guestactions
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/GoogleCloudPlatform/agentcommunication_client"
+	"google.golang.org/protobuf/proto"
+	"github.com/GoogleCloudPlatform/workloadagentplatform/sharedlibraries/log"
+
+	anypb "google.golang.org/protobuf/types/known/anypb"
+)
+
+const (
+	// defaultOperationCacheSize is the default number of operation IDs remembered.
+	defaultOperationCacheSize = 1000
+	// defaultOperationCacheTTL is the default time an operation ID is remembered after it is received.
+	defaultOperationCacheTTL = 24 * time.Hour
+)
+
+type (
+	// OperationCacheOptions configures how guest actions detect operations delivered more than once.
+	OperationCacheOptions struct {
+		// Size is the maximum number of operation IDs remembered. The oldest are forgotten first.
+		// Defaults to 1000.
+		Size int
+		// TTL is how long an operation ID is remembered after it is received. Defaults to 24 hours.
+		TTL time.Duration
+		// File is optional and is the path where the final results of completed operations are
+		// persisted, so that duplicates are still detected after the agent restarts.
+		File string
+	}
+
+	// cachedOperation is an operation ID which has been received, with its final status once sent.
+	cachedOperation struct {
+		OperationID string    `json:"operation_id"`
+		Expiry      time.Time `json:"expiry"`
+		Done        bool      `json:"done"`
+		Status      string    `json:"status,omitempty"`
+		// Body is the serialized anypb.Any body of the final status message.
+		Body []byte `json:"body,omitempty"`
+	}
+
+	/*
+		operationCache remembers the operation IDs received by guest actions, so that a message ACS
+		delivers more than once is not executed again. The methods of a nil operationCache do nothing
+		and report every operation as new.
+	*/
+	operationCache struct {
+		opts OperationCacheOptions
+		now  func() time.Time
+
+		mu         sync.Mutex
+		operations map[string]*cachedOperation
+		// order holds the cached operation IDs from the oldest to the newest.
+		order []string
+	}
+)
+
+// newOperationCache returns an operationCache with the options, loading the persisted operations
+// from opts.File if it is set.
+func newOperationCache(ctx context.Context, opts OperationCacheOptions) *operationCache {
+	if opts.Size <= 0 {
+		opts.Size = defaultOperationCacheSize
+	}
+	if opts.TTL <= 0 {
+		opts.TTL = defaultOperationCacheTTL
+	}
+	c := &operationCache{opts: opts, now: time.Now, operations: make(map[string]*cachedOperation)}
+	if opts.File == "" {
+		return c
+	}
+	data, err := os.ReadFile(opts.File)
+	if errors.Is(err, os.ErrNotExist) {
+		return c
+	}
+	var persisted []*cachedOperation
+	if err == nil {
+		err = json.Unmarshal(data, &persisted)
+	}
+	if err != nil {
+		log.CtxLogger(ctx).Warnw("Could not load the operation cache, starting with an empty cache", "file", opts.File, "error", err)
+		return c
+	}
+	for _, op := range persisted {
+		c.add(op)
+	}
+	c.purge()
+	return c
+}
+
+/*
+begin records that the operation has been received. It returns the cached operation and true if
+the operation ID has been received before, in which case the operation must not be executed again.
+*/
+func (c *operationCache) begin(operationID string) (cachedOperation, bool) {
+	if c == nil {
+		return cachedOperation{}, false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.purge()
+	if op, ok := c.operations[operationID]; ok {
+		return *op, true
+	}
+	c.add(&cachedOperation{OperationID: operationID, Expiry: c.now().Add(c.opts.TTL)})
+	c.purge()
+	return cachedOperation{}, false
+}
+
+// complete records the final status of the operation, to be re-sent to duplicates of it.
+func (c *operationCache) complete(ctx context.Context, operationID, status string, body *anypb.Any) {
+	if c == nil {
+		return
+	}
+	data, err := proto.Marshal(body)
+	if err != nil {
+		log.CtxLogger(ctx).Warnw("Could not serialize the final status for the operation cache", "operation_id", operationID, "error", err)
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	op, ok := c.operations[operationID]
+	if !ok {
+		op = &cachedOperation{OperationID: operationID, Expiry: c.now().Add(c.opts.TTL)}
+		c.add(op)
+		c.purge()
+	}
+	op.Done, op.Status, op.Body = true, status, data
+	c.persist(ctx)
+}
+
+// forget removes the operation, so that it is executed if it is delivered again.
+func (c *operationCache) forget(operationID string) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.operations, operationID)
+	c.order = slices.DeleteFunc(c.order, func(id string) bool { return id == operationID })
+}
+
+// add caches op as the newest operation. c.mu must be held.
+func (c *operationCache) add(op *cachedOperation) {
+	c.operations[op.OperationID] = op
+	c.order = append(c.order, op.OperationID)
+}
+
+// purge removes the expired operations and the oldest operations beyond the size of the cache.
+// c.mu must be held.
+func (c *operationCache) purge() {
+	now := c.now()
+	c.order = slices.DeleteFunc(c.order, func(id string) bool {
+		if now.After(c.operations[id].Expiry) {
+			delete(c.operations, id)
+			return true
+		}
+		return false
+	})
+	for len(c.order) > c.opts.Size {
+		delete(c.operations, c.order[0])
+		c.order = c.order[1:]
+	}
+}
+
+// persist writes the completed operations to the cache file, if it is set. c.mu must be held.
+func (c *operationCache) persist(ctx context.Context) {
+	if c.opts.File == "" {
+		return
+	}
+	var done []*cachedOperation
+	for _, id := range c.order {
+		if op := c.operations[id]; op.Done {
+			done = append(done, op)
+		}
+	}
+	data, err := json.Marshal(done)
+	if err == nil {
+		err = os.MkdirAll(filepath.Dir(c.opts.File), 0750)
+	}
+	if err == nil {
+		tmp := c.opts.File + ".tmp"
+		if err = os.WriteFile(tmp, data, 0600); err == nil {
+			err = os.Rename(tmp, c.opts.File)
+		}
+	}
+	if err != nil {
+		log.CtxLogger(ctx).Warnw("Could not persist the operation cache", "file", c.opts.File, "error", err)
+	}
+}
+
+/*
+handleDuplicate answers an operation which has been received before without executing it again.
+A duplicate of an operation which is still running gets a "running" status, and a duplicate of a
+completed operation gets its final status re-sent.
+*/
+func (g *GuestActions) handleDuplicate(ctx context.Context, op cachedOperation, conn *client.Connection) error {
+	body := anyResponse(ctx, guestActionResponse(ctx, nil, ""))
+	status, lroState := statusRunning, lroStateRunning
+	if op.Done {
+		cached := &anypb.Any{}
+		if err := proto.Unmarshal(op.Body, cached); err != nil {
+			log.CtxLogger(ctx).Warnw("Could not parse the cached final status", "operation_id", op.OperationID, "error", err)
+		} else {
+			body = cached
+		}
+		status, lroState = op.Status, lroStateDone
+	}
+	log.CtxLogger(ctx).Infow("Received duplicate operation, not executing it again", "operation_id", op.OperationID, "channel", g.options.Channel, "status", status)
+	if err := g.sendStatusMessage(ctx, op.OperationID, body, status, lroState, conn); err != nil {
+		return fmt.Errorf("failed to send status message: %v", err)
+	}
+	return nil
+}
+
+// sendFinalStatus sends the final status of an operation and caches it for duplicates.
+func (g *GuestActions) sendFinalStatus(ctx context.Context, operationID string, body *anypb.Any, status string, conn *client.Connection) error {
+	g.cacheFor(ctx).complete(ctx, operationID, status, body)
+	return g.sendStatusMessage(ctx, operationID, body, status, lroStateDone, conn)
+}
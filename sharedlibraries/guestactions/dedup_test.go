@@ -0,0 +1,143 @@
+/*
+Copyright 2025 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package guestactions
+
+import (
+	"context"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"google.golang.org/protobuf/testing/protocmp"
+	"github.com/GoogleCloudPlatform/workloadagentplatform/sharedlibraries/gce/metadataserver"
+
+	acpb "github.com/GoogleCloudPlatform/agentcommunication_client/gapic/agentcommunicationpb"
+	gpb "github.com/GoogleCloudPlatform/workloadagentplatform/sharedprotos/guestactions"
+)
+
+func TestOperationCache(t *testing.T) {
+	ctx := context.Background()
+	now := time.Unix(1000, 0)
+	c := newOperationCache(ctx, OperationCacheOptions{Size: 2, TTL: time.Minute})
+	c.now = func() time.Time { return now }
+
+	if _, duplicate := c.begin("op1"); duplicate {
+		t.Errorf("begin(op1) reported a new operation as a duplicate")
+	}
+	if op, duplicate := c.begin("op1"); !duplicate || op.Done {
+		t.Errorf("begin(op1) again = %+v, %t, want an in-flight duplicate", op, duplicate)
+	}
+	c.complete(ctx, "op1", statusSucceeded, anyResponse(ctx, guestActionResponse(ctx, nil, "")))
+	if op, duplicate := c.begin("op1"); !duplicate || !op.Done || op.Status != statusSucceeded {
+		t.Errorf("begin(op1) after complete = %+v, %t, want a done duplicate", op, duplicate)
+	}
+
+	// The oldest operation is forgotten once the cache is full.
+	c.begin("op2")
+	c.begin("op3")
+	if _, duplicate := c.begin("op1"); duplicate {
+		t.Errorf("begin(op1) after the cache is full reported a duplicate, want op1 evicted")
+	}
+
+	// Operations are forgotten after the TTL.
+	now = now.Add(2 * time.Minute)
+	if _, duplicate := c.begin("op3"); duplicate {
+		t.Errorf("begin(op3) after the TTL reported a duplicate, want op3 expired")
+	}
+
+	c.forget("op3")
+	if _, duplicate := c.begin("op3"); duplicate {
+		t.Errorf("begin(op3) after forget reported a duplicate")
+	}
+}
+
+func TestOperationCachePersisted(t *testing.T) {
+	ctx := context.Background()
+	opts := OperationCacheOptions{File: filepath.Join(t.TempDir(), "operations.json")}
+	c := newOperationCache(ctx, opts)
+	c.begin("op1")
+	c.begin("op2")
+	body := anyResponse(ctx, guestActionResponse(ctx, []*gpb.CommandResult{{Stdout: "stopped"}}, ""))
+	c.complete(ctx, "op1", statusSucceeded, body)
+
+	c = newOperationCache(ctx, opts)
+	op, duplicate := c.begin("op1")
+	if !duplicate || !op.Done || op.Status != statusSucceeded {
+		t.Fatalf("begin(op1) after reloading = %+v, %t, want a done duplicate", op, duplicate)
+	}
+	if _, duplicate := c.begin("op2"); duplicate {
+		t.Errorf("begin(op2) after reloading reported a duplicate, want in-flight operations not persisted")
+	}
+}
+
+func TestConnectionHandlerDuplicate(t *testing.T) {
+	ctx := context.Background()
+	recorder := newStatusRecorder(t)
+	proceed := make(chan struct{})
+	var calls atomic.Int32
+	g := &GuestActions{
+		options: Options{
+			LROHandlers: map[string]GuestActionHandler{
+				"sap_stop": func(ctx context.Context, command *gpb.Command, cp *metadataserver.CloudProperties) *gpb.CommandResult {
+					calls.Add(1)
+					<-proceed
+					return &gpb.CommandResult{Command: command, Stdout: "stopped"}
+				},
+			},
+		},
+		locker:         newLocker(),
+		operationCache: newOperationCache(ctx, OperationCacheOptions{}),
+	}
+	msg := requestMessage(t, "op1", &gpb.GuestActionRequest{Commands: []*gpb.Command{
+		{CommandType: &gpb.Command_AgentCommand{AgentCommand: &gpb.AgentCommand{Command: "sap_stop"}}},
+	}})
+
+	deliver := func() {
+		t.Helper()
+		if err := g.connectionHandler(ctx, msg, nil, nil); err != nil {
+			t.Fatalf("connectionHandler() returned unexpected error: %v", err)
+		}
+	}
+	var got []string
+	next := func() *acpb.MessageBody {
+		m := recorder.next(t)
+		got = append(got, m.GetLabels()["state"]+"/"+m.GetLabels()["lro_state"])
+		return m
+	}
+
+	deliver()
+	next()
+	deliver()
+	next()
+	close(proceed)
+	final := next()
+	deliver()
+	resent := next()
+
+	want := []string{"running/running", "running/running", "succeeded/done", "succeeded/done"}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("connectionHandler() sent unexpected statuses for duplicates (-want +got):\n%s", diff)
+	}
+	if diff := cmp.Diff(final.GetBody(), resent.GetBody(), protocmp.Transform()); diff != "" {
+		t.Errorf("connectionHandler() re-sent a different final result (-want +got):\n%s", diff)
+	}
+	if n := calls.Load(); n != 1 {
+		t.Errorf("connectionHandler() ran the handler %d times, want 1", n)
+	}
+}
@@ -0,0 +1,231 @@
+/*
+Copyright 2025 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package guestactions
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/GoogleCloudPlatform/workloadagentplatform/sharedlibraries/gce/metadataserver"
+
+	gpb "github.com/GoogleCloudPlatform/workloadagentplatform/sharedprotos/guestactions"
+)
+
+// commandState is the progress of a command of a GuestActionRequest.
+type commandState int
+
+const (
+	commandPending commandState = iota
+	commandRunning
+	commandSucceeded
+	commandFailed
+	// commandNotRun is a command which depends on a command which failed or was not run.
+	commandNotRun
+)
+
+// commandFinished is the outcome of a command run by processCommands.
+type commandFinished struct {
+	index  int
+	result *gpb.CommandResult
+	err    error
+}
+
+// commandLabel names a command in error messages, by its name or its position in the request.
+func commandLabel(commands []*gpb.Command, i int) string {
+	if name := commands[i].GetName(); name != "" {
+		return fmt.Sprintf("%q", name)
+	}
+	return fmt.Sprintf("#%d", i+1)
+}
+
+/*
+commandDependencies returns the indexes of the commands each command depends on. It returns an
+error if command names are not unique, if depends_on names an unknown command, or if the
+dependencies form a cycle.
+*/
+func commandDependencies(commands []*gpb.Command) ([][]int, error) {
+	indexes := make(map[string]int)
+	for i, c := range commands {
+		if c.GetName() == "" {
+			continue
+		}
+		if _, ok := indexes[c.GetName()]; ok {
+			return nil, fmt.Errorf("invalid request: command name %q is not unique", c.GetName())
+		}
+		indexes[c.GetName()] = i
+	}
+	deps := make([][]int, len(commands))
+	for i, c := range commands {
+		for _, name := range c.GetDependsOn() {
+			d, ok := indexes[name]
+			if !ok {
+				return nil, fmt.Errorf("invalid request: command %s depends on unknown command %q", commandLabel(commands, i), name)
+			}
+			deps[i] = append(deps[i], d)
+		}
+	}
+
+	// Depth first search for a cycle, visiting marks the commands on the current path.
+	const (
+		unvisited = iota
+		visiting
+		visited
+	)
+	marks := make([]int, len(commands))
+	var visit func(i int) error
+	visit = func(i int) error {
+		switch marks[i] {
+		case visiting:
+			return fmt.Errorf("invalid request: the dependencies of command %s form a cycle", commandLabel(commands, i))
+		case visited:
+			return nil
+		}
+		marks[i] = visiting
+		for _, d := range deps[i] {
+			if err := visit(d); err != nil {
+				return err
+			}
+		}
+		marks[i] = visited
+		return nil
+	}
+	for i := range commands {
+		if err := visit(i); err != nil {
+			return nil, err
+		}
+	}
+	return deps, nil
+}
+
+/*
+processCommands executes the commands of the request following its ExecutionOptions and returns
+the results of the commands which ran, in request order. It returns an error if any command fails
+or has a non-zero exit code, or if a command is not run because a command it depends on failed.
+
+At most one command runs at a time unless the options are parallel. A command starts once the
+commands it depends on have succeeded, earlier commands in the request first. No more commands
+start after a failure unless the options continue on error, or once ctx is done.
+*/
+func (g *GuestActions) processCommands(ctx context.Context, gar *gpb.GuestActionRequest, cloudProperties *metadataserver.CloudProperties) ([]*gpb.CommandResult, error) {
+	commands := gar.GetCommands()
+	deps, err := commandDependencies(commands)
+	if err != nil {
+		return nil, err
+	}
+	opts := gar.GetExecutionOptions()
+	limit := 1
+	if opts.GetParallel() {
+		limit = int(opts.GetMaxConcurrency())
+		if limit <= 0 {
+			limit = len(commands)
+		}
+	}
+
+	states := make([]commandState, len(commands))
+	results := make([]*gpb.CommandResult, len(commands))
+	errs := make([]error, len(commands))
+	finished := make(chan commandFinished)
+	running := 0
+	stopped := false
+	for {
+		if !stopped && ctx.Err() == nil {
+			markNotRun(states, deps)
+			for i := range commands {
+				if running >= limit {
+					break
+				}
+				if states[i] != commandPending || !dependenciesSucceeded(states, deps[i]) {
+					continue
+				}
+				states[i] = commandRunning
+				running++
+				go func() {
+					result, err := g.runCommand(ctx, commands[i], cloudProperties)
+					finished <- commandFinished{index: i, result: result, err: err}
+				}()
+			}
+		}
+		if running == 0 {
+			break
+		}
+		f := <-finished
+		running--
+		results[f.index] = f.result
+		states[f.index] = commandSucceeded
+		if f.err != nil {
+			states[f.index] = commandFailed
+			errs[f.index] = f.err
+			stopped = !opts.GetContinueOnError()
+		}
+	}
+
+	var ran []*gpb.CommandResult
+	for _, r := range results {
+		if r != nil {
+			ran = append(ran, r)
+		}
+	}
+	var allErrs []error
+	var notRun []string
+	if ctx.Err() != nil && slices.Contains(states, commandPending) {
+		allErrs = append(allErrs, fmt.Errorf("stopped before running all commands: %w", context.Cause(ctx)))
+	}
+	for i, s := range states {
+		switch s {
+		case commandFailed:
+			allErrs = append(allErrs, errs[i])
+		case commandNotRun:
+			notRun = append(notRun, commandLabel(commands, i))
+		}
+	}
+	if len(notRun) > 0 {
+		allErrs = append(allErrs, fmt.Errorf("not run because a command they depend on failed: %s", strings.Join(notRun, ", ")))
+	}
+	return ran, errors.Join(allErrs...)
+}
+
+// dependenciesSucceeded returns true if every command in deps has succeeded.
+func dependenciesSucceeded(states []commandState, deps []int) bool {
+	for _, d := range deps {
+		if states[d] != commandSucceeded {
+			return false
+		}
+	}
+	return true
+}
+
+// markNotRun marks the pending commands which depend on a command which failed or was not run.
+func markNotRun(states []commandState, deps [][]int) {
+	for changed := true; changed; {
+		changed = false
+		for i, s := range states {
+			if s != commandPending {
+				continue
+			}
+			for _, d := range deps[i] {
+				if states[d] == commandFailed || states[d] == commandNotRun {
+					states[i] = commandNotRun
+					changed = true
+					break
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,221 @@
+/*
+Copyright 2025 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package guestactions
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/GoogleCloudPlatform/workloadagentplatform/sharedlibraries/gce/metadataserver"
+
+	gpb "github.com/GoogleCloudPlatform/workloadagentplatform/sharedprotos/guestactions"
+)
+
+// executionRecorder provides agent command handlers which record the order and concurrency of
+// the commands they run.
+type executionRecorder struct {
+	mu      sync.Mutex
+	running int
+	max     int
+	started []string
+}
+
+func (r *executionRecorder) handlers() map[string]GuestActionHandler {
+	run := func(exitCode int32) GuestActionHandler {
+		return func(ctx context.Context, command *gpb.Command, cp *metadataserver.CloudProperties) *gpb.CommandResult {
+			r.mu.Lock()
+			r.running++
+			r.max = max(r.max, r.running)
+			r.started = append(r.started, command.GetName())
+			r.mu.Unlock()
+			time.Sleep(20 * time.Millisecond)
+			r.mu.Lock()
+			r.running--
+			r.mu.Unlock()
+			return &gpb.CommandResult{Command: command, Stdout: command.GetName(), ExitCode: exitCode}
+		}
+	}
+	return map[string]GuestActionHandler{"succeed": run(0), "fail": run(1)}
+}
+
+// namedCommand returns an agent command running handler, named name and depending on dependsOn.
+func namedCommand(name, handler string, dependsOn ...string) *gpb.Command {
+	return &gpb.Command{
+		CommandType: &gpb.Command_AgentCommand{AgentCommand: &gpb.AgentCommand{Command: handler}},
+		Name:        name,
+		DependsOn:   dependsOn,
+	}
+}
+
+func TestCommandDependencies(t *testing.T) {
+	tests := []struct {
+		name     string
+		commands []*gpb.Command
+		want     [][]int
+		wantErr  string
+	}{
+		{
+			name:     "NoDependencies",
+			commands: []*gpb.Command{namedCommand("", "succeed"), namedCommand("", "succeed")},
+			want:     [][]int{nil, nil},
+		},
+		{
+			name:     "Dependencies",
+			commands: []*gpb.Command{namedCommand("c", "succeed", "a", "b"), namedCommand("a", "succeed"), namedCommand("b", "succeed", "a")},
+			want:     [][]int{{1, 2}, nil, {1}},
+		},
+		{
+			name:     "DuplicateName",
+			commands: []*gpb.Command{namedCommand("a", "succeed"), namedCommand("a", "succeed")},
+			wantErr:  `command name "a" is not unique`,
+		},
+		{
+			name:     "UnknownDependency",
+			commands: []*gpb.Command{namedCommand("", "succeed", "a")},
+			wantErr:  `command #1 depends on unknown command "a"`,
+		},
+		{
+			name:     "Cycle",
+			commands: []*gpb.Command{namedCommand("a", "succeed", "c"), namedCommand("b", "succeed", "a"), namedCommand("c", "succeed", "b")},
+			wantErr:  `the dependencies of command "a" form a cycle`,
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := commandDependencies(tc.commands)
+			if tc.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tc.wantErr) {
+					t.Errorf("commandDependencies() returned error: %v, want error containing: %q", err, tc.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("commandDependencies() returned unexpected error: %v", err)
+			}
+			if diff := cmp.Diff(tc.want, got); diff != "" {
+				t.Errorf("commandDependencies() returned diff (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestProcessCommandsExecutionOptions(t *testing.T) {
+	tests := []struct {
+		name        string
+		commands    []*gpb.Command
+		opts        *gpb.ExecutionOptions
+		wantResults []string
+		wantStarted []string
+		wantLast    string
+		wantMax     int
+		wantErr     string
+	}{
+		{
+			name:        "SequentialStopsAtFailure",
+			commands:    []*gpb.Command{namedCommand("a", "succeed"), namedCommand("b", "fail"), namedCommand("c", "succeed")},
+			wantResults: []string{"a", "b"},
+			wantStarted: []string{"a", "b"},
+			wantMax:     1,
+			wantErr:     "received nonzero exit code with output: b",
+		},
+		{
+			name:        "ContinueOnError",
+			commands:    []*gpb.Command{namedCommand("a", "fail"), namedCommand("b", "succeed"), namedCommand("c", "fail")},
+			opts:        &gpb.ExecutionOptions{ContinueOnError: true},
+			wantResults: []string{"a", "b", "c"},
+			wantStarted: []string{"a", "b", "c"},
+			wantMax:     1,
+			wantErr:     "received nonzero exit code with output: a\nreceived nonzero exit code with output: c",
+		},
+		{
+			name:        "ParallelWithConcurrencyCap",
+			commands:    []*gpb.Command{namedCommand("a", "succeed"), namedCommand("b", "succeed"), namedCommand("c", "succeed"), namedCommand("d", "succeed")},
+			opts:        &gpb.ExecutionOptions{Parallel: true, MaxConcurrency: 2},
+			wantResults: []string{"a", "b", "c", "d"},
+			wantMax:     2,
+		},
+		{
+			name:        "ParallelWithoutCap",
+			commands:    []*gpb.Command{namedCommand("a", "succeed"), namedCommand("b", "succeed"), namedCommand("c", "succeed")},
+			opts:        &gpb.ExecutionOptions{Parallel: true},
+			wantResults: []string{"a", "b", "c"},
+			wantMax:     3,
+		},
+		{
+			name:        "DependenciesRunFirst",
+			commands:    []*gpb.Command{namedCommand("report", "succeed", "host1", "host2"), namedCommand("host1", "succeed"), namedCommand("host2", "succeed")},
+			opts:        &gpb.ExecutionOptions{Parallel: true},
+			wantResults: []string{"report", "host1", "host2"},
+			wantLast:    "report",
+			wantMax:     2,
+		},
+		{
+			name: "DependentsOfFailureNotRun",
+			commands: []*gpb.Command{
+				namedCommand("stop", "fail"), namedCommand("start", "succeed", "stop"), namedCommand("check", "succeed", "start"), namedCommand("other", "succeed"),
+			},
+			opts:        &gpb.ExecutionOptions{ContinueOnError: true},
+			wantResults: []string{"stop", "other"},
+			wantStarted: []string{"stop", "other"},
+			wantMax:     1,
+			wantErr:     "received nonzero exit code with output: stop\nnot run because a command they depend on failed: \"start\", \"check\"",
+		},
+		{
+			name:     "InvalidDependencies",
+			commands: []*gpb.Command{namedCommand("a", "succeed", "missing")},
+			wantErr:  `invalid request: command "a" depends on unknown command "missing"`,
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			r := &executionRecorder{}
+			ga := &GuestActions{options: Options{Handlers: r.handlers()}}
+			gar := &gpb.GuestActionRequest{Commands: tc.commands, ExecutionOptions: tc.opts}
+			results, err := ga.processCommands(context.Background(), gar, nil)
+
+			gotErr := ""
+			if err != nil {
+				gotErr = err.Error()
+			}
+			if gotErr != tc.wantErr {
+				t.Errorf("processCommands() returned error: %q, want: %q", gotErr, tc.wantErr)
+			}
+			var got []string
+			for _, result := range results {
+				got = append(got, result.GetStdout())
+			}
+			if diff := cmp.Diff(tc.wantResults, got); diff != "" {
+				t.Errorf("processCommands() returned results in unexpected order (-want +got):\n%s", diff)
+			}
+			if tc.wantStarted != nil {
+				if diff := cmp.Diff(tc.wantStarted, r.started); diff != "" {
+					t.Errorf("processCommands() started unexpected commands (-want +got):\n%s", diff)
+				}
+			}
+			if tc.wantLast != "" && r.started[len(r.started)-1] != tc.wantLast {
+				t.Errorf("processCommands() started %v, want %s to start last", r.started, tc.wantLast)
+			}
+			if r.max != tc.wantMax {
+				t.Errorf("processCommands() ran %d commands at once, want %d", r.max, tc.wantMax)
+			}
+		})
+	}
+}
@@ -0,0 +1,63 @@
+/*
+Copyright 2025 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package gcsoutput uploads the output of guest action commands which is too large for a response
+// to a GCS bucket.
+package gcsoutput
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"path"
+
+	gcs "cloud.google.com/go/storage"
+	"github.com/GoogleCloudPlatform/workloadagentplatform/sharedlibraries/guestactions"
+	"github.com/GoogleCloudPlatform/workloadagentplatform/sharedlibraries/storage"
+)
+
+// Options holds the bucket and upload settings of the uploader.
+type Options struct {
+	// Call storage.ConnectToBucket() to generate a bucket handle.
+	BucketHandle *gcs.BucketHandle
+	// The name of the bucket must match the handle.
+	BucketName string
+	// Prefix is the folder in the bucket the outputs are uploaded to, such as "guestactions/".
+	Prefix string
+	// MaxRetries sets the maximum amount of retries of an upload.
+	MaxRetries int64
+}
+
+// NewUploader returns a guestactions.OutputUploader which uploads outputs with storage.ReadWriter.
+func NewUploader(opts Options) guestactions.OutputUploader {
+	return func(ctx context.Context, name string, data []byte) (string, error) {
+		objectName := path.Join(opts.Prefix, name)
+		rw := storage.ReadWriter{
+			Reader:       bytes.NewReader(data),
+			Copier:       io.Copy,
+			BucketHandle: opts.BucketHandle,
+			BucketName:   opts.BucketName,
+			ObjectName:   objectName,
+			TotalBytes:   int64(len(data)),
+			MaxRetries:   opts.MaxRetries,
+		}
+		if _, err := rw.Upload(ctx); err != nil {
+			return "", fmt.Errorf("uploading output to gs://%s/%s: %w", opts.BucketName, objectName, err)
+		}
+		return fmt.Sprintf("gs://%s/%s", opts.BucketName, objectName), nil
+	}
+}
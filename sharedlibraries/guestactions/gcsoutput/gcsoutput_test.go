@@ -0,0 +1,55 @@
+/*
+Copyright 2025 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package gcsoutput
+
+import (
+	"context"
+	"testing"
+
+	"github.com/fsouza/fake-gcs-server/fakestorage"
+)
+
+const bucketName = "test-bucket"
+
+func TestNewUploader(t *testing.T) {
+	server := fakestorage.NewServer([]fakestorage.Object{})
+	defer server.Stop()
+	server.CreateBucketWithOpts(fakestorage.CreateBucketOpts{Name: bucketName})
+
+	upload := NewUploader(Options{BucketHandle: server.Client().Bucket(bucketName), BucketName: bucketName, Prefix: "guestactions"})
+	got, err := upload(context.Background(), "op1/0/stdout", []byte("full output"))
+	if err != nil {
+		t.Fatalf("upload() returned unexpected error: %v", err)
+	}
+	if want := "gs://test-bucket/guestactions/op1/0/stdout"; got != want {
+		t.Errorf("upload() = %q, want: %q", got, want)
+	}
+	object, err := server.GetObject(bucketName, "guestactions/op1/0/stdout")
+	if err != nil {
+		t.Fatalf("GetObject() failed: %v", err)
+	}
+	if string(object.Content) != "full output" {
+		t.Errorf("Uploaded object content = %q, want: %q", object.Content, "full output")
+	}
+}
+
+func TestNewUploaderNoBucket(t *testing.T) {
+	upload := NewUploader(Options{BucketName: bucketName})
+	if _, err := upload(context.Background(), "op1/0/stdout", []byte("full output")); err == nil {
+		t.Errorf("upload() without a bucket handle returned nil error, want error")
+	}
+}
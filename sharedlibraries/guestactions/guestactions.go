@@ -18,20 +18,28 @@ limitations under the License.
 // It acts as a dispatcher for commands sent by GCP services.
 // The package supports both synchronous and asynchronous command execution.
 // For asynchronous commands (LROs), it manages the lifecycle by sending
-// intermediate "running" status updates followed by a final "done" status.
+// intermediate "running" status updates, including any progress the handler reports with
+// ReportProgress, followed by a final "done" status.
 // For synchronous commands, it executes the action and sends a final "done" status.
+// Other ACS action services whose protos mirror the guest action protos, such as GCBDR actions,
+// are dispatched the same way by setting Options.Protocol.
 package guestactions
 
 import (
 	"context"
 	"errors"
 	"fmt"
+	"path/filepath"
+	"slices"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/GoogleCloudPlatform/agentcommunication_client"
+	"google.golang.org/api/option"
 	"google.golang.org/protobuf/encoding/prototext"
+	"google.golang.org/protobuf/proto"
 	"github.com/GoogleCloudPlatform/workloadagentplatform/sharedlibraries/commandlineexecutor"
 	"github.com/GoogleCloudPlatform/workloadagentplatform/sharedlibraries/communication"
 	"github.com/GoogleCloudPlatform/workloadagentplatform/sharedlibraries/gce/metadataserver"
@@ -50,63 +58,256 @@ const (
 	statusSucceeded = "succeeded"
 	statusFailed    = "failed"
 	statusRunning   = "running"
+	statusCancelled = "cancelled"
 
 	lroStateRunning = "running"
 	lroStateDone    = "done"
 
 	defaultLockTimeout = 24 * time.Hour
+
+	// defaultShellCommandTimeoutSeconds matches the default timeout of the command line executor.
+	defaultShellCommandTimeoutSeconds = 60
+	// defaultMaxStdinBytes is the default maximum size of the stdin of a shell command.
+	defaultMaxStdinBytes = 1 << 20
+	// shellCommandPool is the pool of the Options.CommandLimiter that shell commands run in.
+	shellCommandPool = "guestactions"
 )
 
+// errOperationCancelled is the cause of the context of an operation cancelled by a
+// CancelOperationRequest.
+var errOperationCancelled = errors.New("operation cancelled")
+
+// LockMode is how an operation holds the lock on the resource of a command.
+type LockMode int
+
+const (
+	// LockExclusive prevents any other operation from locking the resource. It is the default.
+	LockExclusive LockMode = iota
+	// LockShared lets other operations lock the resource in shared mode at the same time, such as
+	// read-only status commands. It prevents exclusive locks on the resource.
+	LockShared
+)
+
+// lockPollInterval is how often waiting requests check for locks which have expired.
+const lockPollInterval = time.Second
+
 // resourceKey represents a lockable resource identifier.
 type resourceKey string
 
 // lockExpiry represents the expiration time of a lock.
 type lockExpiry time.Time
 
-// locker manages time-based locks for named resources.
+// lockRequest is a lock to acquire on a resource.
+type lockRequest struct {
+	timeout time.Duration
+	mode    LockMode
+}
+
+// lockState is a lock held on a resource, by one exclusive or any number of shared holders.
+type lockState struct {
+	mode    LockMode
+	holders int
+	// expiry is the latest expiry of the holders of the lock.
+	expiry lockExpiry
+}
+
+// heldLock is a lock held by an operation, as recorded in the operation journal.
+type heldLock struct {
+	Expiry time.Time `json:"expiry"`
+	Shared bool      `json:"shared,omitempty"`
+}
+
+// lockWaiter is a request waiting in the locker queue for its locks.
+type lockWaiter struct {
+	requests map[string]lockRequest
+	ready    chan struct{}
+}
+
+// locker manages time-based shared and exclusive locks for named resources.
+// Requests which wait for their locks are granted them in the order they started waiting.
 type locker struct {
-	mu    sync.Mutex
-	locks map[resourceKey]lockExpiry
+	mu      sync.Mutex
+	locks   map[resourceKey]*lockState
+	waiters []*lockWaiter
 }
 
 // newLocker creates and initializes a locker.
 func newLocker() *locker {
 	return &locker{
-		locks: make(map[resourceKey]lockExpiry),
+		locks: make(map[resourceKey]*lockState),
 	}
 }
 
-// acquire attempts to acquire locks for the given resource keys with their specified timeouts.
+// acquire attempts to acquire exclusive locks for the given resource keys with their specified timeouts.
 // It returns the key that is already locked and false if a lock cannot be acquired,
 // or an empty string and true if all locks are successfully acquired.
 func (l *locker) acquire(ctx context.Context, locksToAcquire map[string]time.Duration) (string, bool) {
-	if len(locksToAcquire) == 0 {
+	requests := make(map[string]lockRequest, len(locksToAcquire))
+	for k, timeout := range locksToAcquire {
+		requests[k] = lockRequest{timeout: timeout, mode: LockExclusive}
+	}
+	return l.acquireLocks(ctx, requests)
+}
+
+// acquireLocks attempts to acquire the requested locks without waiting, as acquire does.
+// A lock requested by a waiting request is busy.
+func (l *locker) acquireLocks(ctx context.Context, requests map[string]lockRequest) (string, bool) {
+	if len(requests) == 0 {
 		return "", true
 	}
 	l.mu.Lock()
 	defer l.mu.Unlock()
+	return l.tryAcquire(ctx, requests, len(l.waiters))
+}
+
+/*
+wait acquires the requested locks, waiting in the queue until they are available for at most
+timeout. It returns the key that is still locked and false if the locks are not acquired before
+the timeout or before ctx is done.
+*/
+func (l *locker) wait(ctx context.Context, requests map[string]lockRequest, timeout time.Duration) (string, bool) {
+	if len(requests) == 0 {
+		return "", true
+	}
+	l.mu.Lock()
+	if _, ok := l.tryAcquire(ctx, requests, len(l.waiters)); ok {
+		l.mu.Unlock()
+		return "", true
+	}
+	w := &lockWaiter{requests: requests, ready: make(chan struct{})}
+	l.waiters = append(l.waiters, w)
+	l.mu.Unlock()
 
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	ticker := time.NewTicker(lockPollInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-w.ready:
+			return "", true
+		case <-ticker.C:
+			// Locks may have expired without being released.
+			l.mu.Lock()
+			l.dispatch(ctx)
+			l.mu.Unlock()
+			continue
+		case <-timer.C:
+		case <-ctx.Done():
+		}
+		l.mu.Lock()
+		defer l.mu.Unlock()
+		select {
+		case <-w.ready:
+			return "", true
+		default:
+		}
+		l.waiters = slices.DeleteFunc(l.waiters, func(q *lockWaiter) bool { return q == w })
+		busyKey, _ := l.busyKey(ctx, requests, 0)
+		// Requests queued behind this one may now be able to run.
+		l.dispatch(ctx)
+		return busyKey, false
+	}
+}
+
+// tryAcquire acquires the requested locks if none of them is held in a conflicting mode or
+// requested by one of the first ahead waiters. l.mu must be held.
+func (l *locker) tryAcquire(ctx context.Context, requests map[string]lockRequest, ahead int) (string, bool) {
+	if busyKey, busy := l.busyKey(ctx, requests, ahead); busy {
+		return busyKey, false
+	}
 	now := time.Now()
-	// Check if any resources are currently locked.
-	for k := range locksToAcquire {
+	for k, r := range requests {
 		key := resourceKey(k)
-		if expiry, exists := l.locks[key]; exists {
-			if now.Before(time.Time(expiry)) {
-				return k, false // Resource is locked and lock is not expired.
+		expiry := now.Add(r.timeout)
+		if st, ok := l.locks[key]; ok {
+			// A shared lock held by other operations.
+			st.holders++
+			if expiry.After(time.Time(st.expiry)) {
+				st.expiry = lockExpiry(expiry)
 			}
-			log.CtxLogger(ctx).Warnw("Lock for resource has expired, re-acquiring lock", "resource", k)
+			continue
 		}
+		l.locks[key] = &lockState{mode: r.mode, holders: 1, expiry: lockExpiry(expiry)}
 	}
+	return "", true
+}
 
-	// Acquire locks for all resources.
-	for k, timeout := range locksToAcquire {
+// busyKey returns a requested key which is locked in a conflicting mode or requested by one of
+// the first ahead waiters, and true if there is one. Expired locks are removed. l.mu must be held.
+func (l *locker) busyKey(ctx context.Context, requests map[string]lockRequest, ahead int) (string, bool) {
+	now := time.Now()
+	// Check if any resources are currently locked.
+	for k, r := range requests {
 		key := resourceKey(k)
-		l.locks[key] = lockExpiry(now.Add(timeout))
+		if st, exists := l.locks[key]; exists {
+			if !now.Before(time.Time(st.expiry)) {
+				log.CtxLogger(ctx).Warnw("Lock for resource has expired, re-acquiring lock", "resource", k)
+				delete(l.locks, key)
+			} else if st.mode == LockExclusive || r.mode == LockExclusive {
+				return k, true // Resource is locked and lock is not expired.
+			}
+		}
+		for _, w := range l.waiters[:ahead] {
+			if q, ok := w.requests[k]; ok && (q.mode == LockExclusive || r.mode == LockExclusive) {
+				return k, true // Resource is requested by an earlier waiting request.
+			}
+		}
 	}
-	return "", true
+	return "", false
 }
 
-// release removes the locks for the given resource keys.
+// dispatch grants the locks of the waiting requests which can now acquire them, in queue order.
+// l.mu must be held.
+func (l *locker) dispatch(ctx context.Context) {
+	for i := 0; i < len(l.waiters); {
+		w := l.waiters[i]
+		if _, ok := l.tryAcquire(ctx, w.requests, i); !ok {
+			i++
+			continue
+		}
+		l.waiters = append(l.waiters[:i], l.waiters[i+1:]...)
+		close(w.ready)
+	}
+}
+
+// held returns the locks held for the given resource keys.
+func (l *locker) held(keys []string) map[string]heldLock {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	held := make(map[string]heldLock, len(keys))
+	for _, k := range keys {
+		if st, ok := l.locks[resourceKey(k)]; ok {
+			held[k] = heldLock{Expiry: time.Time(st.expiry), Shared: st.mode == LockShared}
+		}
+	}
+	return held
+}
+
+// restore acquires the given locks until their expiry, as they were held before the agent
+// restarted. It returns the restored keys.
+func (l *locker) restore(locks map[string]heldLock) []string {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	keys := make([]string, 0, len(locks))
+	for k, h := range locks {
+		mode := LockExclusive
+		if h.Shared {
+			mode = LockShared
+		}
+		if st, ok := l.locks[resourceKey(k)]; ok && st.mode == LockShared && mode == LockShared {
+			st.holders++
+		} else {
+			l.locks[resourceKey(k)] = &lockState{mode: mode, holders: 1, expiry: lockExpiry(h.Expiry)}
+		}
+		keys = append(keys, k)
+	}
+	return keys
+}
+
+// release releases the locks for the given resource keys held by one operation.
 func (l *locker) release(keysToRelease []string) {
 	if len(keysToRelease) == 0 {
 		return
@@ -115,14 +316,33 @@ func (l *locker) release(keysToRelease []string) {
 	defer l.mu.Unlock()
 
 	for _, k := range keysToRelease {
-		delete(l.locks, resourceKey(k))
+		key := resourceKey(k)
+		if st, ok := l.locks[key]; ok {
+			st.holders--
+			if st.holders <= 0 {
+				delete(l.locks, key)
+			}
+		}
 	}
+	l.dispatch(context.Background())
 }
 
 // GuestActions is a struct that holds the state for guest actions.
 type GuestActions struct {
 	options Options
 	locker  *locker
+	// policy restricts shell commands if Options.ShellCommandPolicyFile is set.
+	policy *shellPolicy
+	// journal records the operations being processed if Options.JournalFile is set.
+	journal *operationJournal
+	// operationCache detects operations which are delivered more than once.
+	operationCache *operationCache
+	// supervisor keeps the ACS channel connected once Start is called.
+	supervisor atomic.Pointer[communication.Supervisor]
+
+	opsMu sync.Mutex
+	// operations holds the cancel functions of the in-flight operations by operation ID.
+	operations map[string]context.CancelCauseFunc
 }
 
 // GuestActionHandler is a function that handles a guest action command.
@@ -135,12 +355,69 @@ type Options struct {
 	CloudProperties *metadataserver.CloudProperties
 	Handlers        map[string]GuestActionHandler
 	LROHandlers     map[string]GuestActionHandler
+	// parameterSchemas are the schemas of the parameters of the agent commands registered by
+	// AddHandler and AddLROHandler, by the name of their handler.
+	parameterSchemas map[string]ParameterSchema
 	// CommandConcurrencyKey extracts a locking key from a command to prevent concurrent operations on the same resource (e.g., a specific database instance).
 	// Note: Returning an empty string as `key` with `ok=true` will acquire a lock on the empty string.
 	// This can lead to unintended contention if multiple distinct operations use an empty key.
 	// To avoid locking for a command, return `ok=false`.
 	// If timeout is 0 or negative, defaultLockTimeout is used.
 	CommandConcurrencyKey func(context.Context, *gpb.Command, *metadataserver.CloudProperties) (string, time.Duration, bool)
+	// CommandLockMode is optional and returns how a command holds the lock of its
+	// CommandConcurrencyKey, which lets read-only commands share the lock. Defaults to LockExclusive.
+	// If commands of a request lock the same key in different modes, the lock is exclusive.
+	CommandLockMode func(context.Context, *gpb.Command, *metadataserver.CloudProperties) LockMode
+	// LockWaitTimeout is optional. If it is set, a request for a busy resource waits in a queue for
+	// up to LockWaitTimeout, after sending a "running" status, instead of failing immediately.
+	LockWaitTimeout time.Duration
+	// ShellCommandPolicyFile is optional and is the path of a JSON ShellCommandPolicy restricting the
+	// shell commands that can be run. Changes to the file are applied to the next shell command.
+	ShellCommandPolicyFile string
+	// ShellCommandOptions restricts the user, environment, stdin and working directory of shell commands.
+	ShellCommandOptions ShellCommandOptions
+	// CommandLimiter is optional and caps the shell commands running at once. They run in its
+	// "guestactions" pool with PriorityInteractive, ahead of the background commands of the agent
+	// sharing the Limiter.
+	CommandLimiter *commandlineexecutor.Limiter
+	// ProgressInterval is the minimum time between the progress messages an LRO handler publishes
+	// with ReportProgress. Defaults to 30 seconds.
+	ProgressInterval time.Duration
+	// JournalFile is optional and is the path of a file recording the operations being processed and
+	// the locks they hold. Operations interrupted by an agent restart are handled when Start
+	// replays the journal.
+	JournalFile string
+	// ResumeOperation is optional and reports whether an operation interrupted by an agent restart
+	// is safe to run again. Other interrupted operations are reported as failed.
+	ResumeOperation func(*gpb.GuestActionRequest) bool
+	// OperationCache configures the detection of operations which ACS delivers more than once.
+	OperationCache OperationCacheOptions
+	// DebugSocket is optional and is the path of a Unix socket serving a local debug endpoint, which
+	// runs guest actions posted to it without ACS and streams their status messages back. It is
+	// meant for integration tests and troubleshooting on the machine and should not be set otherwise.
+	DebugSocket string
+	// Protocol is optional and is the request and response messages of the service the operations
+	// are received from, if it is not guest actions.
+	Protocol *Protocol
+	// Output limits the size of command output and of the responses sent to ACS.
+	Output OutputOptions
+	// ClientOptions are optional and configure the ACS client, such as the insecure local
+	// credentials of a fakeacs server in tests.
+	ClientOptions []option.ClientOption
+}
+
+// ShellCommandOptions is the agent configuration which the optional fields of a ShellCommand are
+// validated against. A shell command setting a field which is not allowed is rejected.
+type ShellCommandOptions struct {
+	// AllowedUsers are the users a shell command may run as.
+	AllowedUsers []string
+	// AllowedEnv are the names of the environment variables a shell command may set.
+	AllowedEnv []string
+	// AllowedWorkingDirectories are the directories a shell command may run in, including their
+	// subdirectories. Paths are compared without resolving symbolic links.
+	AllowedWorkingDirectories []string
+	// MaxStdinBytes is the maximum size of the stdin of a shell command. Defaults to 1 MiB.
+	MaxStdinBytes int
 }
 
 func anyResponse(ctx context.Context, gar *gpb.GuestActionResponse) *anypb.Any {
@@ -171,14 +448,79 @@ func guestActionResponse(ctx context.Context, results []*gpb.CommandResult, erro
 	}
 }
 
-func handleShellCommand(ctx context.Context, command *gpb.Command, execute commandlineexecutor.Execute) *gpb.CommandResult {
+// shellCommandTimeout returns the timeout in seconds that the command line executor applies to sc.
+func shellCommandTimeout(sc *gpb.ShellCommand) int32 {
+	if sc.GetTimeoutSeconds() > 0 {
+		return sc.GetTimeoutSeconds()
+	}
+	return defaultShellCommandTimeoutSeconds
+}
+
+// validateShellCommand returns an error if sc sets an optional field which opts does not allow.
+func validateShellCommand(sc *gpb.ShellCommand, opts ShellCommandOptions) error {
+	if user := sc.GetUser(); user != "" && !slices.Contains(opts.AllowedUsers, user) {
+		return fmt.Errorf("running as user %q is not allowed", user)
+	}
+	for name := range sc.GetEnv() {
+		if name == "" || strings.Contains(name, "=") {
+			return fmt.Errorf("invalid environment variable name %q", name)
+		}
+		if !slices.Contains(opts.AllowedEnv, name) {
+			return fmt.Errorf("setting environment variable %q is not allowed", name)
+		}
+	}
+	maxStdin := opts.MaxStdinBytes
+	if maxStdin <= 0 {
+		maxStdin = defaultMaxStdinBytes
+	}
+	if len(sc.GetStdin()) > maxStdin {
+		return fmt.Errorf("stdin of %d bytes exceeds the maximum of %d bytes", len(sc.GetStdin()), maxStdin)
+	}
+	if dir := sc.GetWorkingDirectory(); dir != "" {
+		if !filepath.IsAbs(dir) {
+			return fmt.Errorf("working directory %q is not an absolute path", dir)
+		}
+		allowed := slices.ContainsFunc(opts.AllowedWorkingDirectories, func(a string) bool {
+			rel, err := filepath.Rel(filepath.Clean(a), filepath.Clean(dir))
+			return err == nil && rel != ".." && !strings.HasPrefix(rel, "../")
+		})
+		if !allowed {
+			return fmt.Errorf("working directory %q is not allowed", dir)
+		}
+	}
+	return nil
+}
+
+// shellCommandEnv returns the environment variables of sc as sorted "NAME=value" entries.
+func shellCommandEnv(sc *gpb.ShellCommand) []string {
+	var env []string
+	for name, value := range sc.GetEnv() {
+		env = append(env, name+"="+value)
+	}
+	slices.Sort(env)
+	return env
+}
+
+func handleShellCommand(ctx context.Context, command *gpb.Command, opts ShellCommandOptions, execute commandlineexecutor.Execute) *gpb.CommandResult {
 	sc := command.GetShellCommand()
+	if err := validateShellCommand(sc, opts); err != nil {
+		log.CtxLogger(ctx).Warnw("Shell command rejected by agent configuration", "command", prototext.Format(command), "reason", err)
+		return &gpb.CommandResult{
+			Command:  command,
+			Stderr:   fmt.Sprintf("Command rejected by agent configuration: %v", err),
+			ExitCode: PolicyRejectedExitCode,
+		}
+	}
 	result := execute(
 		ctx,
 		commandlineexecutor.Params{
 			Executable:  sc.GetCommand(),
 			ArgsToSplit: sc.GetArgs(),
 			Timeout:     int(sc.GetTimeoutSeconds()),
+			User:        sc.GetUser(),
+			Env:         shellCommandEnv(sc),
+			Stdin:       sc.GetStdin(),
+			WorkingDir:  sc.GetWorkingDirectory(),
 		},
 	)
 	log.CtxLogger(ctx).Debugw("Received result for shell command",
@@ -188,10 +530,18 @@ func handleShellCommand(ctx context.Context, command *gpb.Command, execute comma
 	if exitCode == 0 && (result.Error != nil || result.StdErr != "") {
 		exitCode = int32(1)
 	}
+	stdErr := result.StdErr
+	if result.TimedOut {
+		// Report the timeout explicitly, the exit code of a killed command is not meaningful to users.
+		stdErr = fmt.Sprintf("Command timed out after %d seconds.\n%s", shellCommandTimeout(sc), result.StdErr)
+		if exitCode <= 0 {
+			exitCode = int32(1)
+		}
+	}
 	return &gpb.CommandResult{
 		Command:  command,
 		Stdout:   result.StdOut,
-		Stderr:   result.StdErr,
+		Stderr:   stdErr,
 		ExitCode: exitCode,
 	}
 }
@@ -211,8 +561,17 @@ func (g *GuestActions) handleAgentCommand(ctx context.Context, command *gpb.Comm
 			}
 		}
 	}
-
-	result := handler(ctx, command, cloudProperties)
+	validated, err := g.validateParameters(agentCommand, command)
+	if err != nil {
+		log.CtxLogger(ctx).Warnw("Agent command has invalid parameters", "command", prototext.Format(command), "err", err)
+		return &gpb.CommandResult{
+			Command:  command,
+			Stdout:   err.Error(),
+			Stderr:   err.Error(),
+			ExitCode: int32(1),
+		}
+	}
+	result := handler(ctx, validated, cloudProperties)
 	log.CtxLogger(ctx).Debugw("Received result for agent command", "result", prototext.Format(result))
 	return result
 }
@@ -226,87 +585,254 @@ func errorResult(errMsg string) *gpb.CommandResult {
 	}
 }
 
-// processCommands executes the commands and returns the results.
-// It returns an error if any command fails or has a non-zero exit code.
-func (g *GuestActions) processCommands(ctx context.Context, gar *gpb.GuestActionRequest, cloudProperties *metadataserver.CloudProperties) ([]*gpb.CommandResult, error) {
-	var results []*gpb.CommandResult
-	for _, command := range gar.GetCommands() {
-		log.CtxLogger(ctx).Debugw("Processing command", "command", prototext.Format(command))
-		pr := command.ProtoReflect()
-		fd := pr.WhichOneof(pr.Descriptor().Oneofs().ByName("command_type"))
-		result := &gpb.CommandResult{}
-		switch {
-		case fd == nil:
-			errMsg := fmt.Sprintf("received unknown command: %s", prototext.Format(command))
-			results = append(results, errorResult(errMsg))
-			return results, errors.New(errMsg)
-		case fd.Name() == shellCommand:
-			result = handleShellCommand(ctx, command, commandlineexecutor.ExecuteCommand)
-			results = append(results, result)
-		case fd.Name() == agentCommand:
-			result = g.handleAgentCommand(ctx, command, cloudProperties)
-			results = append(results, result)
-		default:
-			errMsg := fmt.Sprintf("received unknown command: %s", prototext.Format(command))
-			results = append(results, errorResult(errMsg))
-			return results, errors.New(errMsg)
-		}
-		// Exit early if we get an error
-		if result.GetExitCode() != int32(0) {
-			errMsg := fmt.Sprintf("received nonzero exit code with output: %s", result.GetStdout())
-			return results, errors.New(errMsg)
+// runCommand executes a command and returns its result, and an error if it fails or has a
+// non-zero exit code.
+func (g *GuestActions) runCommand(ctx context.Context, command *gpb.Command, cloudProperties *metadataserver.CloudProperties) (*gpb.CommandResult, error) {
+	log.CtxLogger(ctx).Debugw("Processing command", "command", prototext.Format(command))
+	pr := command.ProtoReflect()
+	fd := pr.WhichOneof(pr.Descriptor().Oneofs().ByName("command_type"))
+	var result *gpb.CommandResult
+	switch {
+	case fd == nil:
+		errMsg := fmt.Sprintf("received unknown command: %s", prototext.Format(command))
+		return errorResult(errMsg), errors.New(errMsg)
+	case fd.Name() == shellCommand:
+		result = handleShellCommand(ctx, command, g.options.ShellCommandOptions, g.shellExecutor())
+	case fd.Name() == agentCommand:
+		result = g.handleAgentCommand(ctx, command, cloudProperties)
+	default:
+		errMsg := fmt.Sprintf("received unknown command: %s", prototext.Format(command))
+		return errorResult(errMsg), errors.New(errMsg)
+	}
+	if result.GetExitCode() != int32(0) {
+		errMsg := fmt.Sprintf("received nonzero exit code with output: %s", result.GetStdout())
+		return result, errors.New(errMsg)
+	}
+	return result, nil
+}
+
+/*
+shellExecutor returns the executor for shell commands, which enforces the shell command policy and
+waits for the Options.CommandLimiter. Commands rejected by the policy do not wait.
+*/
+func (g *GuestActions) shellExecutor() commandlineexecutor.Execute {
+	execute := commandlineexecutor.ExecuteCommand
+	if l := g.options.CommandLimiter; l != nil {
+		execute = l.Execute(execute, shellCommandPool, commandlineexecutor.PriorityInteractive)
+	}
+	if g.policy == nil {
+		return execute
+	}
+	return g.policy.execute(execute)
+}
+
+// startOperation registers the operation so that it can be cancelled by a CancelOperationRequest.
+// It returns the context to run the operation with, and a function to call once it is done.
+func (g *GuestActions) startOperation(ctx context.Context, operationID string) (context.Context, func()) {
+	opCtx, cancel := context.WithCancelCause(ctx)
+	g.opsMu.Lock()
+	defer g.opsMu.Unlock()
+	if g.operations == nil {
+		g.operations = make(map[string]context.CancelCauseFunc)
+	}
+	g.operations[operationID] = cancel
+	return opCtx, func() {
+		g.opsMu.Lock()
+		delete(g.operations, operationID)
+		g.opsMu.Unlock()
+		cancel(nil)
+	}
+}
+
+// cancelOperation cancels the context of an in-flight operation.
+// It returns false if there is no in-flight operation with the operation ID.
+func (g *GuestActions) cancelOperation(operationID string) bool {
+	g.opsMu.Lock()
+	defer g.opsMu.Unlock()
+	cancel, ok := g.operations[operationID]
+	if ok {
+		cancel(errOperationCancelled)
+	}
+	return ok
+}
+
+// handleCancelRequest cancels the operation named in a CancelOperationRequest and sends the
+// outcome as the status of the cancel message.
+func (g *GuestActions) handleCancelRequest(ctx context.Context, operationID string, body *anypb.Any, conn *client.Connection) error {
+	req := &gpb.CancelOperationRequest{}
+	if err := body.UnmarshalTo(req); err != nil {
+		log.CtxLogger(ctx).Warnw("Failed to parse cancel request", "operation_id", operationID, "channel", g.options.Channel, "err", err)
+		return fmt.Errorf("failed to unmarshal message: %v", err)
+	}
+	statusMsg := statusSucceeded
+	errMsg := ""
+	if g.cancelOperation(req.GetOperationId()) {
+		log.CtxLogger(ctx).Infow("Cancelled operation", "operation_id", req.GetOperationId(), "cancel_operation_id", operationID, "channel", g.options.Channel)
+	} else {
+		log.CtxLogger(ctx).Warnw("No in-flight operation to cancel", "operation_id", req.GetOperationId(), "cancel_operation_id", operationID, "channel", g.options.Channel)
+		statusMsg = statusFailed
+		errMsg = fmt.Sprintf("No in-flight operation with operation_id: %s", req.GetOperationId())
+	}
+	if err := g.sendStatusMessage(ctx, operationID, anyResponse(ctx, guestActionResponse(ctx, nil, errMsg)), statusMsg, lroStateDone, conn); err != nil {
+		return fmt.Errorf("failed to send status message: %v", err)
+	}
+	return nil
+}
+
+// cacheFor returns the operation cache which the operation in ctx is recorded in, which is nil for
+// operations started by the debug endpoint.
+func (g *GuestActions) cacheFor(ctx context.Context) *operationCache {
+	if isDebugOperation(ctx) {
+		return nil
+	}
+	return g.operationCache
+}
+
+// journalFor returns the journal which the operation in ctx is recorded in, which is nil for
+// operations started by the debug endpoint.
+func (g *GuestActions) journalFor(ctx context.Context) *operationJournal {
+	if isDebugOperation(ctx) {
+		return nil
+	}
+	return g.journal
+}
+
+/*
+sendStatusMessage sends a status message of an operation, including its progress, to ACS with
+communication.SendStatusMessage, or to the status sink in ctx if the operation was started by the
+debug endpoint. The GuestActionResponse in body is converted to the response message of
+Options.Protocol, and a final status larger than Options.Output.MaxResponseBytes is sent in chunks.
+*/
+func (g *GuestActions) sendStatusMessage(ctx context.Context, operationID string, body *anypb.Any, status string, lroState string, conn *client.Connection) error {
+	body, err := g.options.Protocol.convertResponse(body)
+	if err != nil {
+		return fmt.Errorf("converting status message to the protocol response: %v", err)
+	}
+	conn = g.connection(conn)
+	if maxBytes := g.options.Output.MaxResponseBytes; lroState == lroStateDone && maxBytes > 0 && proto.Size(body) > maxBytes {
+		return g.sendChunks(ctx, operationID, body, status, conn)
+	}
+	if sink, ok := ctx.Value(statusSinkKey{}).(statusSink); ok {
+		sink(&acpb.MessageBody{
+			Labels: map[string]string{"operation_id": operationID, "state": status, "lro_state": lroState},
+			Body:   body,
+		})
+		return nil
+	}
+	return communication.SendStatusMessage(ctx, operationID, body, status, lroState, conn)
+}
+
+/*
+connection returns the connection status messages are sent on. The connection an operation was
+received on is closed when it fails, so the current connection of the supervisor is used, and
+conn only while there is none.
+*/
+func (g *GuestActions) connection(conn *client.Connection) *client.Connection {
+	if s := g.supervisor.Load(); s != nil {
+		if current := s.Connection(); current != nil {
+			return current
 		}
 	}
-	return results, nil
+	return conn
+}
+
+// deliverMessage sends msg to ACS, or to the status sink in ctx if the operation was started by
+// the debug endpoint.
+func deliverMessage(ctx context.Context, msg *acpb.MessageBody, conn *client.Connection) error {
+	if sink, ok := ctx.Value(statusSinkKey{}).(statusSink); ok {
+		sink(msg)
+		return nil
+	}
+	log.CtxLogger(ctx).Debugw("Sending status message via ACS", "messageToSend", msg)
+	if err := communication.SendMessage(conn, msg); err != nil {
+		return fmt.Errorf("error sending status message via ACS: %v", err)
+	}
+	return nil
 }
 
 func (g *GuestActions) executeAndSendDone(ctx context.Context, operationID string, gar *gpb.GuestActionRequest, conn *client.Connection, cloudProperties *metadataserver.CloudProperties, keysToRelease []string) {
 	defer g.locker.release(keysToRelease)
 	results, err := g.processCommands(ctx, gar, cloudProperties)
+	g.limitOutput(ctx, operationID, results)
 	statusMsg := statusSucceeded
 	errMsg := ""
-	if err != nil {
+	if errors.Is(context.Cause(ctx), errOperationCancelled) {
+		log.CtxLogger(ctx).Infow("Operation was cancelled", "operation_id", operationID, "channel", g.options.Channel)
+		statusMsg = statusCancelled
+		errMsg = "Operation cancelled"
+	} else if err != nil {
 		log.CtxLogger(ctx).Warnw("Failed to process commands", "operation_id", operationID, "channel", g.options.Channel, "err", err)
 		statusMsg = statusFailed
 		errMsg = err.Error()
 	}
-	// Send final status
-	err = communication.SendStatusMessage(ctx, operationID, anyResponse(ctx, guestActionResponse(ctx, results, errMsg)), statusMsg, lroStateDone, conn)
+	// Send final status, even if the operation was cancelled, after any progress of the operation.
+	progressReporterFrom(ctx).stop()
+	ctx = context.WithoutCancel(ctx)
+	err = g.sendFinalStatus(ctx, operationID, anyResponse(ctx, guestActionResponse(ctx, results, errMsg)), statusMsg, conn)
 	if err != nil {
 		log.CtxLogger(ctx).Warnw("SendStatusMessage failed", "operation_id", operationID, "channel", g.options.Channel, "err", err)
 	}
+	g.journalFor(ctx).done(ctx, operationID, statusMsg)
 }
 
 // acquireLocksForRequest acquires locks for the commands in the request.
 // It returns the keys of the acquired locks and the key of the busy resource if any.
 // It returns true if all locks are acquired successfully, false otherwise.
 func (g *GuestActions) acquireLocksForRequest(ctx context.Context, gaReq *gpb.GuestActionRequest, cloudProperties *metadataserver.CloudProperties) ([]string, string, bool) {
+	requests := g.lockRequests(ctx, gaReq, cloudProperties)
+	if busyKey, ok := g.locker.acquireLocks(ctx, requests); !ok {
+		return nil, busyKey, false
+	}
+	return lockKeys(requests), "", true
+}
+
+// waitForLocksForRequest acquires the locks of the request as acquireLocksForRequest does, waiting
+// for up to timeout if a resource is busy.
+func (g *GuestActions) waitForLocksForRequest(ctx context.Context, gaReq *gpb.GuestActionRequest, cloudProperties *metadataserver.CloudProperties, timeout time.Duration) ([]string, string, bool) {
+	requests := g.lockRequests(ctx, gaReq, cloudProperties)
+	if busyKey, ok := g.locker.wait(ctx, requests, timeout); !ok {
+		return nil, busyKey, false
+	}
+	return lockKeys(requests), "", true
+}
+
+// lockRequests returns the locks the commands of the request need.
+func (g *GuestActions) lockRequests(ctx context.Context, gaReq *gpb.GuestActionRequest, cloudProperties *metadataserver.CloudProperties) map[string]lockRequest {
+	requests := make(map[string]lockRequest)
 	if g.options.CommandConcurrencyKey == nil {
-		return []string{}, "", true
+		return requests
 	}
-	locksToAcquire := make(map[string]time.Duration)
 	for _, command := range gaReq.GetCommands() {
 		if key, timeout, ok := g.options.CommandConcurrencyKey(ctx, command, cloudProperties); ok {
 			if timeout <= 0 {
 				timeout = defaultLockTimeout
 			}
+			mode := LockExclusive
+			if g.options.CommandLockMode != nil {
+				mode = g.options.CommandLockMode(ctx, command, cloudProperties)
+			}
 			// If multiple commands in a single GuestActionRequest proto need to lock the same key,
-			// we use the longest timeout to make sure the lock is held for the entire operation.
-			if existing, found := locksToAcquire[key]; !found || timeout > existing {
-				locksToAcquire[key] = timeout
+			// we use the longest timeout to make sure the lock is held for the entire operation,
+			// and an exclusive lock if any of them needs one.
+			if existing, found := requests[key]; found {
+				timeout = max(timeout, existing.timeout)
+				if existing.mode == LockExclusive {
+					mode = LockExclusive
+				}
 			}
+			requests[key] = lockRequest{timeout: timeout, mode: mode}
 		}
 	}
+	return requests
+}
 
-	if busyKey, ok := g.locker.acquire(ctx, locksToAcquire); !ok {
-		return nil, busyKey, false
-	}
-
-	keysToRelease := make([]string, 0, len(locksToAcquire))
-	for k := range locksToAcquire {
-		keysToRelease = append(keysToRelease, k)
+// lockKeys returns the keys of the lock requests.
+func lockKeys(requests map[string]lockRequest) []string {
+	keys := make([]string, 0, len(requests))
+	for k := range requests {
+		keys = append(keys, k)
 	}
-	return keysToRelease, "", true
+	return keys
 }
 
 func (g *GuestActions) isLRORequest(gaReq *gpb.GuestActionRequest) bool {
@@ -325,6 +851,8 @@ func (g *GuestActions) isLRORequest(gaReq *gpb.GuestActionRequest) bool {
 // processes the command in a goroutine, and sends a final "done" status message upon completion.
 // Synchronous commands are processed in a separate goroutine to avoid blocking the listener loop,
 // but no initial "running" status is sent.
+// A message containing a CancelOperationRequest cancels the context of the in-flight operation it
+// names, which then reports a final "cancelled" status.
 func (g *GuestActions) connectionHandler(ctx context.Context, msg *acpb.MessageBody, conn *client.Connection, cloudProperties *metadataserver.CloudProperties) error {
 	if msg.GetLabels() == nil {
 		err := errors.New("received message with nil labels")
@@ -337,45 +865,132 @@ func (g *GuestActions) connectionHandler(ctx context.Context, msg *acpb.MessageB
 		log.CtxLogger(ctx).Warnw("Connection handler failed", "err", err)
 		return err
 	}
-	gaReq, err := parseRequest(ctx, msg.GetBody())
+	if msg.GetBody().MessageIs(&gpb.CancelOperationRequest{}) {
+		return g.handleCancelRequest(ctx, operationID, msg.GetBody(), conn)
+	}
+	gaReq, err := g.options.Protocol.parseRequest(ctx, msg.GetBody())
 	if err != nil {
 		log.CtxLogger(ctx).Warnw("Failed to parse request", "operation_id", operationID, "channel", g.options.Channel, "err", err)
 		return err
 	}
 	log.CtxLogger(ctx).Debugw("Received GuestActionRequest", "operation_id", operationID, "channel", g.options.Channel, "request_msg", prototext.Format(gaReq))
+	if op, duplicate := g.cacheFor(ctx).begin(operationID); duplicate {
+		return g.handleDuplicate(ctx, op, conn)
+	}
+	return g.handleRequest(ctx, operationID, gaReq, conn, cloudProperties)
+}
 
+// handleRequest acquires the locks of a new operation, or waits for them, and runs it.
+func (g *GuestActions) handleRequest(ctx context.Context, operationID string, gaReq *gpb.GuestActionRequest, conn *client.Connection, cloudProperties *metadataserver.CloudProperties) error {
 	keysToRelease, busyKey, ok := g.acquireLocksForRequest(ctx, gaReq, cloudProperties)
+	if !ok && g.options.LockWaitTimeout > 0 {
+		return g.queueOperation(ctx, operationID, gaReq, conn, cloudProperties, busyKey)
+	}
 	if !ok {
 		log.CtxLogger(ctx).Warnw("Failed to acquire lock, resource busy", "busy_resource", busyKey)
-		errMsg := ""
-		if busyKey == "" {
-			errMsg = "Operation aborted. A resource with an empty-string lock key is busy"
-		} else {
-			errMsg = fmt.Sprintf("Operation aborted. Resource busy: %s", busyKey)
-		}
-		err := communication.SendStatusMessage(ctx, operationID, anyResponse(ctx, guestActionResponse(ctx, nil, errMsg)), statusFailed, lroStateDone, conn)
+		err := g.sendFinalStatus(ctx, operationID, anyResponse(ctx, guestActionResponse(ctx, nil, busyMessage(busyKey))), statusFailed, conn)
 		if err != nil {
 			return fmt.Errorf("failed to send status message: %v", err)
 		}
+		g.journalFor(ctx).done(ctx, operationID, statusFailed)
 		return nil
 	}
+	g.journalFor(ctx).accepted(ctx, operationID, gaReq, g.locker.held(keysToRelease))
 
 	if g.isLRORequest(gaReq) {
 		// Send initial running status
-		err := communication.SendStatusMessage(ctx, operationID, anyResponse(ctx, guestActionResponse(ctx, nil, "")), statusRunning, lroStateRunning, conn)
+		err := g.sendStatusMessage(ctx, operationID, anyResponse(ctx, guestActionResponse(ctx, nil, "")), statusRunning, lroStateRunning, conn)
 		if err != nil {
 			log.CtxLogger(ctx).Warnw("SendStatusMessage failed", "operation_id", operationID, "channel", g.options.Channel, "err", err)
 			g.locker.release(keysToRelease)
+			g.journalFor(ctx).done(ctx, operationID, statusFailed)
+			// Nothing was executed, so the operation runs if it is delivered again.
+			g.cacheFor(ctx).forget(operationID)
 			return err
 		}
 	}
 
 	// Process commands in background to avoid blocking the listener loop.
-	go g.executeAndSendDone(ctx, operationID, gaReq, conn, cloudProperties, keysToRelease)
+	g.runOperation(ctx, operationID, gaReq, conn, cloudProperties, keysToRelease)
 	return nil
 }
 
-// Start starts listening to ACS/UAP and handling the related guest actions.
+// runOperation processes the commands of an accepted operation in a goroutine, which sends the
+// final status and releases the locks when it is done.
+func (g *GuestActions) runOperation(ctx context.Context, operationID string, gaReq *gpb.GuestActionRequest, conn *client.Connection, cloudProperties *metadataserver.CloudProperties, keysToRelease []string) {
+	opCtx, done := g.startOperation(ctx, operationID)
+	go func() {
+		defer done()
+		g.executeAndSendDone(g.progressContext(opCtx, operationID, gaReq, conn), operationID, gaReq, conn, cloudProperties, keysToRelease)
+	}()
+}
+
+// progressContext returns the context to process the commands of the request with, in which LRO
+// handlers can report their progress.
+func (g *GuestActions) progressContext(ctx context.Context, operationID string, gaReq *gpb.GuestActionRequest, conn *client.Connection) context.Context {
+	if !g.isLRORequest(gaReq) {
+		return ctx
+	}
+	send := func(ctx context.Context, body *anypb.Any) error {
+		return g.sendStatusMessage(ctx, operationID, body, statusRunning, lroStateRunning, conn)
+	}
+	return withProgressReporter(ctx, operationID, send, g.options.ProgressInterval)
+}
+
+/*
+queueOperation handles a request for a busy resource when Options.LockWaitTimeout is set. It sends
+a "running" status and then waits in the background for the locks of the request, in turn with
+the other waiting requests. The request is run once it has the locks, and fails if it does not
+get them within the timeout. A waiting request can be cancelled, and is journaled so that it is
+replayed if the agent stops while it waits.
+*/
+func (g *GuestActions) queueOperation(ctx context.Context, operationID string, gaReq *gpb.GuestActionRequest, conn *client.Connection, cloudProperties *metadataserver.CloudProperties, busyKey string) error {
+	log.CtxLogger(ctx).Infow("Resource busy, waiting for lock", "operation_id", operationID, "busy_resource", busyKey, "timeout", g.options.LockWaitTimeout)
+	err := g.sendStatusMessage(ctx, operationID, anyResponse(ctx, guestActionResponse(ctx, nil, "")), statusRunning, lroStateRunning, conn)
+	if err != nil {
+		log.CtxLogger(ctx).Warnw("SendStatusMessage failed", "operation_id", operationID, "channel", g.options.Channel, "err", err)
+		g.cacheFor(ctx).forget(operationID)
+		return err
+	}
+	g.journalFor(ctx).queued(ctx, operationID, gaReq)
+
+	opCtx, done := g.startOperation(ctx, operationID)
+	go func() {
+		defer done()
+		keysToRelease, busyKey, ok := g.waitForLocksForRequest(opCtx, gaReq, cloudProperties, g.options.LockWaitTimeout)
+		if !ok {
+			statusMsg, errMsg := statusFailed, busyMessage(busyKey)
+			if errors.Is(context.Cause(opCtx), errOperationCancelled) {
+				statusMsg, errMsg = statusCancelled, "Operation cancelled"
+			}
+			log.CtxLogger(ctx).Warnw("Failed to acquire lock while waiting", "operation_id", operationID, "busy_resource", busyKey, "status", statusMsg)
+			if err := g.sendFinalStatus(ctx, operationID, anyResponse(ctx, guestActionResponse(ctx, nil, errMsg)), statusMsg, conn); err != nil {
+				log.CtxLogger(ctx).Warnw("SendStatusMessage failed", "operation_id", operationID, "channel", g.options.Channel, "err", err)
+				return
+			}
+			g.journalFor(ctx).done(ctx, operationID, statusMsg)
+			return
+		}
+		g.journalFor(ctx).accepted(ctx, operationID, gaReq, g.locker.held(keysToRelease))
+		g.executeAndSendDone(g.progressContext(opCtx, operationID, gaReq, conn), operationID, gaReq, conn, cloudProperties, keysToRelease)
+	}()
+	return nil
+}
+
+// busyMessage returns the error of an operation aborted because the resource with busyKey is locked.
+func busyMessage(busyKey string) string {
+	if busyKey == "" {
+		return "Operation aborted. A resource with an empty-string lock key is busy"
+	}
+	return fmt.Sprintf("Operation aborted. Resource busy: %s", busyKey)
+}
+
+/*
+Start starts listening to ACS/UAP and handling the related guest actions, until ctx is done.
+The connection is re-established with an exponential backoff when it fails. Operations which
+are running when it fails send their status on the new connection, and a status sent while the
+channel is down is not delivered.
+*/
 func (g *GuestActions) Start(ctx context.Context, a any) {
 	args, ok := a.(Options)
 	if !ok {
@@ -384,18 +999,55 @@ func (g *GuestActions) Start(ctx context.Context, a any) {
 	}
 	g.options = args
 	g.locker = newLocker()
+	g.operationCache = newOperationCache(ctx, args.OperationCache)
+	if args.ShellCommandPolicyFile != "" {
+		g.policy = newShellPolicy(ctx, args.ShellCommandPolicyFile)
+	}
 	endpoint := defaultEndpoint
 	if g.options.Endpoint != "" {
 		endpoint = g.options.Endpoint
 	}
+	// The journal is loaded before the debug endpoint and the connection handler use it.
+	var replay *journalReplay
+	if args.JournalFile != "" {
+		var err error
+		if replay, err = g.loadJournal(ctx); err != nil {
+			log.CtxLogger(ctx).Errorw("Failed to load operation journal, operations will not be journaled", "err", err, "journal", args.JournalFile)
+		}
+	}
+	if args.DebugSocket != "" {
+		go func() {
+			if err := g.serveDebug(ctx, args.DebugSocket, args.CloudProperties); err != nil {
+				log.CtxLogger(ctx).Errorw("Failed to serve guest actions debug endpoint", "err", err, "socket", args.DebugSocket)
+			}
+		}()
+	}
 	log.CtxLogger(ctx).Debugw("Listening for ACS messages", "endpoint", endpoint, "channel", args.Channel)
-	conn := communication.EstablishACSConnection(ctx, endpoint, args.Channel)
+	supervisor := communication.NewSupervisor(communication.ConnectOptions{
+		Endpoint:      endpoint,
+		Channel:       args.Channel,
+		Regional:      true,
+		ClientOptions: args.ClientOptions,
+	}, g.connectionHandler, args.CloudProperties)
+	g.supervisor.Store(supervisor)
+	conn := supervisor.Connect(ctx)
 	if conn == nil {
-		log.CtxLogger(ctx).Errorw("Failed to establish ACS connection, exiting", "endpoint", endpoint, "channel", args.Channel)
+		log.CtxLogger(ctx).Infow("Stopped connecting to ACS, exiting", "endpoint", endpoint, "channel", args.Channel)
 		return
 	}
-	if err := communication.Listen(ctx, conn, g.connectionHandler, args.CloudProperties); err != nil {
+	if replay != nil {
+		g.replayJournal(ctx, replay, conn, args.CloudProperties)
+	}
+	if err := supervisor.Run(ctx, conn); err != nil && ctx.Err() == nil {
 		log.CtxLogger(ctx).Errorw("Failed to listen for ACS messages, exiting", "err", err, "endpoint", endpoint, "channel", args.Channel)
 		return
 	}
 }
+
+// ConnectionState returns the state of the ACS connection, which is the zero value before Start.
+func (g *GuestActions) ConnectionState() communication.ConnectionState {
+	if s := g.supervisor.Load(); s != nil {
+		return s.State()
+	}
+	return communication.ConnectionState{}
+}
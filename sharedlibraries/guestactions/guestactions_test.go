@@ -25,11 +25,15 @@ import (
 	"time"
 
 	"github.com/GoogleCloudPlatform/agentcommunication_client"
+	"github.com/cenkalti/backoff/v4"
 	"github.com/google/go-cmp/cmp"
+	"google.golang.org/grpc/codes"
 	"google.golang.org/protobuf/encoding/prototext"
+	"google.golang.org/protobuf/proto"
 	"google.golang.org/protobuf/testing/protocmp"
 	"github.com/GoogleCloudPlatform/workloadagentplatform/sharedlibraries/commandlineexecutor"
 	"github.com/GoogleCloudPlatform/workloadagentplatform/sharedlibraries/communication"
+	"github.com/GoogleCloudPlatform/workloadagentplatform/sharedlibraries/communication/fakeacs"
 	"github.com/GoogleCloudPlatform/workloadagentplatform/sharedlibraries/gce/metadataserver"
 
 	anypb "google.golang.org/protobuf/types/known/anypb"
@@ -146,10 +150,27 @@ func TestParseRequest(t *testing.T) {
 	}
 }
 
+func TestShellExecutorLimiter(t *testing.T) {
+	limiter := commandlineexecutor.NewLimiter(commandlineexecutor.LimiterOptions{MaxConcurrent: 1})
+	g := &GuestActions{options: Options{CommandLimiter: limiter}}
+	command := &gpb.Command{
+		CommandType: &gpb.Command_ShellCommand{
+			ShellCommand: &gpb.ShellCommand{Command: "echo", Args: "limited"},
+		},
+	}
+	if got := handleShellCommand(context.Background(), command, ShellCommandOptions{}, g.shellExecutor()); got.GetStdout() != "limited\n" {
+		t.Errorf("handleShellCommand() returned stdout %q, want: %q", got.GetStdout(), "limited\n")
+	}
+	if s := limiter.Stats(); s.Started != 1 || s.Running != 0 {
+		t.Errorf("CommandLimiter Stats() = started: %d, running: %d, want 1, 0", s.Started, s.Running)
+	}
+}
+
 func TestHandleShellCommand(t *testing.T) {
 	tests := []struct {
 		name    string
 		command *gpb.Command
+		opts    ShellCommandOptions
 		want    *gpb.CommandResult
 		execute commandlineexecutor.Execute
 	}{
@@ -262,12 +283,162 @@ func TestHandleShellCommand(t *testing.T) {
 			},
 			execute: commandlineexecutor.ExecuteCommand,
 		},
+		{
+			name: "ShellCommandTimedOut",
+			command: &gpb.Command{
+				CommandType: &gpb.Command_ShellCommand{
+					ShellCommand: &gpb.ShellCommand{Command: "sleep", Args: "30", TimeoutSeconds: 1},
+				},
+			},
+			want: &gpb.CommandResult{
+				Command: &gpb.Command{
+					CommandType: &gpb.Command_ShellCommand{
+						ShellCommand: &gpb.ShellCommand{Command: "sleep", Args: "30", TimeoutSeconds: 1},
+					},
+				},
+				Stdout:   "",
+				Stderr:   "Command timed out after 1 seconds.\n",
+				ExitCode: 1,
+			},
+			execute: func(ctx context.Context, params commandlineexecutor.Params) commandlineexecutor.Result {
+				return commandlineexecutor.Result{
+					Error:    errors.New("signal: killed"),
+					ExitCode: -1,
+					TimedOut: true,
+				}
+			},
+		},
+		{
+			name: "ShellCommandStdinEnvAndWorkingDirectory",
+			command: &gpb.Command{
+				CommandType: &gpb.Command_ShellCommand{
+					ShellCommand: &gpb.ShellCommand{
+						Command:          "sh",
+						Args:             "-c 'cat; echo $GREETING $NAME; pwd'",
+						Stdin:            "input\n",
+						Env:              map[string]string{"GREETING": "hello", "NAME": "world"},
+						WorkingDirectory: "/usr/bin",
+					},
+				},
+			},
+			opts: ShellCommandOptions{AllowedEnv: []string{"GREETING", "NAME"}, AllowedWorkingDirectories: []string{"/usr"}},
+			want: &gpb.CommandResult{
+				Command: &gpb.Command{
+					CommandType: &gpb.Command_ShellCommand{
+						ShellCommand: &gpb.ShellCommand{
+							Command:          "sh",
+							Args:             "-c 'cat; echo $GREETING $NAME; pwd'",
+							Stdin:            "input\n",
+							Env:              map[string]string{"GREETING": "hello", "NAME": "world"},
+							WorkingDirectory: "/usr/bin",
+						},
+					},
+				},
+				Stdout: "input\nhello world\n/usr/bin\n",
+			},
+			execute: commandlineexecutor.ExecuteCommand,
+		},
+		{
+			name: "ShellCommandRunAsUser",
+			command: &gpb.Command{
+				CommandType: &gpb.Command_ShellCommand{
+					ShellCommand: &gpb.ShellCommand{Command: "sapcontrol", User: "abcadm"},
+				},
+			},
+			opts: ShellCommandOptions{AllowedUsers: []string{"abcadm"}},
+			want: &gpb.CommandResult{
+				Command: &gpb.Command{
+					CommandType: &gpb.Command_ShellCommand{
+						ShellCommand: &gpb.ShellCommand{Command: "sapcontrol", User: "abcadm"},
+					},
+				},
+				Stdout: "abcadm",
+			},
+			execute: func(ctx context.Context, params commandlineexecutor.Params) commandlineexecutor.Result {
+				return commandlineexecutor.Result{StdOut: params.User}
+			},
+		},
+		{
+			name: "ShellCommandUserNotAllowed",
+			command: &gpb.Command{
+				CommandType: &gpb.Command_ShellCommand{
+					ShellCommand: &gpb.ShellCommand{Command: "sapcontrol", User: "root"},
+				},
+			},
+			opts: ShellCommandOptions{AllowedUsers: []string{"abcadm"}},
+			want: &gpb.CommandResult{
+				Command: &gpb.Command{
+					CommandType: &gpb.Command_ShellCommand{
+						ShellCommand: &gpb.ShellCommand{Command: "sapcontrol", User: "root"},
+					},
+				},
+				Stderr:   "Command rejected by agent configuration: running as user \"root\" is not allowed",
+				ExitCode: PolicyRejectedExitCode,
+			},
+			execute: commandlineexecutor.ExecuteCommand,
+		},
+		{
+			name: "ShellCommandEnvNotAllowed",
+			command: &gpb.Command{
+				CommandType: &gpb.Command_ShellCommand{
+					ShellCommand: &gpb.ShellCommand{Command: "env", Env: map[string]string{"LD_PRELOAD": "/tmp/x.so"}},
+				},
+			},
+			want: &gpb.CommandResult{
+				Command: &gpb.Command{
+					CommandType: &gpb.Command_ShellCommand{
+						ShellCommand: &gpb.ShellCommand{Command: "env", Env: map[string]string{"LD_PRELOAD": "/tmp/x.so"}},
+					},
+				},
+				Stderr:   "Command rejected by agent configuration: setting environment variable \"LD_PRELOAD\" is not allowed",
+				ExitCode: PolicyRejectedExitCode,
+			},
+			execute: commandlineexecutor.ExecuteCommand,
+		},
+		{
+			name: "ShellCommandWorkingDirectoryNotAllowed",
+			command: &gpb.Command{
+				CommandType: &gpb.Command_ShellCommand{
+					ShellCommand: &gpb.ShellCommand{Command: "ls", WorkingDirectory: "/usr/sap/../../etc"},
+				},
+			},
+			opts: ShellCommandOptions{AllowedWorkingDirectories: []string{"/usr/sap"}},
+			want: &gpb.CommandResult{
+				Command: &gpb.Command{
+					CommandType: &gpb.Command_ShellCommand{
+						ShellCommand: &gpb.ShellCommand{Command: "ls", WorkingDirectory: "/usr/sap/../../etc"},
+					},
+				},
+				Stderr:   "Command rejected by agent configuration: working directory \"/usr/sap/../../etc\" is not allowed",
+				ExitCode: PolicyRejectedExitCode,
+			},
+			execute: commandlineexecutor.ExecuteCommand,
+		},
+		{
+			name: "ShellCommandStdinTooLarge",
+			command: &gpb.Command{
+				CommandType: &gpb.Command_ShellCommand{
+					ShellCommand: &gpb.ShellCommand{Command: "cat", Stdin: "0123456789"},
+				},
+			},
+			opts: ShellCommandOptions{MaxStdinBytes: 4},
+			want: &gpb.CommandResult{
+				Command: &gpb.Command{
+					CommandType: &gpb.Command_ShellCommand{
+						ShellCommand: &gpb.ShellCommand{Command: "cat", Stdin: "0123456789"},
+					},
+				},
+				Stderr:   "Command rejected by agent configuration: stdin of 10 bytes exceeds the maximum of 4 bytes",
+				ExitCode: PolicyRejectedExitCode,
+			},
+			execute: commandlineexecutor.ExecuteCommand,
+		},
 	}
 
 	for _, test := range tests {
 		t.Run(test.name, func(t *testing.T) {
 			ctx := context.Background()
-			got := handleShellCommand(ctx, test.command, test.execute)
+			got := handleShellCommand(ctx, test.command, test.opts, test.execute)
 			if diff := cmp.Diff(test.want, got, protocmp.Transform()); diff != "" {
 				t.Errorf("handleShellCommand(%v) returned diff (-want +got):\n%s", test.command, diff)
 			}
@@ -739,6 +910,209 @@ func TestAcquireLocksForRequest(t *testing.T) {
 	}
 }
 
+func TestLockRequests(t *testing.T) {
+	concurrencyKey := func(_ context.Context, c *gpb.Command, _ *metadataserver.CloudProperties) (string, time.Duration, bool) {
+		if c.GetAgentCommand().GetCommand() == "status" {
+			return "HDB", time.Minute, true
+		}
+		return "HDB", 0, true
+	}
+	lockMode := func(_ context.Context, c *gpb.Command, _ *metadataserver.CloudProperties) LockMode {
+		if c.GetAgentCommand().GetCommand() == "stop" {
+			return LockExclusive
+		}
+		return LockShared
+	}
+	request := func(commands ...string) *gpb.GuestActionRequest {
+		gar := &gpb.GuestActionRequest{}
+		for _, c := range commands {
+			gar.Commands = append(gar.Commands, &gpb.Command{
+				CommandType: &gpb.Command_AgentCommand{AgentCommand: &gpb.AgentCommand{Command: c}},
+			})
+		}
+		return gar
+	}
+	tests := []struct {
+		name     string
+		request  *gpb.GuestActionRequest
+		lockMode func(context.Context, *gpb.Command, *metadataserver.CloudProperties) LockMode
+		want     map[string]lockRequest
+	}{
+		{
+			name:     "Shared",
+			request:  request("status", "other"),
+			lockMode: lockMode,
+			want:     map[string]lockRequest{"HDB": {timeout: defaultLockTimeout, mode: LockShared}},
+		},
+		{
+			name:     "ExclusiveWins",
+			request:  request("status", "stop", "status"),
+			lockMode: lockMode,
+			want:     map[string]lockRequest{"HDB": {timeout: defaultLockTimeout, mode: LockExclusive}},
+		},
+		{
+			name:    "ExclusiveByDefault",
+			request: request("other"),
+			want:    map[string]lockRequest{"HDB": {timeout: defaultLockTimeout, mode: LockExclusive}},
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			g := &GuestActions{
+				options: Options{
+					CommandConcurrencyKey: concurrencyKey,
+					CommandLockMode:       tc.lockMode,
+				},
+			}
+			got := g.lockRequests(context.Background(), tc.request, nil)
+			if diff := cmp.Diff(tc.want, got, cmp.AllowUnexported(lockRequest{})); diff != "" {
+				t.Errorf("lockRequests() returned diff (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestLockerSharedLocks(t *testing.T) {
+	ctx := context.Background()
+	l := newLocker()
+	shared := map[string]lockRequest{"a": {timeout: time.Hour, mode: LockShared}}
+	exclusive := map[string]lockRequest{"a": {timeout: time.Hour, mode: LockExclusive}}
+
+	if _, ok := l.acquireLocks(ctx, shared); !ok {
+		t.Fatalf("acquireLocks(shared) = false, want true")
+	}
+	if _, ok := l.acquireLocks(ctx, shared); !ok {
+		t.Fatalf("acquireLocks(shared) while shared = false, want true")
+	}
+	if key, ok := l.acquireLocks(ctx, exclusive); ok || key != "a" {
+		t.Errorf("acquireLocks(exclusive) while shared = (%q, %v), want (\"a\", false)", key, ok)
+	}
+	l.release([]string{"a"})
+	if _, ok := l.acquireLocks(ctx, exclusive); ok {
+		t.Errorf("acquireLocks(exclusive) while still shared once = true, want false")
+	}
+	l.release([]string{"a"})
+	if _, ok := l.acquireLocks(ctx, exclusive); !ok {
+		t.Fatalf("acquireLocks(exclusive) after releasing all shared locks = false, want true")
+	}
+	if key, ok := l.acquireLocks(ctx, shared); ok || key != "a" {
+		t.Errorf("acquireLocks(shared) while exclusive = (%q, %v), want (\"a\", false)", key, ok)
+	}
+}
+
+func TestLockerWait(t *testing.T) {
+	ctx := context.Background()
+	shared := map[string]lockRequest{"a": {timeout: time.Hour, mode: LockShared}}
+	exclusive := map[string]lockRequest{"a": {timeout: time.Hour, mode: LockExclusive}}
+
+	t.Run("AcquiredWhenReleased", func(t *testing.T) {
+		l := newLocker()
+		l.acquireLocks(ctx, shared)
+		acquired := make(chan bool)
+		go func() {
+			_, ok := l.wait(ctx, exclusive, time.Minute)
+			acquired <- ok
+		}()
+		waitForWaiters(t, l, 1)
+		// A later request does not jump ahead of the waiting request.
+		if _, ok := l.acquireLocks(ctx, shared); ok {
+			t.Errorf("acquireLocks(shared) with an exclusive request waiting = true, want false")
+		}
+		l.release([]string{"a"})
+		if ok := <-acquired; !ok {
+			t.Errorf("wait() after the lock was released = false, want true")
+		}
+		if key, ok := l.acquireLocks(ctx, shared); ok || key != "a" {
+			t.Errorf("acquireLocks(shared) after wait() = (%q, %v), want (\"a\", false)", key, ok)
+		}
+	})
+
+	t.Run("Timeout", func(t *testing.T) {
+		l := newLocker()
+		l.acquireLocks(ctx, exclusive)
+		if key, ok := l.wait(ctx, shared, 10*time.Millisecond); ok || key != "a" {
+			t.Errorf("wait() with the lock held = (%q, %v), want (\"a\", false)", key, ok)
+		}
+		if n := len(l.waiters); n != 0 {
+			t.Errorf("wait() left %d waiters after the timeout, want 0", n)
+		}
+	})
+
+	t.Run("ContextDone", func(t *testing.T) {
+		l := newLocker()
+		l.acquireLocks(ctx, exclusive)
+		cancelCtx, cancel := context.WithCancel(ctx)
+		cancel()
+		if _, ok := l.wait(cancelCtx, shared, time.Minute); ok {
+			t.Errorf("wait() with a cancelled context = true, want false")
+		}
+	})
+}
+
+// waitForWaiters waits until n requests are waiting in the locker.
+func waitForWaiters(t *testing.T, l *locker, n int) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		l.mu.Lock()
+		waiting := len(l.waiters)
+		l.mu.Unlock()
+		if waiting == n {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("Timed out waiting for %d requests to wait for locks, got %d", n, waiting)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestConnectionHandlerLockWait(t *testing.T) {
+	tests := []struct {
+		name       string
+		timeout    time.Duration
+		release    bool
+		wantStatus string
+	}{
+		{name: "RunsOnceLockIsReleased", timeout: time.Minute, release: true, wantStatus: statusSucceeded},
+		{name: "FailsAfterTimeout", timeout: 10 * time.Millisecond, wantStatus: statusFailed},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			ctx := context.Background()
+			recorder := newStatusRecorder(t)
+			g := &GuestActions{
+				options: Options{
+					Handlers: testHandlers,
+					CommandConcurrencyKey: func(context.Context, *gpb.Command, *metadataserver.CloudProperties) (string, time.Duration, bool) {
+						return "ABC", time.Hour, true
+					},
+					LockWaitTimeout: tc.timeout,
+				},
+				locker: newLocker(),
+			}
+			g.locker.acquire(ctx, map[string]time.Duration{"ABC": time.Hour})
+
+			req := &gpb.GuestActionRequest{Commands: []*gpb.Command{
+				{CommandType: &gpb.Command_AgentCommand{AgentCommand: &gpb.AgentCommand{Command: "version"}}},
+			}}
+			if err := g.connectionHandler(ctx, requestMessage(t, "op1", req), nil, nil); err != nil {
+				t.Fatalf("connectionHandler() returned unexpected error: %v", err)
+			}
+			if got := recorder.next(t).GetLabels()["state"]; got != statusRunning {
+				t.Errorf("connectionHandler() for a busy resource sent status %q, want %q", got, statusRunning)
+			}
+			if tc.release {
+				waitForWaiters(t, g.locker, 1)
+				g.locker.release([]string{"ABC"})
+			}
+			if got := recorder.next(t).GetLabels()["state"]; got != tc.wantStatus {
+				t.Errorf("connectionHandler() for a busy resource sent final status %q, want %q", got, tc.wantStatus)
+			}
+		})
+	}
+}
+
 func TestExecuteAndSendDone(t *testing.T) {
 	tests := []struct {
 		name         string
@@ -980,3 +1354,241 @@ func TestIsLRORequest(t *testing.T) {
 		})
 	}
 }
+
+// statusRecorder captures the status messages sent via communication.SendMessage.
+type statusRecorder struct {
+	msgs chan *acpb.MessageBody
+}
+
+func newStatusRecorder(t *testing.T) *statusRecorder {
+	r := &statusRecorder{msgs: make(chan *acpb.MessageBody, 10)}
+	origSendMessage := communication.SendMessage
+	t.Cleanup(func() { communication.SendMessage = origSendMessage })
+	communication.SendMessage = func(c *client.Connection, msg *acpb.MessageBody) error {
+		r.msgs <- msg
+		return nil
+	}
+	return r
+}
+
+// next returns the next status message sent, failing the test if none is sent in time.
+func (r *statusRecorder) next(t *testing.T) *acpb.MessageBody {
+	t.Helper()
+	select {
+	case msg := <-r.msgs:
+		return msg
+	case <-time.After(10 * time.Second):
+		t.Fatalf("Timed out waiting for a status message")
+		return nil
+	}
+}
+
+func requestMessage(t *testing.T, operationID string, m proto.Message) *acpb.MessageBody {
+	t.Helper()
+	body, err := anypb.New(m)
+	if err != nil {
+		t.Fatalf("anypb.New(%v) failed: %v", m, err)
+	}
+	return &acpb.MessageBody{Labels: map[string]string{"operation_id": operationID}, Body: body}
+}
+
+func TestCancelOperation(t *testing.T) {
+	tests := []struct {
+		name        string
+		command     *gpb.Command
+		wantRunning bool
+	}{
+		{
+			name: "LROHandlerObservesContext",
+			command: &gpb.Command{
+				CommandType: &gpb.Command_AgentCommand{AgentCommand: &gpb.AgentCommand{Command: "sap_stop"}},
+			},
+			wantRunning: true,
+		},
+		{
+			name: "ShellCommandIsKilled",
+			command: &gpb.Command{
+				CommandType: &gpb.Command_ShellCommand{ShellCommand: &gpb.ShellCommand{Command: "sleep", Args: "30"}},
+			},
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			ctx := context.Background()
+			recorder := newStatusRecorder(t)
+			started := make(chan struct{})
+			g := &GuestActions{
+				options: Options{
+					LROHandlers: map[string]GuestActionHandler{
+						"sap_stop": func(ctx context.Context, command *gpb.Command, cp *metadataserver.CloudProperties) *gpb.CommandResult {
+							close(started)
+							<-ctx.Done()
+							return &gpb.CommandResult{Command: command, Stderr: "stopped early", ExitCode: 1}
+						},
+					},
+					CommandConcurrencyKey: func(context.Context, *gpb.Command, *metadataserver.CloudProperties) (string, time.Duration, bool) {
+						return "ABC", time.Hour, true
+					},
+				},
+				locker: newLocker(),
+			}
+
+			req := &gpb.GuestActionRequest{Commands: []*gpb.Command{tc.command}}
+			if err := g.connectionHandler(ctx, requestMessage(t, "op1", req), nil, nil); err != nil {
+				t.Fatalf("connectionHandler(op1) returned unexpected error: %v", err)
+			}
+			if tc.wantRunning {
+				if got := recorder.next(t).GetLabels()["state"]; got != statusRunning {
+					t.Errorf("connectionHandler(op1) sent status %q, want %q", got, statusRunning)
+				}
+				<-started
+			} else {
+				// Give the shell command time to start.
+				time.Sleep(100 * time.Millisecond)
+			}
+
+			start := time.Now()
+			cancelReq := &gpb.CancelOperationRequest{OperationId: "op1"}
+			if err := g.connectionHandler(ctx, requestMessage(t, "cancel1", cancelReq), nil, nil); err != nil {
+				t.Fatalf("connectionHandler(cancel1) returned unexpected error: %v", err)
+			}
+			got := map[string]string{}
+			for i := 0; i < 2; i++ {
+				msg := recorder.next(t)
+				got[msg.GetLabels()["operation_id"]] = msg.GetLabels()["state"] + "/" + msg.GetLabels()["lro_state"]
+			}
+			want := map[string]string{"op1": statusCancelled + "/" + lroStateDone, "cancel1": statusSucceeded + "/" + lroStateDone}
+			if diff := cmp.Diff(want, got); diff != "" {
+				t.Errorf("connectionHandler() sent unexpected statuses after cancel (-want +got):\n%s", diff)
+			}
+			if elapsed := time.Since(start); elapsed > 10*time.Second {
+				t.Errorf("Cancelled operation took %v to finish, want it to stop promptly", elapsed)
+			}
+
+			// The lock is released once the cancelled operation has reported its status.
+			waitForUnlocked := time.Now().Add(5 * time.Second)
+			for {
+				if _, ok := g.locker.acquire(ctx, map[string]time.Duration{"ABC": time.Minute}); ok {
+					break
+				}
+				if time.Now().After(waitForUnlocked) {
+					t.Fatalf("Lock for ABC was not released after the operation was cancelled")
+				}
+				time.Sleep(time.Millisecond)
+			}
+		})
+	}
+}
+
+func TestCancelUnknownOperation(t *testing.T) {
+	recorder := newStatusRecorder(t)
+	g := &GuestActions{locker: newLocker()}
+	cancelReq := &gpb.CancelOperationRequest{OperationId: "unknown"}
+	if err := g.connectionHandler(context.Background(), requestMessage(t, "cancel1", cancelReq), nil, nil); err != nil {
+		t.Fatalf("connectionHandler(cancel1) returned unexpected error: %v", err)
+	}
+	msg := recorder.next(t)
+	if got := msg.GetLabels()["state"]; got != statusFailed {
+		t.Errorf("connectionHandler(cancel1) sent status %q, want %q", got, statusFailed)
+	}
+	resp := &gpb.GuestActionResponse{}
+	if err := msg.GetBody().UnmarshalTo(resp); err != nil {
+		t.Fatalf("Failed to unmarshal response: %v", err)
+	}
+	if want := "No in-flight operation with operation_id: unknown"; resp.GetError().GetErrorMessage() != want {
+		t.Errorf("connectionHandler(cancel1) sent error %q, want %q", resp.GetError().GetErrorMessage(), want)
+	}
+}
+
+func TestStartFakeACS(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	server := fakeacs.New(t)
+	g := &GuestActions{}
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		g.Start(ctx, Options{
+			Channel:       "test-channel",
+			Endpoint:      server.Endpoint(),
+			Handlers:      testHandlers,
+			ClientOptions: server.ClientOptions(),
+		})
+	}()
+
+	server.Send(requestMessage(t, "op1", &gpb.GuestActionRequest{Commands: []*gpb.Command{namedCommand("", "version")}}))
+	msg, err := server.Next(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := msg.GetLabels(); got["operation_id"] != "op1" || got["state"] != statusSucceeded || got["lro_state"] != lroStateDone {
+		t.Errorf("Start() sent status labels %v, want a succeeded and done status of op1", got)
+	}
+	resp := &gpb.GuestActionResponse{}
+	if err := msg.GetBody().UnmarshalTo(resp); err != nil {
+		t.Fatalf("UnmarshalTo() failed: %v", err)
+	}
+	if got, want := resp.GetCommandResults()[0].GetStdout(), "Google Cloud Agent for SAP version test response"; got != want {
+		t.Errorf("Start() sent stdout %q, want: %q", got, want)
+	}
+	if state := g.ConnectionState(); !state.Connected {
+		t.Errorf("ConnectionState() = %+v, want connected", state)
+	}
+	cancel()
+	<-done
+}
+
+func TestStatusAfterReconnect(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	server := fakeacs.New(t)
+	release := make(chan struct{})
+	g := &GuestActions{
+		options: Options{
+			LROHandlers: map[string]GuestActionHandler{
+				"sap_stop": func(ctx context.Context, command *gpb.Command, cp *metadataserver.CloudProperties) *gpb.CommandResult {
+					<-release
+					return &gpb.CommandResult{Command: command, Stdout: "stopped"}
+				},
+			},
+		},
+		locker: newLocker(),
+	}
+	supervisor := communication.NewSupervisor(communication.ConnectOptions{
+		Endpoint:      server.Endpoint(),
+		Channel:       "test-channel",
+		Backoff:       func() backoff.BackOff { return &backoff.ZeroBackOff{} },
+		ClientOptions: server.ClientOptions(),
+	}, g.connectionHandler, nil)
+	g.supervisor.Store(supervisor)
+	done := make(chan error)
+	go func() { done <- supervisor.Run(ctx, nil) }()
+
+	server.Send(requestMessage(t, "op1", &gpb.GuestActionRequest{Commands: []*gpb.Command{namedCommand("", "sap_stop")}}))
+	msg, err := server.Next(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := msg.GetLabels(); got["operation_id"] != "op1" || got["lro_state"] != lroStateRunning {
+		t.Fatalf("connectionHandler() sent status labels %v, want a running status of op1", got)
+	}
+	// The ACS client closes its connection after an unexpected stream error.
+	server.CloseStreams(codes.Internal)
+	for state := g.ConnectionState(); !state.Connected || state.Reconnects == 0; state = g.ConnectionState() {
+		if ctx.Err() != nil {
+			t.Fatalf("ConnectionState() = %+v, want connected after a reconnect", state)
+		}
+		time.Sleep(time.Millisecond)
+	}
+	close(release)
+
+	msg, err = server.Next(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := msg.GetLabels(); got["operation_id"] != "op1" || got["state"] != statusSucceeded || got["lro_state"] != lroStateDone {
+		t.Errorf("connectionHandler() sent status labels %v after reconnecting, want a succeeded and done status of op1", got)
+	}
+	cancel()
+	<-done
+}
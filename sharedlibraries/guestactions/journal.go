@@ -0,0 +1,360 @@
+/*
+Copyright 2025 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package guestactions
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/GoogleCloudPlatform/agentcommunication_client"
+	"google.golang.org/protobuf/proto"
+	"github.com/GoogleCloudPlatform/workloadagentplatform/sharedlibraries/gce/metadataserver"
+	"github.com/GoogleCloudPlatform/workloadagentplatform/sharedlibraries/log"
+
+	gpb "github.com/GoogleCloudPlatform/workloadagentplatform/sharedprotos/guestactions"
+)
+
+const (
+	// journalQueued records an operation which is waiting for the locks of its resources.
+	journalQueued = "queued"
+	// journalAccepted records an operation which has acquired its locks and is being processed.
+	journalAccepted = "accepted"
+	// journalDone records an operation which has sent its final status.
+	journalDone = "done"
+
+	// interruptedMessage is the error of an operation which was running when the agent stopped.
+	interruptedMessage = "Operation interrupted by an agent restart"
+
+	// journalCompactThreshold is the number of operations which finish before the journal is
+	// compacted.
+	journalCompactThreshold = 100
+)
+
+type (
+	// journalRecord is a state transition of an operation, stored as a line of JSON in the journal.
+	journalRecord struct {
+		Time        time.Time `json:"time"`
+		OperationID string    `json:"operation_id"`
+		State       string    `json:"state"`
+		// Request is the serialized GuestActionRequest of a queued or accepted operation.
+		Request []byte `json:"request,omitempty"`
+		// Locks are the lock keys an accepted operation holds and when they expire.
+		Locks map[string]heldLock `json:"locks,omitempty"`
+		// Status is the final status of a done operation.
+		Status string `json:"status,omitempty"`
+	}
+
+	/*
+		operationJournal is an append-only file of the operations accepted by guest actions and the
+		locks they hold, so that operations interrupted by an agent restart can be reported or
+		resumed by replayJournal. The methods of a nil operationJournal do nothing.
+
+		The journal is compacted to the operations which are not done every
+		journalCompactThreshold finished operations.
+	*/
+	operationJournal struct {
+		path string
+
+		mu   sync.Mutex
+		file *os.File
+		// open are the accepted records of the operations which are not done, in the order they
+		// were accepted.
+		open []journalRecord
+		// finished is the number of operations done since the journal was last compacted.
+		finished int
+	}
+
+	// journalReplay are the operations of the journal to report or resume once connected to ACS.
+	journalReplay struct {
+		toResume []resumedOperation
+		// toRequeue are the resumed operations which were waiting for their locks.
+		toRequeue   []resumedOperation
+		toInterrupt []string
+	}
+
+	// resumedOperation is an operation of the journal which is run again.
+	resumedOperation struct {
+		operationID string
+		gaReq       *gpb.GuestActionRequest
+		keys        []string
+	}
+)
+
+/*
+readJournal returns the records of the queued or accepted operations in the journal at path which
+are not done, in the order they were first recorded. A missing journal has no operations. Lines which cannot be
+parsed, such as a record partially written as the agent stopped, are skipped.
+*/
+func readJournal(ctx context.Context, path string) ([]journalRecord, error) {
+	f, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var order []string
+	open := make(map[string]journalRecord)
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 0, 64*1024), 16*1024*1024)
+	for line := 1; scanner.Scan(); line++ {
+		var r journalRecord
+		if err := json.Unmarshal(scanner.Bytes(), &r); err != nil {
+			log.CtxLogger(ctx).Warnw("Skipping invalid operation journal record", "journal", path, "line", line, "error", err)
+			continue
+		}
+		switch r.State {
+		case journalQueued, journalAccepted:
+			if _, ok := open[r.OperationID]; !ok {
+				order = append(order, r.OperationID)
+			}
+			open[r.OperationID] = r
+		case journalDone:
+			delete(open, r.OperationID)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	var records []journalRecord
+	for _, id := range order {
+		if r, ok := open[id]; ok {
+			records = append(records, r)
+			delete(open, id)
+		}
+	}
+	return records, nil
+}
+
+/*
+openJournal replaces the journal at path with the records, discarding the history of finished
+operations, and opens it to append new records.
+*/
+func openJournal(path string, records []journalRecord) (*operationJournal, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0750); err != nil {
+		return nil, err
+	}
+	j := &operationJournal{path: path, open: records}
+	if err := j.compact(); err != nil {
+		return nil, err
+	}
+	return j, nil
+}
+
+/*
+compact replaces the journal file with the records of the operations which are not done and
+reopens it to append new records. j.mu must be held once the journal is in use.
+*/
+func (j *operationJournal) compact() error {
+	tmp := j.path + ".tmp"
+	f, err := os.OpenFile(tmp, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	for _, r := range j.open {
+		if err := writeJournalRecord(f, r); err != nil {
+			f.Close()
+			return err
+		}
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, j.path); err != nil {
+		return err
+	}
+	file, err := os.OpenFile(j.path, os.O_APPEND|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	if j.file != nil {
+		j.file.Close()
+	}
+	j.file, j.finished = file, 0
+	return nil
+}
+
+// queued records that the operation is waiting for the locks of its resources.
+func (j *operationJournal) queued(ctx context.Context, operationID string, gaReq *gpb.GuestActionRequest) {
+	j.recordRequest(ctx, operationID, journalQueued, gaReq, nil)
+}
+
+// accepted records that the operation has acquired the locks and is being processed.
+func (j *operationJournal) accepted(ctx context.Context, operationID string, gaReq *gpb.GuestActionRequest, locks map[string]heldLock) {
+	j.recordRequest(ctx, operationID, journalAccepted, gaReq, locks)
+}
+
+func (j *operationJournal) recordRequest(ctx context.Context, operationID, state string, gaReq *gpb.GuestActionRequest, locks map[string]heldLock) {
+	if j == nil {
+		return
+	}
+	request, err := proto.Marshal(gaReq)
+	if err != nil {
+		log.CtxLogger(ctx).Warnw("Could not serialize request for the operation journal", "operation_id", operationID, "error", err)
+		return
+	}
+	j.record(ctx, journalRecord{OperationID: operationID, State: state, Request: request, Locks: locks})
+}
+
+// done records that the operation has sent its final status. It does nothing for an operation
+// which is not in the journal.
+func (j *operationJournal) done(ctx context.Context, operationID, status string) {
+	if j == nil {
+		return
+	}
+	j.record(ctx, journalRecord{OperationID: operationID, State: journalDone, Status: status})
+}
+
+/*
+record appends r to the journal, and compacts the journal once enough operations have finished.
+A failure is logged rather than failing the operation.
+*/
+func (j *operationJournal) record(ctx context.Context, r journalRecord) {
+	r.Time = time.Now()
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	i := slices.IndexFunc(j.open, func(o journalRecord) bool { return o.OperationID == r.OperationID })
+	if r.State == journalDone && i < 0 {
+		return
+	}
+	if err := writeJournalRecord(j.file, r); err != nil {
+		log.CtxLogger(ctx).Warnw("Could not write to the operation journal", "operation_id", r.OperationID, "state", r.State, "error", err)
+	}
+	switch {
+	case r.State == journalDone:
+		j.open = slices.Delete(j.open, i, i+1)
+		j.finished++
+	case i >= 0:
+		j.open[i] = r
+	default:
+		j.open = append(j.open, r)
+	}
+	if j.finished < journalCompactThreshold {
+		return
+	}
+	if err := j.compact(); err != nil {
+		log.CtxLogger(ctx).Warnw("Could not compact the operation journal", "journal", j.path, "error", err)
+	}
+}
+
+// writeJournalRecord appends r to the journal file f and syncs it to disk.
+func writeJournalRecord(f *os.File, r journalRecord) error {
+	data, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(append(data, '\n')); err != nil {
+		return err
+	}
+	return f.Sync()
+}
+
+/*
+loadJournal opens the journal in Options.JournalFile and returns the operations which were running
+or waiting for their locks when the agent last stopped, for replayJournal. Operations for which
+Options.ResumeOperation returns true are resumed, and the locks of running operations are held
+until the expiry they were acquired with. Every other operation is interrupted, and its locks are
+released. The operations stay in the journal until they send their final status, so that they are
+replayed again if the agent stops first. The journal must be loaded before any operation is
+handled.
+*/
+func (g *GuestActions) loadJournal(ctx context.Context) (*journalReplay, error) {
+	path := g.options.JournalFile
+	records, err := readJournal(ctx, path)
+	if err != nil {
+		return nil, fmt.Errorf("reading operation journal %s: %w", path, err)
+	}
+
+	replay := &journalReplay{}
+	var kept []journalRecord
+	now := time.Now()
+	for _, r := range records {
+		gaReq := &gpb.GuestActionRequest{}
+		if err := proto.Unmarshal(r.Request, gaReq); err != nil {
+			log.CtxLogger(ctx).Warnw("Could not parse journaled request, reporting the operation as interrupted", "operation_id", r.OperationID, "error", err)
+			r.Locks = nil
+			kept = append(kept, r)
+			replay.toInterrupt = append(replay.toInterrupt, r.OperationID)
+			continue
+		}
+		if g.options.ResumeOperation == nil || !g.options.ResumeOperation(gaReq) {
+			r.Locks = nil
+			kept = append(kept, r)
+			replay.toInterrupt = append(replay.toInterrupt, r.OperationID)
+			continue
+		}
+		if r.State == journalQueued {
+			kept = append(kept, r)
+			replay.toRequeue = append(replay.toRequeue, resumedOperation{operationID: r.OperationID, gaReq: gaReq})
+			continue
+		}
+		locks := make(map[string]heldLock)
+		for k, h := range r.Locks {
+			if h.Expiry.After(now) {
+				locks[k] = h
+			}
+		}
+		keys := g.locker.restore(locks)
+		r.Locks = locks
+		kept = append(kept, r)
+		replay.toResume = append(replay.toResume, resumedOperation{r.OperationID, gaReq, keys})
+	}
+
+	if g.journal, err = openJournal(path, kept); err != nil {
+		return nil, fmt.Errorf("opening operation journal %s: %w", path, err)
+	}
+	return replay, nil
+}
+
+/*
+replayJournal reports the interrupted operations of the journal to the service with a failed final
+status, and runs the resumed operations again. Resumed operations which were waiting for their
+locks are handled again as new requests. An interrupted operation whose status cannot be sent
+stays in the journal.
+*/
+func (g *GuestActions) replayJournal(ctx context.Context, replay *journalReplay, conn *client.Connection, cloudProperties *metadataserver.CloudProperties) {
+	for _, operationID := range replay.toInterrupt {
+		log.CtxLogger(ctx).Infow("Reporting operation interrupted by an agent restart", "operation_id", operationID, "channel", g.options.Channel)
+		if err := g.sendFinalStatus(ctx, operationID, anyResponse(ctx, guestActionResponse(ctx, nil, interruptedMessage)), statusFailed, conn); err != nil {
+			log.CtxLogger(ctx).Warnw("SendStatusMessage failed", "operation_id", operationID, "channel", g.options.Channel, "err", err)
+			continue
+		}
+		g.journal.done(ctx, operationID, statusFailed)
+	}
+	for _, r := range replay.toResume {
+		log.CtxLogger(ctx).Infow("Resuming operation interrupted by an agent restart", "operation_id", r.operationID, "channel", g.options.Channel)
+		g.operationCache.begin(r.operationID)
+		g.runOperation(ctx, r.operationID, r.gaReq, conn, cloudProperties, r.keys)
+	}
+	for _, r := range replay.toRequeue {
+		log.CtxLogger(ctx).Infow("Resuming operation which was waiting for locks when the agent restarted", "operation_id", r.operationID, "channel", g.options.Channel)
+		g.operationCache.begin(r.operationID)
+		if err := g.handleRequest(ctx, r.operationID, r.gaReq, conn, cloudProperties); err != nil {
+			log.CtxLogger(ctx).Warnw("Failed to resume operation", "operation_id", r.operationID, "channel", g.options.Channel, "err", err)
+		}
+	}
+}
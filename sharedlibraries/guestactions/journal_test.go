@@ -0,0 +1,330 @@
+/*
+Copyright 2025 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package guestactions
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/GoogleCloudPlatform/agentcommunication_client"
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
+	"google.golang.org/protobuf/proto"
+	"github.com/GoogleCloudPlatform/workloadagentplatform/sharedlibraries/communication"
+	"github.com/GoogleCloudPlatform/workloadagentplatform/sharedlibraries/gce/metadataserver"
+
+	acpb "github.com/GoogleCloudPlatform/agentcommunication_client/gapic/agentcommunicationpb"
+	gpb "github.com/GoogleCloudPlatform/workloadagentplatform/sharedprotos/guestactions"
+)
+
+func agentCommandRequest(t *testing.T, command string) []byte {
+	t.Helper()
+	data, err := proto.Marshal(&gpb.GuestActionRequest{Commands: []*gpb.Command{
+		{CommandType: &gpb.Command_AgentCommand{AgentCommand: &gpb.AgentCommand{Command: command}}},
+	}})
+	if err != nil {
+		t.Fatalf("proto.Marshal() failed: %v", err)
+	}
+	return data
+}
+
+func writeJournal(t *testing.T, path string, records ...journalRecord) {
+	t.Helper()
+	var data []byte
+	for _, r := range records {
+		line, err := json.Marshal(r)
+		if err != nil {
+			t.Fatalf("json.Marshal(%v) failed: %v", r, err)
+		}
+		data = append(append(data, line...), '\n')
+	}
+	// A record partially written as the agent stopped.
+	data = append(data, `{"operation_id": "op`...)
+	if err := os.WriteFile(path, data, 0600); err != nil {
+		t.Fatalf("os.WriteFile(%q) failed: %v", path, err)
+	}
+}
+
+func TestReadJournal(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "journal")
+	expiry := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	writeJournal(t, path,
+		journalRecord{OperationID: "op1", State: journalAccepted},
+		journalRecord{OperationID: "op2", State: journalAccepted, Locks: map[string]heldLock{"ABC": {Expiry: expiry}}},
+		journalRecord{OperationID: "op1", State: journalDone, Status: statusSucceeded},
+		journalRecord{OperationID: "op3", State: journalAccepted},
+	)
+	got, err := readJournal(context.Background(), path)
+	if err != nil {
+		t.Fatalf("readJournal() returned unexpected error: %v", err)
+	}
+	want := []journalRecord{
+		{OperationID: "op2", State: journalAccepted, Locks: map[string]heldLock{"ABC": {Expiry: expiry}}},
+		{OperationID: "op3", State: journalAccepted},
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("readJournal() returned diff (-want +got):\n%s", diff)
+	}
+
+	got, err = readJournal(context.Background(), filepath.Join(t.TempDir(), "missing"))
+	if err != nil || got != nil {
+		t.Errorf("readJournal() of a missing journal returned: %v, %v, want: nil, nil", got, err)
+	}
+}
+
+func TestOperationJournalCompacts(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "journal")
+	journal, err := openJournal(path, nil)
+	if err != nil {
+		t.Fatalf("openJournal() returned unexpected error: %v", err)
+	}
+	journal.accepted(ctx, "running", &gpb.GuestActionRequest{}, nil)
+	for i := 0; i < journalCompactThreshold; i++ {
+		id := fmt.Sprintf("op%d", i)
+		journal.accepted(ctx, id, &gpb.GuestActionRequest{}, nil)
+		journal.done(ctx, id, statusSucceeded)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("os.ReadFile(%q) failed: %v", path, err)
+	}
+	if lines := strings.Count(string(data), "\n"); lines != 1 {
+		t.Errorf("Journal has %d records after %d operations finished, want 1 record of the running operation", lines, journalCompactThreshold)
+	}
+	journal.done(ctx, "running", statusSucceeded)
+	if records, err := readJournal(ctx, path); err != nil || len(records) != 0 {
+		t.Errorf("readJournal() after compaction returned: %v, %v, want no operations", records, err)
+	}
+}
+
+func TestReplayJournal(t *testing.T) {
+	ctx := context.Background()
+	recorder := newStatusRecorder(t)
+	path := filepath.Join(t.TempDir(), "journal")
+	future := time.Now().Add(time.Hour)
+	writeJournal(t, path,
+		journalRecord{OperationID: "op1", State: journalAccepted, Request: agentCommandRequest(t, "sap_stop"), Locks: map[string]heldLock{"ABC": {Expiry: future}}},
+		journalRecord{OperationID: "op2", State: journalAccepted, Request: agentCommandRequest(t, "sap_status"), Locks: map[string]heldLock{"DEF": {Expiry: future, Shared: true}, "GHI": {Expiry: time.Now().Add(-time.Hour)}}},
+	)
+
+	proceed := make(chan struct{})
+	g := &GuestActions{
+		options: Options{
+			Handlers: map[string]GuestActionHandler{
+				"sap_status": func(ctx context.Context, command *gpb.Command, cp *metadataserver.CloudProperties) *gpb.CommandResult {
+					<-proceed
+					return &gpb.CommandResult{Command: command, Stdout: "running"}
+				},
+			},
+			JournalFile: path,
+			ResumeOperation: func(gaReq *gpb.GuestActionRequest) bool {
+				return gaReq.GetCommands()[0].GetAgentCommand().GetCommand() == "sap_status"
+			},
+		},
+		locker: newLocker(),
+	}
+	replay, err := g.loadJournal(ctx)
+	if err != nil {
+		t.Fatalf("loadJournal() returned unexpected error: %v", err)
+	}
+	g.replayJournal(ctx, replay, nil, nil)
+
+	msg := recorder.next(t)
+	state, _ := statusProgress(t, msg)
+	if id := msg.GetLabels()["operation_id"]; id != "op1" || state != statusFailed {
+		t.Errorf("replayJournal() sent status %q for %q, want %q for op1", state, id, statusFailed)
+	}
+	// The interrupted operation's lock is released, the resumed operation's unexpired lock is restored.
+	if busyKey, ok := g.locker.acquire(ctx, map[string]time.Duration{"ABC": time.Minute, "GHI": time.Minute}); !ok {
+		t.Errorf("replayJournal() kept the lock for %q, want it released", busyKey)
+	}
+	if _, ok := g.locker.acquire(ctx, map[string]time.Duration{"DEF": time.Minute}); ok {
+		t.Errorf("replayJournal() did not restore the lock for DEF")
+	}
+	records, err := readJournal(ctx, path)
+	if err != nil {
+		t.Fatalf("readJournal() returned unexpected error: %v", err)
+	}
+	want := []journalRecord{{OperationID: "op2", State: journalAccepted, Request: agentCommandRequest(t, "sap_status"), Locks: map[string]heldLock{"DEF": {Expiry: future, Shared: true}}}}
+	if diff := cmp.Diff(want, records, cmpopts.IgnoreFields(journalRecord{}, "Time"), cmpopts.EquateApproxTime(0)); diff != "" {
+		t.Errorf("Journal after replayJournal() returned diff (-want +got):\n%s", diff)
+	}
+
+	close(proceed)
+	msg = recorder.next(t)
+	state, _ = statusProgress(t, msg)
+	if id := msg.GetLabels()["operation_id"]; id != "op2" || state != statusSucceeded {
+		t.Errorf("Resumed operation sent status %q for %q, want %q for op2", state, id, statusSucceeded)
+	}
+	waitForJournal(t, path, 0)
+}
+
+// waitForJournal waits until the journal at path has n operations which are not done.
+func waitForJournal(t *testing.T, path string, n int) []journalRecord {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		records, err := readJournal(context.Background(), path)
+		if err != nil {
+			t.Fatalf("readJournal() returned unexpected error: %v", err)
+		}
+		if len(records) == n {
+			return records
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("Journal has %d operations which are not done, want %d", len(records), n)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestConnectionHandlerJournal(t *testing.T) {
+	recorder := newStatusRecorder(t)
+	path := filepath.Join(t.TempDir(), "journal")
+	journal, err := openJournal(path, nil)
+	if err != nil {
+		t.Fatalf("openJournal() returned unexpected error: %v", err)
+	}
+	proceed := make(chan struct{})
+	g := &GuestActions{
+		options: Options{
+			LROHandlers: map[string]GuestActionHandler{
+				"sap_stop": func(ctx context.Context, command *gpb.Command, cp *metadataserver.CloudProperties) *gpb.CommandResult {
+					<-proceed
+					return &gpb.CommandResult{Command: command}
+				},
+			},
+			CommandConcurrencyKey: func(context.Context, *gpb.Command, *metadataserver.CloudProperties) (string, time.Duration, bool) {
+				return "ABC", time.Hour, true
+			},
+		},
+		locker:  newLocker(),
+		journal: journal,
+	}
+	req := &gpb.GuestActionRequest{Commands: []*gpb.Command{
+		{CommandType: &gpb.Command_AgentCommand{AgentCommand: &gpb.AgentCommand{Command: "sap_stop"}}},
+	}}
+	if err := g.connectionHandler(context.Background(), requestMessage(t, "op1", req), nil, nil); err != nil {
+		t.Fatalf("connectionHandler() returned unexpected error: %v", err)
+	}
+	recorder.next(t)
+
+	records := waitForJournal(t, path, 1)
+	if got := records[0]; got.OperationID != "op1" || len(got.Locks) != 1 || got.Locks["ABC"].Expiry.Before(time.Now()) {
+		t.Errorf("Journal record while the operation runs = %+v, want op1 holding ABC", got)
+	}
+	close(proceed)
+	recorder.next(t)
+	waitForJournal(t, path, 0)
+}
+
+func TestReplayJournalKeepsUnsentOperations(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "journal")
+	writeJournal(t, path,
+		journalRecord{OperationID: "op1", State: journalAccepted, Request: agentCommandRequest(t, "sap_stop"), Locks: map[string]heldLock{"ABC": {Expiry: time.Now().Add(time.Hour)}}},
+	)
+	origSendMessage := communication.SendMessage
+	t.Cleanup(func() { communication.SendMessage = origSendMessage })
+	communication.SendMessage = func(*client.Connection, *acpb.MessageBody) error {
+		return fmt.Errorf("not connected")
+	}
+
+	g := &GuestActions{locker: newLocker(), options: Options{JournalFile: path}}
+	replay, err := g.loadJournal(ctx)
+	if err != nil {
+		t.Fatalf("loadJournal() returned unexpected error: %v", err)
+	}
+	g.replayJournal(ctx, replay, nil, nil)
+	g.journal.file.Close()
+
+	records, err := readJournal(ctx, path)
+	if err != nil {
+		t.Fatalf("readJournal() returned unexpected error: %v", err)
+	}
+	want := []journalRecord{{OperationID: "op1", State: journalAccepted, Request: agentCommandRequest(t, "sap_stop")}}
+	if diff := cmp.Diff(want, records, cmpopts.IgnoreFields(journalRecord{}, "Time")); diff != "" {
+		t.Errorf("Journal after a failed replayJournal() returned diff (-want +got):\n%s", diff)
+	}
+
+	// The operation is reported again on the next start.
+	recorder := newStatusRecorder(t)
+	replay, err = g.loadJournal(ctx)
+	if err != nil {
+		t.Fatalf("loadJournal() returned unexpected error: %v", err)
+	}
+	g.replayJournal(ctx, replay, nil, nil)
+	msg := recorder.next(t)
+	if state, _ := statusProgress(t, msg); msg.GetLabels()["operation_id"] != "op1" || state != statusFailed {
+		t.Errorf("replayJournal() sent status %q for %q, want %q for op1", state, msg.GetLabels()["operation_id"], statusFailed)
+	}
+	waitForJournal(t, path, 0)
+}
+
+func TestConnectionHandlerJournalsQueuedOperations(t *testing.T) {
+	ctx := context.Background()
+	recorder := newStatusRecorder(t)
+	path := filepath.Join(t.TempDir(), "journal")
+	journal, err := openJournal(path, nil)
+	if err != nil {
+		t.Fatalf("openJournal() returned unexpected error: %v", err)
+	}
+	g := &GuestActions{
+		options: Options{
+			Handlers: map[string]GuestActionHandler{
+				"sap_status": func(ctx context.Context, command *gpb.Command, cp *metadataserver.CloudProperties) *gpb.CommandResult {
+					return &gpb.CommandResult{Command: command}
+				},
+			},
+			CommandConcurrencyKey: func(context.Context, *gpb.Command, *metadataserver.CloudProperties) (string, time.Duration, bool) {
+				return "ABC", time.Hour, true
+			},
+			LockWaitTimeout: time.Hour,
+		},
+		locker:  newLocker(),
+		journal: journal,
+	}
+	if busyKey, ok := g.locker.acquire(ctx, map[string]time.Duration{"ABC": time.Hour}); !ok {
+		t.Fatalf("acquire() failed for %q", busyKey)
+	}
+	req := &gpb.GuestActionRequest{Commands: []*gpb.Command{
+		{CommandType: &gpb.Command_AgentCommand{AgentCommand: &gpb.AgentCommand{Command: "sap_status"}}},
+	}}
+	if err := g.connectionHandler(ctx, requestMessage(t, "op1", req), nil, nil); err != nil {
+		t.Fatalf("connectionHandler() returned unexpected error: %v", err)
+	}
+	recorder.next(t)
+
+	records := waitForJournal(t, path, 1)
+	if got := records[0]; got.OperationID != "op1" || got.State != journalQueued || got.Locks != nil {
+		t.Errorf("Journal record while the operation waits = %+v, want op1 queued without locks", got)
+	}
+	g.locker.release([]string{"ABC"})
+	msg := recorder.next(t)
+	if state, _ := statusProgress(t, msg); state != statusSucceeded {
+		t.Errorf("Queued operation sent status %q, want %q", state, statusSucceeded)
+	}
+	waitForJournal(t, path, 0)
+}
@@ -0,0 +1,163 @@
+/*
+Copyright 2025 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package guestactions
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"strconv"
+	"unicode/utf8"
+
+	"github.com/GoogleCloudPlatform/agentcommunication_client"
+	"google.golang.org/protobuf/proto"
+	"github.com/GoogleCloudPlatform/workloadagentplatform/sharedlibraries/log"
+
+	anypb "google.golang.org/protobuf/types/known/anypb"
+	acpb "github.com/GoogleCloudPlatform/agentcommunication_client/gapic/agentcommunicationpb"
+	gpb "github.com/GoogleCloudPlatform/workloadagentplatform/sharedprotos/guestactions"
+)
+
+/*
+OutputUploader uploads the output of a command to Cloud Storage, as the object with name under
+the location the uploader is configured with, and returns the gs:// URI of the object.
+*/
+type OutputUploader func(ctx context.Context, name string, data []byte) (string, error)
+
+// OutputOptions limits the size of the output of commands and of the responses sent to ACS.
+type OutputOptions struct {
+	// MaxOutputBytes is the maximum size of the stdout and of the stderr of a command result. Longer
+	// output keeps its head and tail around a truncation marker. 0 means no limit.
+	MaxOutputBytes int
+	// Uploader is optional and uploads the full stdout and stderr of commands which exceed
+	// MaxOutputBytes, returning an OutputReference in the payload of the result. Results in which
+	// the handler set a payload are truncated without being uploaded.
+	Uploader OutputUploader
+	// MaxResponseBytes is the maximum size of the body of a message. A final status which is
+	// larger is sent as a sequence of ResponseChunk messages. 0 means responses are not split.
+	MaxResponseBytes int
+}
+
+/*
+truncateOutput returns s, or the head and tail of s around a marker of the number of bytes
+removed if s is longer than maxBytes. The head and tail are cut at UTF-8 character boundaries.
+*/
+func truncateOutput(s string, maxBytes int) string {
+	if maxBytes <= 0 || len(s) <= maxBytes {
+		return s
+	}
+	head := maxBytes / 2
+	for head > 0 && !utf8.RuneStart(s[head]) {
+		head--
+	}
+	tail := len(s) - (maxBytes - head)
+	for tail < len(s) && !utf8.RuneStart(s[tail]) {
+		tail++
+	}
+	return fmt.Sprintf("%s\n... [%d bytes truncated] ...\n%s", s[:head], tail-head, s[tail:])
+}
+
+/*
+limitOutput truncates the stdout and stderr of the results following Options.Output. If there is
+an Uploader, the full output of a truncated result is uploaded first and referenced in its
+payload. A failed upload is logged and the result is truncated.
+*/
+func (g *GuestActions) limitOutput(ctx context.Context, operationID string, results []*gpb.CommandResult) {
+	opts := g.options.Output
+	if opts.MaxOutputBytes <= 0 {
+		return
+	}
+	for i, r := range results {
+		if len(r.GetStdout()) <= opts.MaxOutputBytes && len(r.GetStderr()) <= opts.MaxOutputBytes {
+			continue
+		}
+		if opts.Uploader != nil && r.GetPayload() == nil {
+			if ref, err := g.uploadOutput(ctx, operationID, i, r); err != nil {
+				log.CtxLogger(ctx).Warnw("Could not upload command output, truncating it", "operation_id", operationID, "command", i, "err", err)
+			} else if r.Payload, err = anypb.New(ref); err != nil {
+				log.CtxLogger(ctx).Warnw("Could not set the output reference payload", "operation_id", operationID, "command", i, "err", err)
+			}
+		}
+		r.Stdout = truncateOutput(r.GetStdout(), opts.MaxOutputBytes)
+		r.Stderr = truncateOutput(r.GetStderr(), opts.MaxOutputBytes)
+	}
+}
+
+// uploadOutput uploads the stdout and stderr of the result which exceed Options.Output.MaxOutputBytes.
+func (g *GuestActions) uploadOutput(ctx context.Context, operationID string, index int, r *gpb.CommandResult) (*gpb.OutputReference, error) {
+	ref := &gpb.OutputReference{StdoutBytes: int64(len(r.GetStdout())), StderrBytes: int64(len(r.GetStderr()))}
+	var err error
+	if len(r.GetStdout()) > g.options.Output.MaxOutputBytes {
+		if ref.StdoutUri, err = g.options.Output.Uploader(ctx, fmt.Sprintf("%s/%d/stdout", operationID, index), []byte(r.GetStdout())); err != nil {
+			return nil, err
+		}
+	}
+	if len(r.GetStderr()) > g.options.Output.MaxOutputBytes {
+		if ref.StderrUri, err = g.options.Output.Uploader(ctx, fmt.Sprintf("%s/%d/stderr", operationID, index), []byte(r.GetStderr())); err != nil {
+			return nil, err
+		}
+	}
+	return ref, nil
+}
+
+/*
+sendChunks sends a final status whose body is larger than Options.Output.MaxResponseBytes as a
+sequence of ResponseChunk messages. Every chunk has "chunk" and "chunks" labels. Only the last
+chunk carries the final status and the "done" lro_state; the chunks before it are "running".
+*/
+func (g *GuestActions) sendChunks(ctx context.Context, operationID string, body *anypb.Any, status string, conn *client.Connection) error {
+	data, err := proto.Marshal(body)
+	if err != nil {
+		return err
+	}
+	// Room is left in each message for the type URL and the other fields of the chunk.
+	empty, err := anypb.New(&gpb.ResponseChunk{Index: math.MaxInt32, Count: math.MaxInt32})
+	if err != nil {
+		return err
+	}
+	size := max(g.options.Output.MaxResponseBytes-proto.Size(empty)-16, 1)
+	count := (len(data) + size - 1) / size
+	log.CtxLogger(ctx).Infow("Sending response in chunks", "operation_id", operationID, "bytes", len(data), "chunks", count)
+	for i := range count {
+		chunk, err := anypb.New(&gpb.ResponseChunk{
+			Index: int32(i),
+			Count: int32(count),
+			Data:  data[i*size : min((i+1)*size, len(data))],
+		})
+		if err != nil {
+			return err
+		}
+		state, lroState := statusRunning, lroStateRunning
+		if i == count-1 {
+			state, lroState = status, lroStateDone
+		}
+		msg := &acpb.MessageBody{
+			Labels: map[string]string{
+				"operation_id": operationID,
+				"state":        state,
+				"lro_state":    lroState,
+				"chunk":        strconv.Itoa(i),
+				"chunks":       strconv.Itoa(count),
+			},
+			Body: chunk,
+		}
+		if err := deliverMessage(ctx, msg, conn); err != nil {
+			return fmt.Errorf("sending chunk %d of %d: %v", i+1, count, err)
+		}
+	}
+	return nil
+}
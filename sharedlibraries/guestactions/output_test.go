@@ -0,0 +1,191 @@
+/*
+Copyright 2025 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package guestactions
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/testing/protocmp"
+	"github.com/GoogleCloudPlatform/workloadagentplatform/sharedlibraries/gce/metadataserver"
+
+	anypb "google.golang.org/protobuf/types/known/anypb"
+	gpb "github.com/GoogleCloudPlatform/workloadagentplatform/sharedprotos/guestactions"
+)
+
+func TestTruncateOutput(t *testing.T) {
+	tests := []struct {
+		name     string
+		s        string
+		maxBytes int
+		want     string
+	}{
+		{name: "NoLimit", s: "0123456789", want: "0123456789"},
+		{name: "WithinLimit", s: "0123456789", maxBytes: 10, want: "0123456789"},
+		{name: "HeadAndTail", s: "0123456789", maxBytes: 4, want: "01\n... [6 bytes truncated] ...\n89"},
+		{name: "OddLimit", s: "0123456789", maxBytes: 5, want: "01\n... [5 bytes truncated] ...\n789"},
+		// "é" is two bytes, which are not split.
+		{name: "CharacterBoundaries", s: "aéééb", maxBytes: 4, want: "a\n... [4 bytes truncated] ...\néb"},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := truncateOutput(tc.s, tc.maxBytes); got != tc.want {
+				t.Errorf("truncateOutput(%q, %d) = %q, want: %q", tc.s, tc.maxBytes, got, tc.want)
+			}
+		})
+	}
+}
+
+func TestLimitOutput(t *testing.T) {
+	ctx := context.Background()
+	long := strings.Repeat("x", 20)
+	truncated := truncateOutput(long, 10)
+	handlerPayload, _ := anypb.New(&gpb.GuestActionError{ErrorMessage: "handler payload"})
+	uploaded := map[string]string{}
+	uploader := func(ctx context.Context, name string, data []byte) (string, error) {
+		uploaded[name] = string(data)
+		return "gs://bucket/" + name, nil
+	}
+	failingUploader := func(ctx context.Context, name string, data []byte) (string, error) {
+		return "", errors.New("upload failed")
+	}
+	reference := func(ref *gpb.OutputReference) *anypb.Any {
+		a, _ := anypb.New(ref)
+		return a
+	}
+
+	tests := []struct {
+		name         string
+		opts         OutputOptions
+		results      []*gpb.CommandResult
+		want         []*gpb.CommandResult
+		wantUploaded map[string]string
+	}{
+		{
+			name:    "NoLimit",
+			results: []*gpb.CommandResult{{Stdout: long}},
+			want:    []*gpb.CommandResult{{Stdout: long}},
+		},
+		{
+			name:    "Truncated",
+			opts:    OutputOptions{MaxOutputBytes: 10},
+			results: []*gpb.CommandResult{{Stdout: "short", Stderr: long}},
+			want:    []*gpb.CommandResult{{Stdout: "short", Stderr: truncated}},
+		},
+		{
+			name:    "Uploaded",
+			opts:    OutputOptions{MaxOutputBytes: 10, Uploader: uploader},
+			results: []*gpb.CommandResult{{Stdout: "short"}, {Stdout: long, Stderr: "err"}},
+			want: []*gpb.CommandResult{
+				{Stdout: "short"},
+				{Stdout: truncated, Stderr: "err", Payload: reference(&gpb.OutputReference{StdoutUri: "gs://bucket/op1/1/stdout", StdoutBytes: 20, StderrBytes: 3})},
+			},
+			wantUploaded: map[string]string{"op1/1/stdout": long},
+		},
+		{
+			name:    "HandlerPayloadKept",
+			opts:    OutputOptions{MaxOutputBytes: 10, Uploader: uploader},
+			results: []*gpb.CommandResult{{Stdout: long, Payload: handlerPayload}},
+			want:    []*gpb.CommandResult{{Stdout: truncated, Payload: handlerPayload}},
+		},
+		{
+			name:    "UploadFailed",
+			opts:    OutputOptions{MaxOutputBytes: 10, Uploader: failingUploader},
+			results: []*gpb.CommandResult{{Stdout: long}},
+			want:    []*gpb.CommandResult{{Stdout: truncated}},
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			clear(uploaded)
+			g := &GuestActions{options: Options{Output: tc.opts}}
+			g.limitOutput(ctx, "op1", tc.results)
+			if diff := cmp.Diff(tc.want, tc.results, protocmp.Transform()); diff != "" {
+				t.Errorf("limitOutput() returned diff (-want +got):\n%s", diff)
+			}
+			if diff := cmp.Diff(tc.wantUploaded, uploaded, cmpopts.EquateEmpty()); diff != "" {
+				t.Errorf("limitOutput() uploaded diff (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestConnectionHandlerChunkedResponse(t *testing.T) {
+	recorder := newStatusRecorder(t)
+	long := strings.Repeat("0123456789", 100)
+	g := &GuestActions{
+		options: Options{
+			Handlers: map[string]GuestActionHandler{
+				"report": func(ctx context.Context, command *gpb.Command, cp *metadataserver.CloudProperties) *gpb.CommandResult {
+					return &gpb.CommandResult{Command: command, Stdout: long}
+				},
+			},
+			Output: OutputOptions{MaxResponseBytes: 300},
+		},
+		locker: newLocker(),
+	}
+	req := &gpb.GuestActionRequest{Commands: []*gpb.Command{
+		{CommandType: &gpb.Command_AgentCommand{AgentCommand: &gpb.AgentCommand{Command: "report"}}},
+	}}
+	if err := g.connectionHandler(context.Background(), requestMessage(t, "op1", req), nil, nil); err != nil {
+		t.Fatalf("connectionHandler() returned unexpected error: %v", err)
+	}
+
+	var data []byte
+	for i := 0; ; i++ {
+		msg := recorder.next(t)
+		if size := proto.Size(msg.GetBody()); size > 300 {
+			t.Errorf("Chunk %d has a body of %d bytes, want at most 300", i, size)
+		}
+		chunk := &gpb.ResponseChunk{}
+		if err := msg.GetBody().UnmarshalTo(chunk); err != nil {
+			t.Fatalf("connectionHandler() sent a message which is not a ResponseChunk: %v", err)
+		}
+		labels := msg.GetLabels()
+		if chunk.GetIndex() != int32(i) || labels["chunk"] != strconv.Itoa(i) || labels["chunks"] != strconv.Itoa(int(chunk.GetCount())) {
+			t.Errorf("Chunk %d has index %d and labels %v, want index %d with matching labels", i, chunk.GetIndex(), labels, i)
+		}
+		data = append(data, chunk.GetData()...)
+		if i == int(chunk.GetCount())-1 {
+			if labels["state"] != statusSucceeded || labels["lro_state"] != lroStateDone {
+				t.Errorf("Last chunk has state %q and lro_state %q, want: %q and %q", labels["state"], labels["lro_state"], statusSucceeded, lroStateDone)
+			}
+			break
+		}
+		if labels["state"] != statusRunning || labels["lro_state"] != lroStateRunning {
+			t.Errorf("Chunk %d has state %q and lro_state %q, want: %q and %q", i, labels["state"], labels["lro_state"], statusRunning, lroStateRunning)
+		}
+	}
+
+	body := &anypb.Any{}
+	if err := proto.Unmarshal(data, body); err != nil {
+		t.Fatalf("proto.Unmarshal() of the chunks failed: %v", err)
+	}
+	gar := &gpb.GuestActionResponse{}
+	if err := body.UnmarshalTo(gar); err != nil {
+		t.Fatalf("UnmarshalTo() of the reassembled response failed: %v", err)
+	}
+	if got := gar.GetCommandResults()[0].GetStdout(); got != long {
+		t.Errorf("Reassembled response stdout has %d bytes, want %d", len(got), len(long))
+	}
+}
@@ -0,0 +1,424 @@
+/*
+Copyright 2025 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package guestactions
+
+import (
+	"fmt"
+	"maps"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+
+	gpb "github.com/GoogleCloudPlatform/workloadagentplatform/sharedprotos/guestactions"
+)
+
+// ParameterType is the type the value of an agent command parameter must parse as.
+type ParameterType int
+
+const (
+	// ParameterString accepts any value. It is the default.
+	ParameterString ParameterType = iota
+	// ParameterInt accepts a base 10 64-bit integer.
+	ParameterInt
+	// ParameterFloat accepts a 64-bit floating point number.
+	ParameterFloat
+	// ParameterBool accepts the values of strconv.ParseBool, such as "true" and "false".
+	ParameterBool
+	// ParameterDuration accepts the values of time.ParseDuration, such as "90s".
+	ParameterDuration
+	// ParameterInt32 accepts a base 10 32-bit integer.
+	ParameterInt32
+	// ParameterUint accepts a base 10 unsigned 64-bit integer.
+	ParameterUint
+	// ParameterUint32 accepts a base 10 unsigned 32-bit integer.
+	ParameterUint32
+	// ParameterFloat32 accepts a 32-bit floating point number.
+	ParameterFloat32
+)
+
+var parameterTypeNames = map[ParameterType]string{
+	ParameterString:   "a string",
+	ParameterInt:      "an integer",
+	ParameterFloat:    "a number",
+	ParameterBool:     "a boolean",
+	ParameterDuration: "a duration",
+	ParameterInt32:    "a 32-bit integer",
+	ParameterUint:     "an unsigned integer",
+	ParameterUint32:   "an unsigned 32-bit integer",
+	ParameterFloat32:  "a 32-bit number",
+}
+
+/*
+integerRanges are the sizes and signedness of the integer parameter types. They are shared by the
+validation of a ParameterSchema and by ParseParameters, so that a schema from ParameterSchemaFor
+accepts the same values as the fields of its message.
+*/
+var integerRanges = map[ParameterType]struct {
+	bits     int
+	unsigned bool
+}{
+	ParameterInt:    {bits: 64},
+	ParameterInt32:  {bits: 32},
+	ParameterUint:   {bits: 64, unsigned: true},
+	ParameterUint32: {bits: 32, unsigned: true},
+}
+
+// integerKinds are the parameter types of the integer kinds of proto fields.
+var integerKinds = map[protoreflect.Kind]ParameterType{
+	protoreflect.Int32Kind:    ParameterInt32,
+	protoreflect.Sint32Kind:   ParameterInt32,
+	protoreflect.Sfixed32Kind: ParameterInt32,
+	protoreflect.Int64Kind:    ParameterInt,
+	protoreflect.Sint64Kind:   ParameterInt,
+	protoreflect.Sfixed64Kind: ParameterInt,
+	protoreflect.Uint32Kind:   ParameterUint32,
+	protoreflect.Fixed32Kind:  ParameterUint32,
+	protoreflect.Uint64Kind:   ParameterUint,
+	protoreflect.Fixed64Kind:  ParameterUint,
+}
+
+// parseInteger parses value as the integer parameter type typ. A signed value is returned in i
+// and an unsigned value in u.
+func parseInteger(typ ParameterType, value string) (i int64, u uint64, err error) {
+	r := integerRanges[typ]
+	if r.unsigned {
+		u, err = strconv.ParseUint(value, 10, r.bits)
+		return 0, u, err
+	}
+	i, err = strconv.ParseInt(value, 10, r.bits)
+	return i, 0, err
+}
+
+type (
+	/*
+		ParameterSchema describes the parameters of an agent command, registered with its handler
+		by Options.AddHandler or Options.AddLROHandler. The parameters of a command are validated against its schema before the
+		handler is called, and the handler receives the parameters with the defaults of the schema
+		applied.
+	*/
+	ParameterSchema struct {
+		// Parameters are the accepted parameters by name.
+		Parameters map[string]ParameterSpec
+		// AllowUnknown accepts parameters which are not in Parameters, they are rejected otherwise.
+		AllowUnknown bool
+	}
+
+	// ParameterSpec describes an agent command parameter.
+	ParameterSpec struct {
+		Type ParameterType
+		// Required parameters must be set, unless they have a Default.
+		Required bool
+		// Default is the value of the parameter if it is not set.
+		Default string
+		// Enum are the only values of the parameter which are accepted, if it is not empty.
+		Enum []string
+	}
+
+	// InvalidParameter is a parameter which does not match its ParameterSpec.
+	InvalidParameter struct {
+		Name   string
+		Reason string
+	}
+
+	// ParameterError is returned for an agent command with invalid parameters. It lists every
+	// invalid parameter, sorted by name.
+	ParameterError struct {
+		Command string
+		Invalid []InvalidParameter
+	}
+)
+
+func (e *ParameterError) Error() string {
+	var reasons []string
+	for _, p := range e.Invalid {
+		reasons = append(reasons, fmt.Sprintf("parameter %q %s", p.Name, p.Reason))
+	}
+	return fmt.Sprintf("invalid parameters for agent command %q: %s", e.Command, strings.Join(reasons, "; "))
+}
+
+/*
+ParameterSchemaFor returns the schema of parameters named after the fields of a proto message,
+such as the message a handler parses its parameters into with ParseParameters. Fields which are
+not scalars are not accepted as parameters. The required fields must be set.
+*/
+func ParameterSchemaFor(m proto.Message, required ...string) ParameterSchema {
+	schema := ParameterSchema{Parameters: make(map[string]ParameterSpec)}
+	fields := m.ProtoReflect().Descriptor().Fields()
+	for i := 0; i < fields.Len(); i++ {
+		fd := fields.Get(i)
+		if fd.IsList() || fd.IsMap() {
+			continue
+		}
+		spec := ParameterSpec{Required: slices.Contains(required, string(fd.Name()))}
+		switch fd.Kind() {
+		case protoreflect.StringKind:
+			spec.Type = ParameterString
+		case protoreflect.BoolKind:
+			spec.Type = ParameterBool
+		case protoreflect.Int32Kind, protoreflect.Sint32Kind, protoreflect.Sfixed32Kind,
+			protoreflect.Int64Kind, protoreflect.Sint64Kind, protoreflect.Sfixed64Kind,
+			protoreflect.Uint32Kind, protoreflect.Fixed32Kind, protoreflect.Uint64Kind, protoreflect.Fixed64Kind:
+			spec.Type = integerKinds[fd.Kind()]
+		case protoreflect.FloatKind:
+			spec.Type = ParameterFloat32
+		case protoreflect.DoubleKind:
+			spec.Type = ParameterFloat
+		case protoreflect.EnumKind:
+			values := fd.Enum().Values()
+			for j := 0; j < values.Len(); j++ {
+				spec.Enum = append(spec.Enum, string(values.Get(j).Name()))
+			}
+		default:
+			continue
+		}
+		schema.Parameters[string(fd.Name())] = spec
+	}
+	return schema
+}
+
+/*
+ParseParameters sets the fields of m named by the parameters of an agent command. It returns a
+*ParameterError listing the parameters which are not scalar fields of m or do not parse as the
+type of their field.
+*/
+func ParseParameters(command *gpb.Command, m proto.Message) error {
+	msg := m.ProtoReflect()
+	fields := msg.Descriptor().Fields()
+	var invalid []InvalidParameter
+	params := command.GetAgentCommand().GetParameters()
+	for _, name := range slices.Sorted(maps.Keys(params)) {
+		fd := fields.ByName(protoreflect.Name(name))
+		if fd == nil || fd.IsList() || fd.IsMap() {
+			invalid = append(invalid, InvalidParameter{Name: name, Reason: "is not a known parameter"})
+			continue
+		}
+		v, err := parseField(fd, params[name])
+		if err != nil {
+			invalid = append(invalid, InvalidParameter{Name: name, Reason: err.Error()})
+			continue
+		}
+		msg.Set(fd, v)
+	}
+	if len(invalid) > 0 {
+		return &ParameterError{Command: command.GetAgentCommand().GetCommand(), Invalid: invalid}
+	}
+	return nil
+}
+
+// parseField parses value as the type of a scalar field.
+func parseField(fd protoreflect.FieldDescriptor, value string) (protoreflect.Value, error) {
+	invalid := func(typ string) (protoreflect.Value, error) {
+		return protoreflect.Value{}, fmt.Errorf("must be %s, got %q", typ, value)
+	}
+	switch fd.Kind() {
+	case protoreflect.StringKind:
+		return protoreflect.ValueOfString(value), nil
+	case protoreflect.BoolKind:
+		if b, err := strconv.ParseBool(value); err == nil {
+			return protoreflect.ValueOfBool(b), nil
+		}
+		return invalid("a boolean")
+	case protoreflect.Int32Kind, protoreflect.Sint32Kind, protoreflect.Sfixed32Kind,
+		protoreflect.Int64Kind, protoreflect.Sint64Kind, protoreflect.Sfixed64Kind,
+		protoreflect.Uint32Kind, protoreflect.Fixed32Kind, protoreflect.Uint64Kind, protoreflect.Fixed64Kind:
+		typ := integerKinds[fd.Kind()]
+		i, u, err := parseInteger(typ, value)
+		if err != nil {
+			return invalid(parameterTypeNames[typ])
+		}
+		switch typ {
+		case ParameterInt32:
+			return protoreflect.ValueOfInt32(int32(i)), nil
+		case ParameterUint32:
+			return protoreflect.ValueOfUint32(uint32(u)), nil
+		case ParameterUint:
+			return protoreflect.ValueOfUint64(u), nil
+		}
+		return protoreflect.ValueOfInt64(i), nil
+	case protoreflect.FloatKind:
+		if f, err := strconv.ParseFloat(value, 32); err == nil {
+			return protoreflect.ValueOfFloat32(float32(f)), nil
+		}
+		return invalid(parameterTypeNames[ParameterFloat32])
+	case protoreflect.DoubleKind:
+		if f, err := strconv.ParseFloat(value, 64); err == nil {
+			return protoreflect.ValueOfFloat64(f), nil
+		}
+		return invalid("a number")
+	case protoreflect.EnumKind:
+		if ev := fd.Enum().Values().ByName(protoreflect.Name(value)); ev != nil {
+			return protoreflect.ValueOfEnum(ev.Number()), nil
+		}
+		return invalid("one of " + enumNames(fd.Enum()))
+	}
+	return protoreflect.Value{}, fmt.Errorf("has unsupported type %s", fd.Kind())
+}
+
+// enumNames returns the names of the values of an enum for error messages.
+func enumNames(ed protoreflect.EnumDescriptor) string {
+	var names []string
+	values := ed.Values()
+	for i := 0; i < values.Len(); i++ {
+		names = append(names, string(values.Get(i).Name()))
+	}
+	return "[" + strings.Join(names, ", ") + "]"
+}
+
+/*
+AddHandler registers handler for the agent command name in Options.Handlers, with the schema of its
+parameters. The parameters of a command are validated against the schema before it is dispatched,
+and a command with invalid parameters fails with a *ParameterError listing every invalid parameter,
+without calling handler. It returns an error if a Default of the schema does not match its spec.
+*/
+func (o *Options) AddHandler(name string, schema ParameterSchema, handler GuestActionHandler) error {
+	if err := o.addParameterSchema(name, schema); err != nil {
+		return err
+	}
+	if o.Handlers == nil {
+		o.Handlers = make(map[string]GuestActionHandler)
+	}
+	o.Handlers[name] = handler
+	return nil
+}
+
+// AddLROHandler registers handler for the agent command name in Options.LROHandlers, with the
+// schema of its parameters, as AddHandler does.
+func (o *Options) AddLROHandler(name string, schema ParameterSchema, handler GuestActionHandler) error {
+	if err := o.addParameterSchema(name, schema); err != nil {
+		return err
+	}
+	if o.LROHandlers == nil {
+		o.LROHandlers = make(map[string]GuestActionHandler)
+	}
+	o.LROHandlers[name] = handler
+	return nil
+}
+
+func (o *Options) addParameterSchema(name string, schema ParameterSchema) error {
+	if err := schema.checkDefaults(name); err != nil {
+		return err
+	}
+	if o.parameterSchemas == nil {
+		o.parameterSchemas = make(map[string]ParameterSchema)
+	}
+	o.parameterSchemas[name] = schema
+	return nil
+}
+
+/*
+validateParameters returns the command with the defaults of the schema registered for it applied.
+The command is returned unchanged if it has no schema, and the request is never modified. It
+returns a *ParameterError if the parameters do not match the schema.
+*/
+func (g *GuestActions) validateParameters(name string, command *gpb.Command) (*gpb.Command, error) {
+	schema, ok := g.options.parameterSchemas[name]
+	if !ok {
+		return command, nil
+	}
+	params, err := schema.validate(command.GetAgentCommand().GetCommand(), command.GetAgentCommand().GetParameters())
+	if err != nil {
+		return nil, err
+	}
+	command = proto.Clone(command).(*gpb.Command)
+	command.GetAgentCommand().Parameters = params
+	return command, nil
+}
+
+// checkDefaults returns a *ParameterError listing the parameters whose Default does not match
+// their spec.
+func (s ParameterSchema) checkDefaults(command string) error {
+	var invalid []InvalidParameter
+	for _, name := range slices.Sorted(maps.Keys(s.Parameters)) {
+		spec := s.Parameters[name]
+		if spec.Default == "" {
+			continue
+		}
+		if reason := spec.check(spec.Default); reason != "" {
+			invalid = append(invalid, InvalidParameter{Name: name, Reason: "has a default which " + reason})
+		}
+	}
+	if len(invalid) > 0 {
+		return &ParameterError{Command: command, Invalid: invalid}
+	}
+	return nil
+}
+
+/*
+validate returns the parameters with the defaults of the schema applied. It returns a
+*ParameterError listing every parameter which is missing, unknown, or does not match its spec.
+*/
+func (s ParameterSchema) validate(command string, params map[string]string) (map[string]string, error) {
+	var invalid []InvalidParameter
+	validated := make(map[string]string, len(params))
+	for name, value := range params {
+		validated[name] = value
+		spec, ok := s.Parameters[name]
+		if !ok {
+			if !s.AllowUnknown {
+				invalid = append(invalid, InvalidParameter{Name: name, Reason: "is not a known parameter"})
+			}
+			continue
+		}
+		if reason := spec.check(value); reason != "" {
+			invalid = append(invalid, InvalidParameter{Name: name, Reason: reason})
+		}
+	}
+	for name, spec := range s.Parameters {
+		if _, ok := params[name]; ok {
+			continue
+		}
+		switch {
+		case spec.Default != "":
+			validated[name] = spec.Default
+		case spec.Required:
+			invalid = append(invalid, InvalidParameter{Name: name, Reason: "is required"})
+		}
+	}
+	if len(invalid) > 0 {
+		slices.SortFunc(invalid, func(a, b InvalidParameter) int { return strings.Compare(a.Name, b.Name) })
+		return nil, &ParameterError{Command: command, Invalid: invalid}
+	}
+	return validated, nil
+}
+
+// check returns why value does not match the spec, or an empty string if it does.
+func (p ParameterSpec) check(value string) string {
+	if len(p.Enum) > 0 && !slices.Contains(p.Enum, value) {
+		return fmt.Sprintf("must be one of [%s], got %q", strings.Join(p.Enum, ", "), value)
+	}
+	var err error
+	switch p.Type {
+	case ParameterInt, ParameterInt32, ParameterUint, ParameterUint32:
+		_, _, err = parseInteger(p.Type, value)
+	case ParameterFloat:
+		_, err = strconv.ParseFloat(value, 64)
+	case ParameterFloat32:
+		_, err = strconv.ParseFloat(value, 32)
+	case ParameterBool:
+		_, err = strconv.ParseBool(value)
+	case ParameterDuration:
+		_, err = time.ParseDuration(value)
+	}
+	if err != nil {
+		return fmt.Sprintf("must be %s, got %q", parameterTypeNames[p.Type], value)
+	}
+	return ""
+}
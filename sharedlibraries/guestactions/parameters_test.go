@@ -0,0 +1,291 @@
+/*
+Copyright 2025 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package guestactions
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"google.golang.org/protobuf/testing/protocmp"
+	"github.com/GoogleCloudPlatform/workloadagentplatform/sharedlibraries/gce/metadataserver"
+
+	gpb "github.com/GoogleCloudPlatform/workloadagentplatform/sharedprotos/guestactions"
+)
+
+var stopSchema = ParameterSchema{
+	Parameters: map[string]ParameterSpec{
+		"sid":     {Required: true},
+		"timeout": {Type: ParameterDuration, Default: "5m"},
+		"force":   {Type: ParameterBool},
+		"retries": {Type: ParameterInt},
+		"scope":   {Enum: []string{"instance", "system"}, Default: "instance"},
+	},
+}
+
+func agentCommandWithParameters(command string, params map[string]string) *gpb.Command {
+	return &gpb.Command{CommandType: &gpb.Command_AgentCommand{AgentCommand: &gpb.AgentCommand{Command: command, Parameters: params}}}
+}
+
+func TestParameterSchemaValidate(t *testing.T) {
+	tests := []struct {
+		name        string
+		schema      ParameterSchema
+		params      map[string]string
+		want        map[string]string
+		wantInvalid []InvalidParameter
+	}{
+		{
+			name:   "DefaultsApplied",
+			schema: stopSchema,
+			params: map[string]string{"sid": "DEV", "force": "true"},
+			want:   map[string]string{"sid": "DEV", "force": "true", "timeout": "5m", "scope": "instance"},
+		},
+		{
+			name:   "EveryInvalidParameterListed",
+			schema: stopSchema,
+			params: map[string]string{"timeout": "soon", "force": "maybe", "retries": "1.5", "scope": "host", "extra": "1"},
+			wantInvalid: []InvalidParameter{
+				{Name: "extra", Reason: "is not a known parameter"},
+				{Name: "force", Reason: `must be a boolean, got "maybe"`},
+				{Name: "retries", Reason: `must be an integer, got "1.5"`},
+				{Name: "scope", Reason: `must be one of [instance, system], got "host"`},
+				{Name: "sid", Reason: "is required"},
+				{Name: "timeout", Reason: `must be a duration, got "soon"`},
+			},
+		},
+		{
+			name: "IntegerRanges",
+			schema: ParameterSchema{Parameters: map[string]ParameterSpec{
+				"int":    {Type: ParameterInt},
+				"int32":  {Type: ParameterInt32},
+				"uint":   {Type: ParameterUint},
+				"uint32": {Type: ParameterUint32},
+			}},
+			params: map[string]string{"int": "-3000000000", "int32": "3000000000", "uint": "-1", "uint32": "5000000000"},
+			wantInvalid: []InvalidParameter{
+				{Name: "int32", Reason: `must be a 32-bit integer, got "3000000000"`},
+				{Name: "uint", Reason: `must be an unsigned integer, got "-1"`},
+				{Name: "uint32", Reason: `must be an unsigned 32-bit integer, got "5000000000"`},
+			},
+		},
+		{
+			name: "FloatRanges",
+			schema: ParameterSchema{Parameters: map[string]ParameterSpec{
+				"float":   {Type: ParameterFloat},
+				"float32": {Type: ParameterFloat32},
+			}},
+			params: map[string]string{"float": "1e39", "float32": "1e39"},
+			wantInvalid: []InvalidParameter{
+				{Name: "float32", Reason: `must be a 32-bit number, got "1e39"`},
+			},
+		},
+		{
+			name:   "AllowUnknown",
+			schema: ParameterSchema{AllowUnknown: true},
+			params: map[string]string{"extra": "1"},
+			want:   map[string]string{"extra": "1"},
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := tc.schema.validate("sap_stop", tc.params)
+			var pe *ParameterError
+			if tc.wantInvalid != nil {
+				if !errors.As(err, &pe) {
+					t.Fatalf("validate() returned error: %v, want a *ParameterError", err)
+				}
+				if diff := cmp.Diff(tc.wantInvalid, pe.Invalid); diff != "" {
+					t.Errorf("validate() returned invalid parameters diff (-want +got):\n%s", diff)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("validate() returned unexpected error: %v", err)
+			}
+			if diff := cmp.Diff(tc.want, got); diff != "" {
+				t.Errorf("validate() returned diff (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestParameterError(t *testing.T) {
+	err := &ParameterError{Command: "sap_stop", Invalid: []InvalidParameter{{Name: "force", Reason: "is required"}, {Name: "sid", Reason: "is required"}}}
+	want := `invalid parameters for agent command "sap_stop": parameter "force" is required; parameter "sid" is required`
+	if got := err.Error(); got != want {
+		t.Errorf("Error() = %q, want: %q", got, want)
+	}
+}
+
+func TestParameterSchemaFor(t *testing.T) {
+	tests := []struct {
+		name     string
+		msg      *gpb.ExecutionOptions
+		required []string
+		want     ParameterSchema
+	}{
+		{
+			name: "ScalarFields",
+			want: ParameterSchema{Parameters: map[string]ParameterSpec{
+				"parallel":          {Type: ParameterBool},
+				"max_concurrency":   {Type: ParameterInt32},
+				"continue_on_error": {Type: ParameterBool},
+			}},
+		},
+		{
+			name:     "Required",
+			required: []string{"parallel"},
+			want: ParameterSchema{Parameters: map[string]ParameterSpec{
+				"parallel":          {Type: ParameterBool, Required: true},
+				"max_concurrency":   {Type: ParameterInt32},
+				"continue_on_error": {Type: ParameterBool},
+			}},
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			got := ParameterSchemaFor(&gpb.ExecutionOptions{}, tc.required...)
+			if diff := cmp.Diff(tc.want, got); diff != "" {
+				t.Errorf("ParameterSchemaFor() returned diff (-want +got):\n%s", diff)
+			}
+		})
+	}
+
+	got := ParameterSchemaFor(&gpb.WorkloadAction{})
+	want := ParameterSchema{Parameters: map[string]ParameterSpec{
+		"sap_workload_action": {Enum: []string{"SAP_WORKLOAD_ACTION_UNSPECIFIED", "SAP_WLM_EVALUATION_FIX", "SAP_START", "SAP_STOP", "SAP_SNOOZE"}},
+	}}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("ParameterSchemaFor(WorkloadAction) returned diff (-want +got):\n%s", diff)
+	}
+}
+
+func TestParseParameters(t *testing.T) {
+	tests := []struct {
+		name        string
+		params      map[string]string
+		want        *gpb.ExecutionOptions
+		wantInvalid []InvalidParameter
+	}{
+		{
+			name:   "Valid",
+			params: map[string]string{"parallel": "true", "max_concurrency": "4"},
+			want:   &gpb.ExecutionOptions{Parallel: true, MaxConcurrency: 4},
+		},
+		{
+			name:   "Invalid",
+			params: map[string]string{"parallel": "yes please", "max_concurrency": "99999999999", "other": "1"},
+			wantInvalid: []InvalidParameter{
+				{Name: "max_concurrency", Reason: `must be a 32-bit integer, got "99999999999"`},
+				{Name: "other", Reason: "is not a known parameter"},
+				{Name: "parallel", Reason: `must be a boolean, got "yes please"`},
+			},
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			got := &gpb.ExecutionOptions{}
+			err := ParseParameters(agentCommandWithParameters("run", tc.params), got)
+			var pe *ParameterError
+			if tc.wantInvalid != nil {
+				if !errors.As(err, &pe) {
+					t.Fatalf("ParseParameters() returned error: %v, want a *ParameterError", err)
+				}
+				if diff := cmp.Diff(tc.wantInvalid, pe.Invalid); diff != "" {
+					t.Errorf("ParseParameters() returned invalid parameters diff (-want +got):\n%s", diff)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ParseParameters() returned unexpected error: %v", err)
+			}
+			if diff := cmp.Diff(tc.want, got, protocmp.Transform()); diff != "" {
+				t.Errorf("ParseParameters() returned diff (-want +got):\n%s", diff)
+			}
+		})
+	}
+
+	action := &gpb.WorkloadAction{}
+	if err := ParseParameters(agentCommandWithParameters("run", map[string]string{"sap_workload_action": "SAP_STOP"}), action); err != nil {
+		t.Fatalf("ParseParameters() returned unexpected error: %v", err)
+	}
+	if got := action.GetSapWorkloadAction(); got != gpb.SapWorkloadAction_SAP_STOP {
+		t.Errorf("ParseParameters() set sap_workload_action = %v, want: SAP_STOP", got)
+	}
+}
+
+func TestAddHandlerInvalidDefaults(t *testing.T) {
+	schema := ParameterSchema{Parameters: map[string]ParameterSpec{
+		"timeout": {Type: ParameterDuration, Default: "soon"},
+		"scope":   {Enum: []string{"instance", "system"}, Default: "host"},
+		"force":   {Type: ParameterBool, Default: "false"},
+	}}
+	opts := Options{}
+	err := opts.AddLROHandler("sap_stop", schema, func(ctx context.Context, command *gpb.Command, cp *metadataserver.CloudProperties) *gpb.CommandResult {
+		return &gpb.CommandResult{Command: command}
+	})
+	var pe *ParameterError
+	if !errors.As(err, &pe) {
+		t.Fatalf("AddLROHandler() returned error: %v, want a *ParameterError", err)
+	}
+	want := []InvalidParameter{
+		{Name: "scope", Reason: `has a default which must be one of [instance, system], got "host"`},
+		{Name: "timeout", Reason: `has a default which must be a duration, got "soon"`},
+	}
+	if diff := cmp.Diff(want, pe.Invalid); diff != "" {
+		t.Errorf("AddLROHandler() returned invalid parameters diff (-want +got):\n%s", diff)
+	}
+	if len(opts.LROHandlers) != 0 || len(opts.parameterSchemas) != 0 {
+		t.Errorf("AddLROHandler() registered a handler with an invalid schema")
+	}
+}
+
+func TestHandleAgentCommandParameters(t *testing.T) {
+	var received map[string]string
+	opts := Options{}
+	if err := opts.AddHandler("sap_stop", stopSchema, func(ctx context.Context, command *gpb.Command, cp *metadataserver.CloudProperties) *gpb.CommandResult {
+		received = command.GetAgentCommand().GetParameters()
+		return &gpb.CommandResult{Command: command}
+	}); err != nil {
+		t.Fatalf("AddHandler() returned unexpected error: %v", err)
+	}
+	g := &GuestActions{options: opts}
+
+	command := agentCommandWithParameters("SAP_STOP", map[string]string{"sid": "DEV"})
+	if got := g.handleAgentCommand(context.Background(), command, nil); got.GetExitCode() != 0 {
+		t.Errorf("handleAgentCommand() returned exit code %d, want: 0", got.GetExitCode())
+	}
+	want := map[string]string{"sid": "DEV", "timeout": "5m", "scope": "instance"}
+	if diff := cmp.Diff(want, received); diff != "" {
+		t.Errorf("handleAgentCommand() called the handler with parameters diff (-want +got):\n%s", diff)
+	}
+	if len(command.GetAgentCommand().GetParameters()) != 1 {
+		t.Errorf("handleAgentCommand() modified the parameters of the request: %v", command.GetAgentCommand().GetParameters())
+	}
+
+	received = nil
+	got := g.handleAgentCommand(context.Background(), agentCommandWithParameters("sap_stop", map[string]string{"force": "maybe"}), nil)
+	wantErr := `invalid parameters for agent command "sap_stop": parameter "force" must be a boolean, got "maybe"; parameter "sid" is required`
+	if got.GetExitCode() != 1 || got.GetStderr() != wantErr {
+		t.Errorf("handleAgentCommand() = exit code %d, stderr %q, want: exit code 1, stderr %q", got.GetExitCode(), got.GetStderr(), wantErr)
+	}
+	if received != nil {
+		t.Errorf("handleAgentCommand() called the handler for a command with invalid parameters")
+	}
+}
@@ -0,0 +1,271 @@
+/*
+Copyright 2025 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package guestactions
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/GoogleCloudPlatform/workloadagentplatform/sharedlibraries/commandlineexecutor"
+	"github.com/GoogleCloudPlatform/workloadagentplatform/sharedlibraries/log"
+)
+
+// PolicyRejectedExitCode is the exit code of a shell command rejected by the shell command policy
+// or by the ShellCommandOptions.
+// It is outside of the exit codes shells reserve for commands which cannot be run or are killed
+// by a signal, so it is not mistaken for one of them.
+const PolicyRejectedExitCode = 100
+
+type (
+	// ShellCommandPolicy restricts the shell commands which guest actions will run.
+	// It is read as JSON from Options.ShellCommandPolicyFile, for example:
+	//
+	// 	{
+	// 	  "allowed_users": ["", "sapadm"],
+	// 	  "allow": [
+	// 	    {"executable": "/usr/bin/systemctl", "args": "(is-active|status) [\\w@.-]+"},
+	// 	    {"executable": "/usr/sap/*/SYS/exe/sapcontrol", "users": ["sapadm"]}
+	// 	  ],
+	// 	  "deny": [
+	// 	    {"executable": "/usr/bin/systemctl", "args": ".*(stop|disable).*"}
+	// 	  ]
+	// 	}
+	//
+	// A command is rejected if it matches any deny rule, if there are allow rules and it matches
+	// none of them, if AllowedUsers is set and does not include the user it runs as, or if its
+	// executable cannot be found.
+	ShellCommandPolicy struct {
+		// AllowedUsers are the users commands may run as. The empty string is the agent's own user.
+		// All users are allowed if AllowedUsers is empty.
+		AllowedUsers []string `json:"allowed_users"`
+		// Allow rules are the only commands permitted to run, unless Allow is empty.
+		Allow []PolicyRule `json:"allow"`
+		// Deny rules are commands which must not run, even if they match an allow rule.
+		Deny []PolicyRule `json:"deny"`
+	}
+
+	// PolicyRule matches shell commands. Every field which is set must match.
+	PolicyRule struct {
+		// Executable is a filepath.Match pattern for the full executable of the command,
+		// such as "/usr/bin/*". The executable of the command is looked up in PATH and cleaned,
+		// and the pattern matches if it matches that path or the path with symbolic links resolved.
+		Executable string `json:"executable"`
+		// Args is a regular expression which must match the whole of the command's arguments.
+		Args string `json:"args"`
+		// Users are the users the command may run as, as in ShellCommandPolicy.AllowedUsers.
+		Users []string `json:"users"`
+	}
+
+	// compiledRule is a PolicyRule with its Args compiled.
+	compiledRule struct {
+		PolicyRule
+		args *regexp.Regexp
+	}
+
+	// shellPolicy enforces the ShellCommandPolicy in a file, reloading it when the file changes.
+	shellPolicy struct {
+		path string
+
+		mu      sync.Mutex
+		modTime time.Time
+		size    int64
+		// loadErr is set if the policy has never been loaded, in which case every command is rejected.
+		loadErr      error
+		allowedUsers []string
+		allow, deny  []compiledRule
+	}
+)
+
+// newShellPolicy returns a shellPolicy which enforces the policy in the file at path.
+func newShellPolicy(ctx context.Context, path string) *shellPolicy {
+	p := &shellPolicy{path: path, loadErr: fmt.Errorf("shell command policy %s has not been loaded", path)}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.reload(ctx)
+	return p
+}
+
+/*
+execute returns a commandlineexecutor.Execute which runs commands with execute if the policy
+allows them. A rejected command is not run and its Result has PolicyRejectedExitCode.
+*/
+func (p *shellPolicy) execute(execute commandlineexecutor.Execute) commandlineexecutor.Execute {
+	return func(ctx context.Context, params commandlineexecutor.Params) commandlineexecutor.Result {
+		if err := p.check(ctx, params); err != nil {
+			log.CtxLogger(ctx).Warnw("Shell command rejected by policy", "executable", params.Executable,
+				"args", params.ArgsToSplit, "user", params.User, "policy", p.path, "reason", err)
+			return commandlineexecutor.Result{
+				StdErr:   fmt.Sprintf("Command rejected by policy: %v", err),
+				ExitCode: PolicyRejectedExitCode,
+				Error:    err,
+			}
+		}
+		return execute(ctx, params)
+	}
+}
+
+// check returns an error describing why the policy rejects the command, or nil if it is allowed.
+func (p *shellPolicy) check(ctx context.Context, params commandlineexecutor.Params) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.reload(ctx)
+	if p.loadErr != nil {
+		return p.loadErr
+	}
+	args := params.ArgsToSplit
+	if args == "" {
+		args = strings.Join(params.Args, " ")
+	}
+	if len(p.allowedUsers) > 0 && !slices.Contains(p.allowedUsers, params.User) {
+		return fmt.Errorf("user %q is not allowed", params.User)
+	}
+	executables, err := resolveExecutable(params.Executable, params.WorkingDir)
+	if err != nil {
+		return fmt.Errorf("command %q cannot be resolved: %w", params.Executable, err)
+	}
+	for _, r := range p.deny {
+		if r.matches(executables, args, params.User) {
+			return fmt.Errorf("command %q matches a deny rule for %q", params.Executable, r.Executable)
+		}
+	}
+	if len(p.allow) == 0 {
+		return nil
+	}
+	for _, r := range p.allow {
+		if r.matches(executables, args, params.User) {
+			return nil
+		}
+	}
+	return fmt.Errorf("command %q with args %q does not match any allow rule", params.Executable, args)
+}
+
+/*
+reload reads the policy file again if it has changed since it was last read. If the file cannot be
+read or is invalid the previously loaded policy is kept. p.mu must be held.
+*/
+func (p *shellPolicy) reload(ctx context.Context) {
+	info, err := os.Stat(p.path)
+	if err != nil {
+		log.CtxLogger(ctx).Warnw("Could not read shell command policy, keeping the current policy", "policy", p.path, "error", err)
+		return
+	}
+	if info.ModTime().Equal(p.modTime) && info.Size() == p.size {
+		return
+	}
+	allowedUsers, allow, deny, err := loadShellPolicy(p.path)
+	if err != nil {
+		log.CtxLogger(ctx).Warnw("Could not load shell command policy, keeping the current policy", "policy", p.path, "error", err)
+		return
+	}
+	p.modTime, p.size = info.ModTime(), info.Size()
+	p.loadErr = nil
+	p.allowedUsers, p.allow, p.deny = allowedUsers, allow, deny
+	log.CtxLogger(ctx).Infow("Loaded shell command policy", "policy", p.path, "allowRules", len(allow), "denyRules", len(deny))
+}
+
+// loadShellPolicy reads and validates the ShellCommandPolicy in the file at path.
+func loadShellPolicy(path string) ([]string, []compiledRule, []compiledRule, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	var policy ShellCommandPolicy
+	if err := json.Unmarshal(data, &policy); err != nil {
+		return nil, nil, nil, fmt.Errorf("invalid shell command policy: %w", err)
+	}
+	allow, err := compileRules(policy.Allow)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	deny, err := compileRules(policy.Deny)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	return policy.AllowedUsers, allow, deny, nil
+}
+
+func compileRules(rules []PolicyRule) ([]compiledRule, error) {
+	var compiled []compiledRule
+	for _, r := range rules {
+		if _, err := filepath.Match(r.Executable, ""); err != nil {
+			return nil, fmt.Errorf("invalid executable pattern %q: %w", r.Executable, err)
+		}
+		c := compiledRule{PolicyRule: r}
+		if r.Args != "" {
+			re, err := regexp.Compile("^(?:" + r.Args + ")$")
+			if err != nil {
+				return nil, fmt.Errorf("invalid args pattern %q: %w", r.Args, err)
+			}
+			c.args = re
+		}
+		compiled = append(compiled, c)
+	}
+	return compiled, nil
+}
+
+/*
+resolveExecutable returns the paths a policy matches the executable of a command against: the
+cleaned absolute path it is found at in PATH, and that path with its symbolic links resolved.
+A relative executable with a directory is relative to the working directory of the command.
+*/
+func resolveExecutable(executable, workingDir string) ([]string, error) {
+	if workingDir != "" && !filepath.IsAbs(executable) && strings.ContainsRune(executable, filepath.Separator) {
+		executable = filepath.Join(workingDir, executable)
+	}
+	path, err := exec.LookPath(executable)
+	if err != nil {
+		return nil, err
+	}
+	if path, err = filepath.Abs(path); err != nil {
+		return nil, err
+	}
+	resolved, err := filepath.EvalSymlinks(path)
+	if err != nil {
+		return nil, err
+	}
+	if resolved == path {
+		return []string{path}, nil
+	}
+	return []string{path, resolved}, nil
+}
+
+// matches returns true if the command matches every field set in the rule. The executables are
+// the paths of the command's executable from resolveExecutable, any of which may match.
+func (r compiledRule) matches(executables []string, args, user string) bool {
+	if r.Executable != "" && !slices.ContainsFunc(executables, func(e string) bool {
+		ok, _ := filepath.Match(r.Executable, e)
+		return ok
+	}) {
+		return false
+	}
+	if r.args != nil && !r.args.MatchString(args) {
+		return false
+	}
+	if len(r.Users) > 0 && !slices.Contains(r.Users, user) {
+		return false
+	}
+	return true
+}
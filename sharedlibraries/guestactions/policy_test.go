@@ -0,0 +1,224 @@
+/*
+Copyright 2025 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package guestactions
+
+import (
+	"context"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"google.golang.org/protobuf/testing/protocmp"
+	"github.com/GoogleCloudPlatform/workloadagentplatform/sharedlibraries/commandlineexecutor"
+
+	gpb "github.com/GoogleCloudPlatform/workloadagentplatform/sharedprotos/guestactions"
+)
+
+// testPolicy is a policy for the executables created by writeExecutables, with $ROOT replaced by
+// their root directory.
+const testPolicy = `{
+  "allowed_users": ["", "sapadm"],
+  "allow": [
+    {"executable": "$ROOT/usr/bin/systemctl", "args": "(is-active|status|stop) [\\w@.-]+"},
+    {"executable": "$ROOT/usr/sap/*/SYS/exe/sapcontrol", "users": ["sapadm"]}
+  ],
+  "deny": [
+    {"executable": "$ROOT/usr/bin/systemctl", "args": "stop .*"}
+  ]
+}`
+
+// writeExecutables creates the executables of testPolicy in a temporary directory with a $ROOT/bin
+// symbolic link to $ROOT/usr/bin, puts $ROOT/usr/bin in PATH and returns the directory.
+func writeExecutables(t *testing.T) string {
+	t.Helper()
+	root := t.TempDir()
+	for _, path := range []string{"usr/bin/systemctl", "usr/bin/rm", "usr/sap/ABC/SYS/exe/sapcontrol"} {
+		path = filepath.Join(root, path)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatalf("os.MkdirAll(%q) failed: %v", filepath.Dir(path), err)
+		}
+		if err := os.WriteFile(path, []byte("#!/bin/sh\n"), 0755); err != nil {
+			t.Fatalf("os.WriteFile(%q) failed: %v", path, err)
+		}
+	}
+	if err := os.Symlink("usr/bin", filepath.Join(root, "bin")); err != nil {
+		t.Fatalf("os.Symlink() failed: %v", err)
+	}
+	t.Setenv("PATH", filepath.Join(root, "usr/bin"))
+	return root
+}
+
+func writePolicy(t *testing.T, path, policy string, modTime time.Time) {
+	t.Helper()
+	if err := os.WriteFile(path, []byte(policy), 0644); err != nil {
+		t.Fatalf("os.WriteFile(%q) failed: %v", path, err)
+	}
+	if err := os.Chtimes(path, modTime, modTime); err != nil {
+		t.Fatalf("os.Chtimes(%q) failed: %v", path, err)
+	}
+}
+
+func TestShellPolicyCheck(t *testing.T) {
+	root := writeExecutables(t)
+	path := filepath.Join(t.TempDir(), "policy.json")
+	writePolicy(t, path, strings.ReplaceAll(testPolicy, "$ROOT", root), time.Now())
+	p := newShellPolicy(context.Background(), path)
+	systemctl := filepath.Join(root, "usr/bin/systemctl")
+	sapcontrol := filepath.Join(root, "usr/sap/ABC/SYS/exe/sapcontrol")
+
+	tests := []struct {
+		name    string
+		params  commandlineexecutor.Params
+		wantErr bool
+	}{
+		{
+			name:   "Allowed",
+			params: commandlineexecutor.Params{Executable: systemctl, ArgsToSplit: "is-active google-cloud-workload-agent.service"},
+		},
+		{
+			name:    "ArgsDoNotMatch",
+			params:  commandlineexecutor.Params{Executable: systemctl, ArgsToSplit: "is-active agent; reboot"},
+			wantErr: true,
+		},
+		{
+			name:    "Denied",
+			params:  commandlineexecutor.Params{Executable: systemctl, ArgsToSplit: "stop sshd"},
+			wantErr: true,
+		},
+		{
+			name:    "ExecutableNotAllowed",
+			params:  commandlineexecutor.Params{Executable: filepath.Join(root, "bin/rm"), ArgsToSplit: "-rf /"},
+			wantErr: true,
+		},
+		{
+			name:   "ExecutableInPath",
+			params: commandlineexecutor.Params{Executable: "systemctl", ArgsToSplit: "status sshd"},
+		},
+		{
+			name:    "DeniedInPath",
+			params:  commandlineexecutor.Params{Executable: "systemctl", ArgsToSplit: "stop sshd"},
+			wantErr: true,
+		},
+		{
+			name:    "DeniedThroughSymlink",
+			params:  commandlineexecutor.Params{Executable: filepath.Join(root, "bin/systemctl"), ArgsToSplit: "stop sshd"},
+			wantErr: true,
+		},
+		{
+			name:    "DeniedWithDotDot",
+			params:  commandlineexecutor.Params{Executable: root + "/usr/bin/../bin/systemctl", ArgsToSplit: "stop sshd"},
+			wantErr: true,
+		},
+		{
+			name:    "DeniedRelativeToWorkingDirectory",
+			params:  commandlineexecutor.Params{Executable: "./systemctl", ArgsToSplit: "stop sshd", WorkingDir: filepath.Join(root, "bin")},
+			wantErr: true,
+		},
+		{
+			name:    "ExecutableNotFound",
+			params:  commandlineexecutor.Params{Executable: filepath.Join(root, "usr/bin/missing"), ArgsToSplit: "status sshd"},
+			wantErr: true,
+		},
+		{
+			name:   "RuleUserAllowed",
+			params: commandlineexecutor.Params{Executable: sapcontrol, Args: []string{"-nr", "00"}, User: "sapadm"},
+		},
+		{
+			name:    "RuleUserNotAllowed",
+			params:  commandlineexecutor.Params{Executable: sapcontrol, Args: []string{"-nr", "00"}},
+			wantErr: true,
+		},
+		{
+			name:    "UserNotAllowed",
+			params:  commandlineexecutor.Params{Executable: systemctl, ArgsToSplit: "status sshd", User: "root"},
+			wantErr: true,
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			err := p.check(context.Background(), tc.params)
+			if gotErr := err != nil; gotErr != tc.wantErr {
+				t.Errorf("check(%+v) returned error: %v, wantErr: %t", tc.params, err, tc.wantErr)
+			}
+		})
+	}
+}
+
+func TestShellPolicyReload(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "policy.json")
+	params := commandlineexecutor.Params{Executable: "/bin/echo", ArgsToSplit: "hello"}
+
+	// A policy which has never been loaded rejects every command.
+	p := newShellPolicy(ctx, path)
+	if err := p.check(ctx, params); err == nil {
+		t.Errorf("check() with a missing policy file returned nil error, want error")
+	}
+
+	modTime := time.Now().Add(-time.Hour)
+	writePolicy(t, path, `{"allow": [{"executable": "/bin/echo"}]}`, modTime)
+	if err := p.check(ctx, params); err != nil {
+		t.Errorf("check() after writing the policy returned error: %v, want nil", err)
+	}
+
+	modTime = modTime.Add(time.Minute)
+	writePolicy(t, path, `{"deny": [{"executable": "/bin/*"}]}`, modTime)
+	if err := p.check(ctx, params); err == nil {
+		t.Errorf("check() after changing the policy returned nil error, want error")
+	}
+
+	// An invalid policy keeps the previous one.
+	modTime = modTime.Add(time.Minute)
+	writePolicy(t, path, `{"allow": [{"args": "("}]}`, modTime)
+	if err := p.check(ctx, params); err == nil {
+		t.Errorf("check() after writing an invalid policy returned nil error, want the previous policy's error")
+	}
+}
+
+func TestHandleShellCommandWithPolicy(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "policy.json")
+	echo, err := exec.LookPath("echo")
+	if err != nil {
+		t.Fatalf("exec.LookPath(echo) failed: %v", err)
+	}
+	writePolicy(t, path, `{"allow": [{"executable": "`+echo+`"}]}`, time.Now())
+	ga := &GuestActions{policy: newShellPolicy(context.Background(), path)}
+
+	command := &gpb.Command{
+		CommandType: &gpb.Command_ShellCommand{ShellCommand: &gpb.ShellCommand{Command: "ls", Args: "/"}},
+	}
+	want := &gpb.CommandResult{
+		Command:  command,
+		Stderr:   `Command rejected by policy: command "ls" with args "/" does not match any allow rule`,
+		ExitCode: PolicyRejectedExitCode,
+	}
+	got := handleShellCommand(context.Background(), command, ShellCommandOptions{}, ga.shellExecutor())
+	if diff := cmp.Diff(want, got, protocmp.Transform()); diff != "" {
+		t.Errorf("handleShellCommand(%v) with policy returned diff (-want +got):\n%s", command, diff)
+	}
+
+	command = &gpb.Command{
+		CommandType: &gpb.Command_ShellCommand{ShellCommand: &gpb.ShellCommand{Command: "echo", Args: "allowed"}},
+	}
+	if got := handleShellCommand(context.Background(), command, ShellCommandOptions{}, ga.shellExecutor()); got.GetStdout() != "allowed\n" {
+		t.Errorf("handleShellCommand(%v) with policy returned stdout: %q, want: %q", command, got.GetStdout(), "allowed\n")
+	}
+}
@@ -0,0 +1,149 @@
+/*
+Copyright 2025 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package guestactions
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/GoogleCloudPlatform/workloadagentplatform/sharedlibraries/log"
+
+	anypb "google.golang.org/protobuf/types/known/anypb"
+	gpb "github.com/GoogleCloudPlatform/workloadagentplatform/sharedprotos/guestactions"
+)
+
+// defaultProgressInterval is the default minimum time between progress messages of an operation.
+const defaultProgressInterval = 30 * time.Second
+
+// Progress is an update on a long-running operation, published with ReportProgress.
+type Progress struct {
+	// PercentComplete is between 0 and 100, values outside the range are clamped.
+	PercentComplete int
+	// Phase names the step the operation is running.
+	Phase string
+	// PartialPayload is an optional result of the operation so far.
+	PartialPayload *anypb.Any
+}
+
+type progressReporterKey struct{}
+
+/*
+progressReporter sends the progress of an operation as "running" status messages, at most once
+per interval. An update reported sooner than that is held back, and replaced by any later update,
+until the interval has passed so the latest progress is always sent.
+*/
+type progressReporter struct {
+	operationID string
+	// sendStatus sends a "running" status message of the operation with body.
+	sendStatus func(ctx context.Context, body *anypb.Any) error
+	interval   time.Duration
+
+	mu       sync.Mutex
+	lastSent time.Time
+	pending  *gpb.OperationProgress
+	timer    *time.Timer
+	stopped  bool
+}
+
+/*
+ReportProgress publishes the progress of the long-running operation a GuestActionHandler in
+Options.LROHandlers is running, using the context the handler was called with. Updates are rate
+limited by Options.ProgressInterval. ReportProgress does nothing for handlers which do not run as
+long-running operations.
+*/
+func ReportProgress(ctx context.Context, p Progress) {
+	r := progressReporterFrom(ctx)
+	if r == nil {
+		log.CtxLogger(ctx).Debugw("Progress reported outside of a long-running operation", "phase", p.Phase, "percentComplete", p.PercentComplete)
+		return
+	}
+	r.report(ctx, &gpb.OperationProgress{
+		PercentComplete: int32(min(max(p.PercentComplete, 0), 100)),
+		Phase:           p.Phase,
+		PartialPayload:  p.PartialPayload,
+	})
+}
+
+// withProgressReporter returns a context in which ReportProgress sends status messages for the
+// operation.
+func withProgressReporter(ctx context.Context, operationID string, sendStatus func(context.Context, *anypb.Any) error, interval time.Duration) context.Context {
+	if interval <= 0 {
+		interval = defaultProgressInterval
+	}
+	r := &progressReporter{operationID: operationID, sendStatus: sendStatus, interval: interval}
+	return context.WithValue(ctx, progressReporterKey{}, r)
+}
+
+// progressReporterFrom returns the progressReporter of the operation running with ctx, or nil.
+func progressReporterFrom(ctx context.Context) *progressReporter {
+	r, _ := ctx.Value(progressReporterKey{}).(*progressReporter)
+	return r
+}
+
+func (r *progressReporter) report(ctx context.Context, progress *gpb.OperationProgress) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.stopped {
+		return
+	}
+	wait := r.interval - time.Since(r.lastSent)
+	if wait <= 0 {
+		r.send(ctx, progress)
+		return
+	}
+	r.pending = progress
+	if r.timer == nil {
+		ctx = context.WithoutCancel(ctx)
+		r.timer = time.AfterFunc(wait, func() {
+			r.mu.Lock()
+			defer r.mu.Unlock()
+			r.timer = nil
+			if r.stopped || r.pending == nil {
+				return
+			}
+			r.send(ctx, r.pending)
+		})
+	}
+}
+
+// send sends progress as a "running" status message. r.mu must be held.
+func (r *progressReporter) send(ctx context.Context, progress *gpb.OperationProgress) {
+	r.pending = nil
+	r.lastSent = time.Now()
+	gar := guestActionResponse(ctx, nil, "")
+	gar.Progress = progress
+	if err := r.sendStatus(ctx, anyResponse(ctx, gar)); err != nil {
+		log.CtxLogger(ctx).Warnw("Failed to send progress", "operation_id", r.operationID, "err", err)
+	}
+}
+
+// stop discards any held back update and stops sending progress, so that no progress message
+// follows the final status of the operation. stop is safe to call on a nil reporter.
+func (r *progressReporter) stop() {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.stopped = true
+	r.pending = nil
+	if r.timer != nil {
+		r.timer.Stop()
+		r.timer = nil
+	}
+}
@@ -0,0 +1,117 @@
+/*
+Copyright 2025 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package guestactions
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"google.golang.org/protobuf/testing/protocmp"
+	"github.com/GoogleCloudPlatform/workloadagentplatform/sharedlibraries/gce/metadataserver"
+
+	anypb "google.golang.org/protobuf/types/known/anypb"
+	acpb "github.com/GoogleCloudPlatform/agentcommunication_client/gapic/agentcommunicationpb"
+	gpb "github.com/GoogleCloudPlatform/workloadagentplatform/sharedprotos/guestactions"
+)
+
+// statusProgress returns the state label and the progress in a status message.
+func statusProgress(t *testing.T, msg *acpb.MessageBody) (string, *gpb.OperationProgress) {
+	t.Helper()
+	resp := &gpb.GuestActionResponse{}
+	if err := msg.GetBody().UnmarshalTo(resp); err != nil {
+		t.Fatalf("Failed to unmarshal response: %v", err)
+	}
+	return msg.GetLabels()["state"], resp.GetProgress()
+}
+
+func TestReportProgress(t *testing.T) {
+	recorder := newStatusRecorder(t)
+	payload, err := anypb.New(&gpb.CommandResult{Stdout: "backed up 3 of 10 volumes"})
+	if err != nil {
+		t.Fatalf("anypb.New() failed: %v", err)
+	}
+	proceed := make(chan struct{})
+	g := &GuestActions{
+		options: Options{
+			LROHandlers: map[string]GuestActionHandler{
+				"hana_backup": func(ctx context.Context, command *gpb.Command, cp *metadataserver.CloudProperties) *gpb.CommandResult {
+					ReportProgress(ctx, Progress{PercentComplete: 30, Phase: "backing up data", PartialPayload: payload})
+					<-proceed
+					// Held back by the rate limit, then replaced by the next update.
+					ReportProgress(ctx, Progress{PercentComplete: 60, Phase: "backing up data"})
+					ReportProgress(ctx, Progress{PercentComplete: 150, Phase: "backing up log"})
+					<-proceed
+					// Discarded, as the operation completes before the interval has passed.
+					ReportProgress(ctx, Progress{PercentComplete: 100, Phase: "done"})
+					return &gpb.CommandResult{Command: command}
+				},
+			},
+			ProgressInterval: time.Second,
+		},
+		locker: newLocker(),
+	}
+
+	req := &gpb.GuestActionRequest{Commands: []*gpb.Command{
+		{CommandType: &gpb.Command_AgentCommand{AgentCommand: &gpb.AgentCommand{Command: "hana_backup"}}},
+	}}
+	if err := g.connectionHandler(context.Background(), requestMessage(t, "op1", req), nil, nil); err != nil {
+		t.Fatalf("connectionHandler() returned unexpected error: %v", err)
+	}
+
+	type status struct {
+		state    string
+		progress *gpb.OperationProgress
+	}
+	var got []status
+	next := func() {
+		state, progress := statusProgress(t, recorder.next(t))
+		got = append(got, status{state, progress})
+	}
+	next() // The initial running status.
+	next()
+	proceed <- struct{}{}
+	next()
+	proceed <- struct{}{}
+	next() // The final status.
+
+	want := []status{
+		{statusRunning, nil},
+		{statusRunning, &gpb.OperationProgress{PercentComplete: 30, Phase: "backing up data", PartialPayload: payload}},
+		{statusRunning, &gpb.OperationProgress{PercentComplete: 100, Phase: "backing up log"}},
+		{statusSucceeded, nil},
+	}
+	if diff := cmp.Diff(want, got, cmp.AllowUnexported(status{}), protocmp.Transform()); diff != "" {
+		t.Errorf("connectionHandler() sent unexpected statuses (-want +got):\n%s", diff)
+	}
+	select {
+	case msg := <-recorder.msgs:
+		t.Errorf("connectionHandler() sent a status after the final status: %v", msg)
+	case <-time.After(300 * time.Millisecond):
+	}
+}
+
+func TestReportProgressWithoutOperation(t *testing.T) {
+	recorder := newStatusRecorder(t)
+	ReportProgress(context.Background(), Progress{PercentComplete: 50})
+	select {
+	case msg := <-recorder.msgs:
+		t.Errorf("ReportProgress() outside an operation sent a status: %v", msg)
+	default:
+	}
+}
@@ -0,0 +1,100 @@
+/*
+Copyright 2025 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package guestactions
+
+import (
+	"context"
+	"fmt"
+
+	"google.golang.org/protobuf/proto"
+	"github.com/GoogleCloudPlatform/workloadagentplatform/sharedlibraries/log"
+
+	anypb "google.golang.org/protobuf/types/known/anypb"
+	gpb "github.com/GoogleCloudPlatform/workloadagentplatform/sharedprotos/guestactions"
+)
+
+/*
+Protocol is the request and response messages of an ACS action service which GuestActions
+dispatches, such as the GCBDR actions of sharedprotos/gcbdractions. The messages must be wire
+compatible with GuestActionRequest and GuestActionResponse: every field they have must have the
+number and type of the corresponding guest actions field. Requests are converted to a
+GuestActionRequest, so that handlers, locking and LRO statuses work the same for every protocol,
+and responses are converted from a GuestActionResponse. Fields the protocol messages do not have
+are dropped.
+
+A nil Protocol is the guest actions protocol.
+*/
+type Protocol struct {
+	request, response proto.Message
+}
+
+// NewProtocol returns the Protocol with request and response messages of the types of request
+// and response.
+func NewProtocol(request, response proto.Message) *Protocol {
+	return &Protocol{request: request, response: response}
+}
+
+// newRequest returns an empty request message of the protocol.
+func (p *Protocol) newRequest() proto.Message {
+	if p == nil {
+		return &gpb.GuestActionRequest{}
+	}
+	return p.request.ProtoReflect().New().Interface()
+}
+
+// parseRequest parses the request message of the protocol in body as a GuestActionRequest.
+func (p *Protocol) parseRequest(ctx context.Context, body *anypb.Any) (*gpb.GuestActionRequest, error) {
+	if p == nil {
+		return parseRequest(ctx, body)
+	}
+	req := p.newRequest()
+	if err := body.UnmarshalTo(req); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal message: %v", err)
+	}
+	gaReq := &gpb.GuestActionRequest{}
+	if err := transcode(req, gaReq); err != nil {
+		return nil, fmt.Errorf("failed to convert %s to a GuestActionRequest: %v", req.ProtoReflect().Descriptor().FullName(), err)
+	}
+	log.CtxLogger(ctx).Debugw("Successfully converted message", "request_type", req.ProtoReflect().Descriptor().FullName())
+	return gaReq, nil
+}
+
+// convertResponse converts the GuestActionResponse in body to the response message of the protocol.
+func (p *Protocol) convertResponse(body *anypb.Any) (*anypb.Any, error) {
+	if p == nil {
+		return body, nil
+	}
+	gar := &gpb.GuestActionResponse{}
+	if err := body.UnmarshalTo(gar); err != nil {
+		return nil, err
+	}
+	resp := p.response.ProtoReflect().New().Interface()
+	if err := transcode(gar, resp); err != nil {
+		return nil, err
+	}
+	return anypb.New(resp)
+}
+
+// transcode sets the fields of to from the fields with the same numbers in from, discarding the
+// fields to does not have.
+func transcode(from, to proto.Message) error {
+	data, err := proto.Marshal(from)
+	if err != nil {
+		return err
+	}
+	return proto.UnmarshalOptions{DiscardUnknown: true}.Unmarshal(data, to)
+}
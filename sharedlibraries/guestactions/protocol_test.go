@@ -0,0 +1,146 @@
+/*
+Copyright 2025 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package guestactions
+
+import (
+	"context"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"google.golang.org/protobuf/testing/protocmp"
+	"github.com/GoogleCloudPlatform/workloadagentplatform/sharedlibraries/gce/metadataserver"
+
+	anypb "google.golang.org/protobuf/types/known/anypb"
+	gapb "github.com/GoogleCloudPlatform/workloadagentplatform/sharedprotos/gcbdractions"
+	gpb "github.com/GoogleCloudPlatform/workloadagentplatform/sharedprotos/guestactions"
+)
+
+var gcbdrProtocol = NewProtocol(&gapb.GCBDRActionRequest{}, &gapb.GCBDRActionResponse{})
+
+func TestProtocolParseRequest(t *testing.T) {
+	ctx := context.Background()
+	tests := []struct {
+		name     string
+		protocol *Protocol
+		msg      *anypb.Any
+		want     *gpb.GuestActionRequest
+		wantErr  bool
+	}{
+		{
+			name:     "GuestActions",
+			protocol: nil,
+			msg: func() *anypb.Any {
+				a, _ := anypb.New(&gpb.GuestActionRequest{Commands: []*gpb.Command{{CommandType: &gpb.Command_AgentCommand{AgentCommand: &gpb.AgentCommand{Command: "version"}}}}})
+				return a
+			}(),
+			want: &gpb.GuestActionRequest{Commands: []*gpb.Command{{CommandType: &gpb.Command_AgentCommand{AgentCommand: &gpb.AgentCommand{Command: "version"}}}}},
+		},
+		{
+			name:     "GCBDRActions",
+			protocol: gcbdrProtocol,
+			msg: func() *anypb.Any {
+				a, _ := anypb.New(&gapb.GCBDRActionRequest{Commands: []*gapb.Command{
+					{CommandType: &gapb.Command_AgentCommand{AgentCommand: &gapb.AgentCommand{Command: "discover", Parameters: map[string]string{"k": "v"}}}},
+					{CommandType: &gapb.Command_ShellCommand{ShellCommand: &gapb.ShellCommand{Command: "ls", Args: "-l", TimeoutSeconds: 5}}},
+				}})
+				return a
+			}(),
+			want: &gpb.GuestActionRequest{Commands: []*gpb.Command{
+				{CommandType: &gpb.Command_AgentCommand{AgentCommand: &gpb.AgentCommand{Command: "discover", Parameters: map[string]string{"k": "v"}}}},
+				{CommandType: &gpb.Command_ShellCommand{ShellCommand: &gpb.ShellCommand{Command: "ls", Args: "-l", TimeoutSeconds: 5}}},
+			}},
+		},
+		{
+			name:     "GuestActionRequestForGCBDRActions",
+			protocol: gcbdrProtocol,
+			msg: func() *anypb.Any {
+				a, _ := anypb.New(&gpb.GuestActionRequest{})
+				return a
+			}(),
+			wantErr: true,
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := tc.protocol.parseRequest(ctx, tc.msg)
+			if gotErr := err != nil; gotErr != tc.wantErr {
+				t.Fatalf("parseRequest() returned error: %v, want error: %t", err, tc.wantErr)
+			}
+			if diff := cmp.Diff(tc.want, got, protocmp.Transform()); diff != "" {
+				t.Errorf("parseRequest() returned diff (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestProtocolConvertResponse(t *testing.T) {
+	ctx := context.Background()
+	gar := guestActionResponse(ctx, []*gpb.CommandResult{{Stdout: "done", ExitCode: 1}}, "failed")
+	gar.Progress = &gpb.OperationProgress{PercentComplete: 50}
+
+	got, err := gcbdrProtocol.convertResponse(anyResponse(ctx, gar))
+	if err != nil {
+		t.Fatalf("convertResponse() returned unexpected error: %v", err)
+	}
+	want, _ := anypb.New(&gapb.GCBDRActionResponse{
+		CommandResults: []*gapb.CommandResult{{Stdout: "done", ExitCode: 1}},
+		Error:          &gapb.GCBDRActionError{ErrorMessage: "failed"},
+	})
+	if diff := cmp.Diff(want, got, protocmp.Transform()); diff != "" {
+		t.Errorf("convertResponse() returned diff (-want +got):\n%s", diff)
+	}
+
+	body := anyResponse(ctx, gar)
+	if got, err := (*Protocol)(nil).convertResponse(body); err != nil || got != body {
+		t.Errorf("convertResponse() of the guest actions protocol = %v, %v, want: the body unchanged", got, err)
+	}
+}
+
+func TestConnectionHandlerProtocol(t *testing.T) {
+	recorder := newStatusRecorder(t)
+	g := &GuestActions{
+		options: Options{
+			LROHandlers: map[string]GuestActionHandler{
+				"gcbdr_action_deep_discovery": func(ctx context.Context, command *gpb.Command, cp *metadataserver.CloudProperties) *gpb.CommandResult {
+					return &gpb.CommandResult{Command: command, Stdout: "discovered"}
+				},
+			},
+			Protocol: gcbdrProtocol,
+		},
+		locker: newLocker(),
+	}
+	req := &gapb.GCBDRActionRequest{Commands: []*gapb.Command{
+		{CommandType: &gapb.Command_AgentCommand{AgentCommand: &gapb.AgentCommand{Command: "gcbdr_action_deep_discovery"}}},
+	}}
+	if err := g.connectionHandler(context.Background(), requestMessage(t, "op1", req), nil, nil); err != nil {
+		t.Fatalf("connectionHandler() returned unexpected error: %v", err)
+	}
+
+	for _, want := range []string{"running/running", "succeeded/done"} {
+		msg := recorder.next(t)
+		if got := msg.GetLabels()["state"] + "/" + msg.GetLabels()["lro_state"]; got != want {
+			t.Errorf("connectionHandler() sent status %q, want: %q", got, want)
+		}
+		resp := &gapb.GCBDRActionResponse{}
+		if err := msg.GetBody().UnmarshalTo(resp); err != nil {
+			t.Fatalf("connectionHandler() sent a status which is not a GCBDRActionResponse: %v", err)
+		}
+		if want == "succeeded/done" && resp.GetCommandResults()[0].GetStdout() != "discovered" {
+			t.Errorf("connectionHandler() sent final response %v, want the result of the handler", resp)
+		}
+	}
+}
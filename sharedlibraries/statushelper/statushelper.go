@@ -22,9 +22,7 @@ import (
 	"context"
 	"fmt"
 	"os"
-	"regexp"
 	"sort"
-	"strconv"
 	"strings"
 	"text/tabwriter"
 
@@ -75,11 +73,7 @@ const (
 	osWindows = "windows"
 )
 
-var (
-	tabWriter         = tabwriter.NewWriter(os.Stdout, 0, 0, 1, ' ', 0)
-	osKernelRegex     = regexp.MustCompile(`^(\d+)\.(\d+)\.(\d+)\.?(\d+)?$`)
-	distroKernelRegex = regexp.MustCompile(`^(\d+)\.(\d+)\.(\d+)\.?(\d+)?[\.-]?(.*)$`)
-)
+var tabWriter = tabwriter.NewWriter(os.Stdout, 0, 0, 1, ' ', 0)
 
 // printColor prints a string with the specified color code.
 func printColor(code colorCode, str string, a ...any) {
@@ -155,49 +149,44 @@ func kernelVersionLinux(ctx context.Context, exec commandlineexecutor.Execute) (
 		return nil, fmt.Errorf("failed to fetch kernel version data: %s", result.Error)
 	}
 
-	result.StdOut = strings.TrimSpace(result.StdOut)
-	version := &spb.KernelVersion{RawString: result.StdOut}
-	parts := strings.SplitN(result.StdOut, "-", 2)
-	if len(parts) != 2 {
+	release, err := result.Value()
+	if err != nil {
+		log.CtxLogger(ctx).Debugw("Failed to parse kernel version data from stdout", "stdout", result.StdOut, "error", err)
+		return &spb.KernelVersion{RawString: strings.TrimSpace(result.StdOut)}, nil
+	}
+	version := &spb.KernelVersion{RawString: release}
+	osRelease, distroRelease, found := strings.Cut(release, "-")
+	if !found {
 		log.CtxLogger(ctx).Debugw("Failed to parse kernel version data from stdout", "stdout", result.StdOut)
 		return version, nil
 	}
 
-	safeAtoi := func(s string) int32 {
-		if s == "" {
-			return 0
-		}
-		// Suppressing the error here as the regex ensures that the string is numeric.
-		number, _ := strconv.Atoi(s)
-		return int32(number)
-	}
-	osKernelMatch := osKernelRegex.FindStringSubmatch(parts[0])
-	if osKernelMatch == nil {
-		log.CtxLogger(ctx).Debugw("failed to parse linux kernel version from stdout", "stdout", result.StdOut)
+	// The linux kernel version is three or four numbers, and the distro kernel version is followed
+	// by the rest of the release.
+	if osKernel, err := commandlineexecutor.ParseVersion(osRelease, 4); err != nil || len(osKernel.Numbers) < 3 || osKernel.Remainder != "" {
+		log.CtxLogger(ctx).Debugw("failed to parse linux kernel version from stdout", "stdout", result.StdOut, "error", err)
 	} else {
-		version.OsKernel = &spb.KernelVersion_Version{
-			Major: safeAtoi(osKernelMatch[1]),
-			Minor: safeAtoi(osKernelMatch[2]),
-			Build: safeAtoi(osKernelMatch[3]),
-			Patch: safeAtoi(osKernelMatch[4]),
-		}
+		version.OsKernel = kernelVersion(osKernel)
 	}
-	distroKernelMatch := distroKernelRegex.FindStringSubmatch(parts[1])
-	if distroKernelMatch == nil {
-		log.CtxLogger(ctx).Debugw("failed to parse distro kernel version from stdout", "stdout", result.StdOut)
+	if distroKernel, err := commandlineexecutor.ParseVersion(distroRelease, 4); err != nil || len(distroKernel.Numbers) < 3 {
+		log.CtxLogger(ctx).Debugw("failed to parse distro kernel version from stdout", "stdout", result.StdOut, "error", err)
 	} else {
-		version.DistroKernel = &spb.KernelVersion_Version{
-			Major:     safeAtoi(distroKernelMatch[1]),
-			Minor:     safeAtoi(distroKernelMatch[2]),
-			Build:     safeAtoi(distroKernelMatch[3]),
-			Patch:     safeAtoi(distroKernelMatch[4]),
-			Remainder: distroKernelMatch[5],
-		}
+		version.DistroKernel = kernelVersion(distroKernel)
+		version.DistroKernel.Remainder = distroKernel.Remainder
 	}
 
 	return version, nil
 }
 
+// kernelVersion returns the major, minor, build and patch numbers of v.
+func kernelVersion(v commandlineexecutor.Version) *spb.KernelVersion_Version {
+	var numbers [4]int32
+	for i := range min(len(v.Numbers), len(numbers)) {
+		numbers[i] = int32(v.Numbers[i])
+	}
+	return &spb.KernelVersion_Version{Major: numbers[0], Minor: numbers[1], Build: numbers[2], Patch: numbers[3]}
+}
+
 // CheckAgentEnabledAndRunning returns the status of the agent service.
 //
 // Returns a tuple as (isEnabled, isRunning, error).
@@ -228,7 +217,7 @@ func agentEnabledAndRunningLinux(ctx context.Context, serviceName string, exec c
 	isEnabled = false
 	// systemctl is-enabled returns 0 for a number of service states, confirm
 	// that the service is actually enabled.
-	if result.ExitCode == 0 && strings.Contains(result.StdOut, "enabled") {
+	if state, err := result.Value(); result.ExitCode == 0 && err == nil && (state == "enabled" || state == "enabled-runtime") {
 		isEnabled = true
 	}
 
@@ -259,11 +248,11 @@ func agentEnabledAndRunningWindows(ctx context.Context, serviceName string, exec
 		Executable:  "Powershell",
 		ArgsToSplit: fmt.Sprintf("(Get-Service -Name '%s' -ErrorAction Ignore).Status", serviceName),
 	})
-	stdOut := strings.TrimSpace(result.StdOut)
-	if stdOut == "Running" {
+	status, err := result.Value()
+	if err == nil && status == "Running" {
 		return true, true, nil
 	}
-	if stdOut == "Stopped" {
+	if err == nil && status == "Stopped" {
 		return false, false, nil
 	}
 	return false, false, fmt.Errorf("could not get the agent service status: %#v", result)
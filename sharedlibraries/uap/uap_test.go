@@ -18,19 +18,17 @@ package uap
 
 import (
 	"context"
-	"fmt"
-	"strings"
+	"errors"
 	"testing"
 	"time"
 
 	apb "google.golang.org/protobuf/types/known/anypb"
-	"github.com/GoogleCloudPlatform/agentcommunication_client"
 	acpb "github.com/GoogleCloudPlatform/agentcommunication_client/gapic/agentcommunicationpb"
+	"github.com/cenkalti/backoff/v4"
 	"github.com/google/go-cmp/cmp"
-	"github.com/google/go-cmp/cmp/cmpopts"
-	"google.golang.org/api/option"
 	"google.golang.org/protobuf/proto"
 	"google.golang.org/protobuf/testing/protocmp"
+	"github.com/GoogleCloudPlatform/workloadagentplatform/sharedlibraries/communication/fakeacs"
 	"github.com/GoogleCloudPlatform/workloadagentplatform/sharedlibraries/gce/metadataserver"
 	gpb "github.com/GoogleCloudPlatform/workloadagentplatform/sharedprotos/guestactions"
 )
@@ -44,298 +42,114 @@ func wrapAny(t *testing.T, m proto.Message) *apb.Any {
 	return any
 }
 
-func TestListenForMessages(t *testing.T) {
-	tests := []struct {
-		name    string
-		want    *acpb.MessageBody
-		receive func(c *client.Connection) (*acpb.MessageBody, error)
-	}{
-		{
-			name: "typical",
-			want: &acpb.MessageBody{
-				Labels: map[string]string{"uap_message_type": "OPERATION_STATUS", "operation_id": "test operation_id", "state": succeeded},
-				Body:   &apb.Any{Value: []byte("typical test body")},
-			},
-			receive: func(c *client.Connection) (*acpb.MessageBody, error) {
-				return &acpb.MessageBody{
-					Labels: map[string]string{"uap_message_type": "OPERATION_STATUS", "operation_id": "test operation_id", "state": succeeded},
-					Body:   &apb.Any{Value: []byte("typical test body")},
-				}, nil
-			},
-		},
-		{
-			name: "emptyBody",
-			want: &acpb.MessageBody{
-				Labels: map[string]string{"uap_message_type": "OPERATION_STATUS", "operation_id": "test operation_id", "state": succeeded},
-				Body:   &apb.Any{},
-			},
-			receive: func(c *client.Connection) (*acpb.MessageBody, error) {
-				return &acpb.MessageBody{
-					Labels: map[string]string{"uap_message_type": "OPERATION_STATUS", "operation_id": "test operation_id", "state": succeeded},
-					Body:   &apb.Any{},
-				}, nil
-			},
-		},
-		{
-			name: "noBody",
-			want: &acpb.MessageBody{
-				Labels: map[string]string{"uap_message_type": "OPERATION_STATUS", "operation_id": "test operation_id", "state": succeeded},
-				Body:   nil,
-			},
-			receive: func(c *client.Connection) (*acpb.MessageBody, error) {
-				return &acpb.MessageBody{
-					Labels: map[string]string{"uap_message_type": "OPERATION_STATUS", "operation_id": "test operation_id", "state": succeeded},
-					Body:   nil,
-				}, nil
-			},
-		},
-		{
-			name: "emptyLabels",
-			want: &acpb.MessageBody{
-				Labels: map[string]string{},
-				Body:   &apb.Any{Value: []byte("emptyLabels test body")},
-			},
-			receive: func(c *client.Connection) (*acpb.MessageBody, error) {
-				return &acpb.MessageBody{
-					Labels: map[string]string{},
-					Body:   &apb.Any{Value: []byte("emptyLabels test body")},
-				}, nil
-			},
-		},
-		{
-			name: "noLabels",
-			want: &acpb.MessageBody{
-				Body: &apb.Any{Value: []byte("noLabels test body")},
-			},
-			receive: func(c *client.Connection) (*acpb.MessageBody, error) {
-				return &acpb.MessageBody{
-					Body: &apb.Any{Value: []byte("noLabels test body")},
-				}, nil
-			},
-		},
-		{
-			name: "noLabels",
-			want: &acpb.MessageBody{
-				Body: &apb.Any{Value: []byte("noLabels test body")},
-			},
-			receive: func(c *client.Connection) (*acpb.MessageBody, error) {
-				return &acpb.MessageBody{
-					Body: &apb.Any{Value: []byte("noLabels test body")},
-				}, nil
-			},
-		},
-		{
-			name: "errorReceivingMessages",
-			want: nil,
-			receive: func(c *client.Connection) (*acpb.MessageBody, error) {
-				return nil, fmt.Errorf("test error")
-			},
-		},
-	}
-
-	ctx := context.Background()
-	conn := &client.Connection{}
-	for _, test := range tests {
-		t.Run(test.name, func(t *testing.T) {
-			receive = test.receive
-			got := listenForMessages(ctx, conn, "testendpoint", "testchannel")
-			if diff := cmp.Diff(test.want, got, protocmp.Transform()); diff != "" {
-				t.Errorf("listenForMessages() returned diff (-want +got):\n%s", diff)
-			}
-		})
-	}
-}
-
-func TestSendStatusMessage(t *testing.T) {
-	tests := []struct {
-		name        string
-		want        error
-		sendMessage func(c *client.Connection, msg *acpb.MessageBody) error
-	}{
-		{
-			name: "typical",
-			want: nil,
-			sendMessage: func(c *client.Connection, msg *acpb.MessageBody) error {
-				return nil
-			},
-		},
-		{
-			name: "errorSendingMessage",
-			want: cmpopts.AnyError,
-			sendMessage: func(c *client.Connection, msg *acpb.MessageBody) error {
-				return fmt.Errorf("test error")
-			},
-		},
-	}
-
-	ctx := context.Background()
-	conn := &client.Connection{}
-	for _, test := range tests {
-		t.Run(test.name, func(t *testing.T) {
-			sendMessage = test.sendMessage
-			got := sendStatusMessage(ctx, "operationID", &apb.Any{Value: []byte("test status body")}, "status", conn)
-			if diff := cmp.Diff(test.want, got, cmpopts.EquateErrors()); diff != "" {
-				t.Errorf("sendStatusMessage() returned diff (-want +got):\n%s", diff)
-			}
-		})
-	}
-}
-
-func TestEstablishConnection(t *testing.T) {
-	tests := []struct {
-		name             string
-		want             *client.Connection
-		createConnection func(ctx context.Context, channel string, regional bool, opts ...option.ClientOption) (*client.Connection, error)
-	}{
-		{
-			name: "typical",
-			want: &client.Connection{},
-			createConnection: func(ctx context.Context, channel string, regional bool, opts ...option.ClientOption) (*client.Connection, error) {
-				return &client.Connection{}, nil
-			},
-		},
-		{
-			name: "error",
-			want: nil,
-			createConnection: func(ctx context.Context, channel string, regional bool, opts ...option.ClientOption) (*client.Connection, error) {
-				return nil, cmpopts.AnyError
+func TestCommunicateWithUAP(t *testing.T) {
+	response := &gpb.GuestActionResponse{CommandResults: []*gpb.CommandResult{{Stdout: "Hello World!"}}}
+	request := &gpb.GuestActionRequest{
+		Commands: []*gpb.Command{
+			{
+				CommandType: &gpb.Command_ShellCommand{
+					ShellCommand: &gpb.ShellCommand{Command: "echo", Args: "Hello World!"},
+				},
 			},
 		},
 	}
-
-	ctx := context.Background()
-	for _, test := range tests {
-		t.Run(test.name, func(t *testing.T) {
-			createConnection = test.createConnection
-			got := establishConnection(ctx, "endpoint", "channel")
-			if diff := cmp.Diff(test.want, got, protocmp.Transform(), cmpopts.IgnoreUnexported(client.Connection{})); diff != "" {
-				t.Errorf("establishConnection() returned diff (-want +got):\n%s", diff)
-			}
-		})
-	}
-}
-
-func TestCommunicateWithUAP(t *testing.T) {
 	tests := []struct {
-		name             string
-		want             string
-		createConnection func(ctx context.Context, channel string, regional bool, opts ...option.ClientOption) (*client.Connection, error)
-		sendMessage      func(c *client.Connection, msg *acpb.MessageBody) error
-		receive          func(c *client.Connection) (*acpb.MessageBody, error)
+		name       string
+		handlerErr error
+		messages   []*acpb.MessageBody
+		want       []*acpb.MessageBody
 	}{
 		{
 			name: "typical",
-			want: "",
-			createConnection: func(ctx context.Context, channel string, regional bool, opts ...option.ClientOption) (*client.Connection, error) {
-				return &client.Connection{}, nil
-			},
-			sendMessage: func(c *client.Connection, msg *acpb.MessageBody) error {
-				return nil
+			messages: []*acpb.MessageBody{
+				{Labels: map[string]string{"uap_message_type": "OPERATION_STATUS", "operation_id": "test operation_id"}, Body: wrapAny(t, request)},
 			},
-			receive: func(c *client.Connection) (*acpb.MessageBody, error) {
-				time.Sleep(5 * time.Second)
-				return &acpb.MessageBody{
-					Labels: map[string]string{"uap_message_type": "OPERATION_STATUS", "operation_id": "test operation_id", "state": succeeded},
-					Body: wrapAny(t, &gpb.GuestActionRequest{
-						Commands: []*gpb.Command{
-							{
-								CommandType: &gpb.Command_ShellCommand{
-									ShellCommand: &gpb.ShellCommand{Command: "echo", Args: "Hello World!"},
-								},
-							},
-						},
-					}),
-				}, nil
+			want: []*acpb.MessageBody{
+				{Labels: map[string]string{"operation_id": "test operation_id", "state": "SUCCEEDED", "lro_state": "done"}, Body: wrapAny(t, response)},
 			},
 		},
 		{
-			name: "sendMessageError",
-			want: "sendMessage error",
-			createConnection: func(ctx context.Context, channel string, regional bool, opts ...option.ClientOption) (*client.Connection, error) {
-				return &client.Connection{}, nil
+			name:       "handlerError",
+			handlerErr: errors.New("handler error"),
+			messages: []*acpb.MessageBody{
+				{Labels: map[string]string{"uap_message_type": "OPERATION_STATUS", "operation_id": "test operation_id"}, Body: wrapAny(t, request)},
 			},
-			sendMessage: func(c *client.Connection, msg *acpb.MessageBody) error {
-				return fmt.Errorf("sendMessage error")
-			},
-			receive: func(c *client.Connection) (*acpb.MessageBody, error) {
-				time.Sleep(5 * time.Second)
-				return &acpb.MessageBody{
-					Labels: map[string]string{"uap_message_type": "OPERATION_STATUS", "operation_id": "test operation_id", "state": succeeded},
-					Body: wrapAny(t, &gpb.GuestActionRequest{
-						Commands: []*gpb.Command{
-							{
-								CommandType: &gpb.Command_ShellCommand{
-									ShellCommand: &gpb.ShellCommand{Command: "echo", Args: "Hello World!"},
-								},
-							},
-						},
-					}),
-				}, nil
+			want: []*acpb.MessageBody{
+				{Labels: map[string]string{"operation_id": "test operation_id", "state": "FAILED", "lro_state": "done"}, Body: wrapAny(t, response)},
 			},
 		},
 		{
-			name: "receiveError",
-			want: "nil labels in message from listenForMessages",
-			createConnection: func(ctx context.Context, channel string, regional bool, opts ...option.ClientOption) (*client.Connection, error) {
-				return &client.Connection{}, nil
-			},
-			sendMessage: func(c *client.Connection, msg *acpb.MessageBody) error {
-				return nil
+			name: "noOperationID",
+			messages: []*acpb.MessageBody{
+				{Labels: map[string]string{"uap_message_type": "OPERATION_STATUS"}, Body: wrapAny(t, request)},
+				{Labels: map[string]string{"uap_message_type": "OPERATION_STATUS", "operation_id": "next operation_id"}, Body: wrapAny(t, request)},
 			},
-			receive: func(c *client.Connection) (*acpb.MessageBody, error) {
-				time.Sleep(5 * time.Second)
-				return nil, fmt.Errorf("receive error")
-			},
-		},
-		{
-			name: "receiveOperationIdError",
-			want: "no operation_id label",
-			createConnection: func(ctx context.Context, channel string, regional bool, opts ...option.ClientOption) (*client.Connection, error) {
-				return &client.Connection{}, nil
-			},
-			sendMessage: func(c *client.Connection, msg *acpb.MessageBody) error {
-				return nil
-			},
-			receive: func(c *client.Connection) (*acpb.MessageBody, error) {
-				time.Sleep(5 * time.Second)
-				return &acpb.MessageBody{
-					Labels: map[string]string{"uap_message_type": "OPERATION_STATUS", "state": succeeded},
-				}, nil
+			want: []*acpb.MessageBody{
+				{Labels: map[string]string{"operation_id": "next operation_id", "state": "SUCCEEDED", "lro_state": "done"}, Body: wrapAny(t, response)},
 			},
 		},
 	}
 
-	for _, test := range tests {
-		t.Run(test.name, func(t *testing.T) {
-			createConnection = test.createConnection
-			sendMessage = test.sendMessage
-			receive = test.receive
-			ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 			defer cancel()
-			got := Communicate(ctx, "endpoint", "channel", func(context.Context, *apb.Any, *metadataserver.CloudProperties) (*apb.Any, error) {
-				return nil, nil
-			}, nil)
-			if got == nil {
-				if test.want != "" {
-					t.Errorf("Communicate() returned nil error, want error: %s", test.want)
-				}
-				return
+			server := fakeacs.New(t)
+			opts := ConnectOptions{
+				Endpoint:      server.Endpoint(),
+				Channel:       "channel",
+				Backoff:       func() backoff.BackOff { return &backoff.ZeroBackOff{} },
+				ClientOptions: server.ClientOptions(),
+			}
+			var received []*apb.Any
+			done := make(chan error)
+			go func() {
+				done <- CommunicateWithOptions(ctx, opts, func(ctx context.Context, body *apb.Any, cp *metadataserver.CloudProperties) (*apb.Any, error) {
+					received = append(received, body)
+					return wrapAny(t, response), tc.handlerErr
+				}, nil)
+			}()
+
+			for _, msg := range tc.messages {
+				server.Send(msg)
 			}
-			if test.want == "" {
-				if got.Error() != "" {
-					t.Errorf("Communicate() returned error: %s, want nil error", got.Error())
+			var got []*acpb.MessageBody
+			for range tc.want {
+				msg, err := server.Next(ctx)
+				if err != nil {
+					t.Fatal(err)
 				}
-				return
+				got = append(got, msg)
 			}
-			gotStr := strings.ReplaceAll(got.Error(), "\u00a0", " ")
-			// Check if the desired error string is contained in the actual error string.
-			if strings.Contains(gotStr, test.want) {
-				return
+			if diff := cmp.Diff(tc.want, got, protocmp.Transform()); diff != "" {
+				t.Errorf("CommunicateWithOptions() sent statuses diff (-want +got):\n%s", diff)
 			}
-			// If the desired error substring is not present, give an error showing the diff.
-			if diff := cmp.Diff(test.want, gotStr, cmpopts.EquateErrors()); diff != "" {
-				t.Errorf("Communicate() returned diff (-want +got):\n%s", diff)
+			cancel()
+			// Unblock the receive of the next message, so that the listener loop sees the cancellation.
+			server.Send(&acpb.MessageBody{Labels: map[string]string{"operation_id": "last"}})
+			<-done
+			if len(received) < len(tc.want) {
+				t.Fatalf("CommunicateWithOptions() called the handler %d times, want at least %d", len(received), len(tc.want))
+			}
+			for _, body := range received[:len(tc.want)] {
+				if diff := cmp.Diff(wrapAny(t, request), body, protocmp.Transform()); diff != "" {
+					t.Errorf("CommunicateWithOptions() called the handler with diff (-want +got):\n%s", diff)
+				}
 			}
 		})
 	}
 }
+
+func TestCommunicateCancelled(t *testing.T) {
+	// The fake serves the metadata the regional endpoint is looked up with.
+	fakeacs.New(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	err := Communicate(ctx, "endpoint", "channel", func(context.Context, *apb.Any, *metadataserver.CloudProperties) (*apb.Any, error) {
+		t.Errorf("Communicate() called the handler without a connection")
+		return nil, nil
+	}, nil)
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("Communicate() with a cancelled context returned error: %v, want: %v", err, context.Canceled)
+	}
+}